@@ -0,0 +1,518 @@
+// Package acme implements the parts of the RFC 8555 ACME protocol
+// required to obtain certificates from LetsEncrypt and compatible CAs
+package acme
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Challenge types
+const (
+	ChallengeHTTP01    = "http-01"
+	ChallengeTLSALPN01 = "tls-alpn-01"
+	ChallengeDNS01     = "dns-01"
+)
+
+// Object states
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+)
+
+const (
+	contentTypeJOSE = "application/jose+json"
+	problemBadNonce = "urn:ietf:params:acme:error:badNonce"
+	maxBodySize     = 1 << 20
+)
+
+// Directory contains the endpoints announced by the ACME server
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	RevokeCert string `json:"revokeCert"`
+	KeyChange  string `json:"keyChange"`
+	Meta       struct {
+		TermsOfService          string `json:"termsOfService"`
+		ExternalAccountRequired bool   `json:"externalAccountRequired"`
+	} `json:"meta"`
+}
+
+// ExternalAccountBinding holds the credentials given by the CA to bind
+// the ACME account to an existing account
+type ExternalAccountBinding struct {
+	KeyID string
+	// HMACKey is the base64url encoded MAC key
+	HMACKey string
+}
+
+// Account represents an ACME account
+type Account struct {
+	URL     string   `json:"-"`
+	Status  string   `json:"status"`
+	Contact []string `json:"contact"`
+}
+
+// Identifier is the subject of an order or authorization
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Order represents a request for a certificate
+type Order struct {
+	URL            string       `json:"-"`
+	Status         string       `json:"status"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate"`
+	Error          *Problem     `json:"error"`
+}
+
+// Authorization represents the proof of control over an identifier
+type Authorization struct {
+	URL        string      `json:"-"`
+	Status     string      `json:"status"`
+	Identifier Identifier  `json:"identifier"`
+	Challenges []Challenge `json:"challenges"`
+	Wildcard   bool        `json:"wildcard"`
+}
+
+// Challenge is one way to prove control over an identifier
+type Challenge struct {
+	Type   string   `json:"type"`
+	URL    string   `json:"url"`
+	Status string   `json:"status"`
+	Token  string   `json:"token"`
+	Error  *Problem `json:"error"`
+}
+
+// Problem is an RFC 7807 error returned by the ACME server
+type Problem struct {
+	Type        string    `json:"type"`
+	Detail      string    `json:"detail"`
+	Status      int       `json:"status"`
+	Subproblems []Problem `json:"subproblems"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("acme: %s (%s)", p.Detail, p.Type)
+}
+
+// Client talks to an ACME server on behalf of one account
+type Client struct {
+	// DirectoryURL is the directory endpoint of the ACME server
+	DirectoryURL string
+	// Key is the account key used to sign all requests
+	Key crypto.Signer
+	// AccountURL is the URL of the registered account, set by Register
+	AccountURL string
+	// HTTPClient is used for all requests to the server
+	HTTPClient *http.Client
+	// PollInterval is the time between two status checks if the server
+	// did not send a Retry-After header
+	PollInterval time.Duration
+	// PollTimeout is the maximum time to wait for a status change
+	PollTimeout time.Duration
+
+	dirLock sync.Mutex
+	dir     *Directory
+
+	nonceLock sync.Mutex
+	nonces    []string
+}
+
+// requestTimeout limits a single request to the ACME server including
+// reading the response body
+const requestTimeout = 30 * time.Second
+
+// NewClient creates a Client for the given directory and account key
+func NewClient(directoryURL string, key crypto.Signer) *Client {
+	return &Client{
+		DirectoryURL: directoryURL,
+		Key:          key,
+		HTTPClient:   &http.Client{Timeout: requestTimeout},
+		PollInterval: time.Second,
+		PollTimeout:  2 * time.Minute,
+	}
+}
+
+// Discover fetches the directory of the ACME server
+func (c *Client) Discover() (*Directory, error) {
+	c.dirLock.Lock()
+	defer c.dirLock.Unlock()
+
+	if c.dir != nil {
+		return c.dir, nil
+	}
+
+	resp, err := c.HTTPClient.Get(c.DirectoryURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	dir := &Directory{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(dir); err != nil {
+		return nil, err
+	}
+	c.dir = dir
+	return dir, nil
+}
+
+// Register creates the account for the key or retrieves the existing
+// one. eab may be nil if the CA does not require external accounts.
+func (c *Client) Register(contact []string, eab *ExternalAccountBinding) (*Account, error) {
+	dir, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	req := map[string]interface{}{
+		"termsOfServiceAgreed": true,
+	}
+	if len(contact) > 0 {
+		req["contact"] = contact
+	}
+	if eab != nil {
+		binding, err := externalAccountBinding(c.Key, eab, dir.NewAccount)
+		if err != nil {
+			return nil, err
+		}
+		req["externalAccountBinding"] = binding
+	} else if dir.Meta.ExternalAccountRequired {
+		return nil, fmt.Errorf("ACME server requires an external account binding")
+	}
+
+	account := &Account{}
+	resp, err := c.post(dir.NewAccount, req, account, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+
+	account.URL = resp.Header.Get("Location")
+	c.AccountURL = account.URL
+	return account, nil
+}
+
+// NewOrder requests a certificate for the given DNS names
+func (c *Client) NewOrder(domains []string) (*Order, error) {
+	dir, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	req := struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}{}
+	for _, domain := range domains {
+		req.Identifiers = append(req.Identifiers, Identifier{Type: "dns", Value: domain})
+	}
+
+	order := &Order{}
+	resp, err := c.post(dir.NewOrder, req, order, http.StatusCreated)
+	if err != nil {
+		return nil, err
+	}
+	order.URL = resp.Header.Get("Location")
+	return order, nil
+}
+
+// GetOrder fetches the current state of the order
+func (c *Client) GetOrder(url string) (*Order, error) {
+	order := &Order{}
+	if _, err := c.post(url, nil, order, http.StatusOK); err != nil {
+		return nil, err
+	}
+	order.URL = url
+	return order, nil
+}
+
+// GetAuthorization fetches the current state of the authorization
+func (c *Client) GetAuthorization(url string) (*Authorization, error) {
+	authz := &Authorization{}
+	if _, err := c.post(url, nil, authz, http.StatusOK); err != nil {
+		return nil, err
+	}
+	authz.URL = url
+	return authz, nil
+}
+
+// AcceptChallenge tells the server the challenge is ready to be validated
+func (c *Client) AcceptChallenge(chal Challenge) error {
+	_, err := c.post(chal.URL, struct{}{}, &Challenge{}, http.StatusOK)
+	return err
+}
+
+// WaitAuthorization polls the authorization until it is no longer pending
+func (c *Client) WaitAuthorization(url string) (*Authorization, error) {
+	deadline := time.Now().Add(c.PollTimeout)
+	for {
+		authz := &Authorization{}
+		resp, err := c.post(url, nil, authz, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		authz.URL = url
+
+		switch authz.Status {
+		case StatusValid:
+			return authz, nil
+		case StatusPending, StatusProcessing:
+		default:
+			for _, chal := range authz.Challenges {
+				if chal.Error != nil {
+					return nil, chal.Error
+				}
+			}
+			return nil, fmt.Errorf("Authorization for %s is %s", authz.Identifier.Value, authz.Status)
+		}
+
+		if err := c.sleep(resp, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Finalize submits the DER encoded CSR and waits for the certificate
+// to be issued
+func (c *Client) Finalize(order *Order, csr []byte) (*Order, error) {
+	req := struct {
+		CSR string `json:"csr"`
+	}{b64(csr)}
+
+	if _, err := c.post(order.Finalize, req, &Order{}, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return c.WaitOrder(order.URL)
+}
+
+// WaitOrder polls the order until it is valid or failed
+func (c *Client) WaitOrder(url string) (*Order, error) {
+	deadline := time.Now().Add(c.PollTimeout)
+	for {
+		order := &Order{}
+		resp, err := c.post(url, nil, order, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		order.URL = url
+
+		switch order.Status {
+		case StatusValid:
+			return order, nil
+		case StatusInvalid:
+			if order.Error != nil {
+				return nil, order.Error
+			}
+			return nil, fmt.Errorf("Order %s is invalid", url)
+		}
+
+		if err := c.sleep(resp, deadline); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// FetchCertificate downloads the issued certificate including the
+// chain provided by the server, the leaf certificate comes first
+func (c *Client) FetchCertificate(url string) ([]*x509.Certificate, error) {
+	resp, err := c.postRaw(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("No certificate found in response")
+	}
+	return chain, nil
+}
+
+// KeyAuthorization builds the key authorization for the challenge token
+func (c *Client) KeyAuthorization(token string) (string, error) {
+	thumb, err := JWKThumbprint(c.Key)
+	if err != nil {
+		return "", err
+	}
+	return token + "." + thumb, nil
+}
+
+// DNS01Value returns the TXT record value for the dns-01 challenge
+func DNS01Value(keyAuth string) string {
+	sum := sha256.Sum256([]byte(keyAuth))
+	return b64(sum[:])
+}
+
+func (c *Client) sleep(resp *http.Response, deadline time.Time) error {
+	wait := c.PollInterval
+	if ra, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && ra > 0 {
+		wait = time.Duration(ra) * time.Second
+	}
+	if time.Now().Add(wait).After(deadline) {
+		return fmt.Errorf("Timed out waiting for status change")
+	}
+	time.Sleep(wait)
+	return nil
+}
+
+func (c *Client) nonce() (string, error) {
+	c.nonceLock.Lock()
+	if len(c.nonces) > 0 {
+		n := c.nonces[len(c.nonces)-1]
+		c.nonces = c.nonces[:len(c.nonces)-1]
+		c.nonceLock.Unlock()
+		return n, nil
+	}
+	c.nonceLock.Unlock()
+
+	dir, err := c.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.HTTPClient.Head(dir.NewNonce)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	n := resp.Header.Get("Replay-Nonce")
+	if n == "" {
+		return "", fmt.Errorf("Server did not return a nonce")
+	}
+	return n, nil
+}
+
+func (c *Client) storeNonce(resp *http.Response) {
+	if n := resp.Header.Get("Replay-Nonce"); n != "" {
+		c.nonceLock.Lock()
+		c.nonces = append(c.nonces, n)
+		c.nonceLock.Unlock()
+	}
+}
+
+// postRaw sends a signed request. A nil payload results in a
+// POST-as-GET request.
+func (c *Client) postRaw(url string, payload interface{}) (*http.Response, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	for retry := 0; ; retry++ {
+		nonce, err := c.nonce()
+		if err != nil {
+			return nil, err
+		}
+
+		msg, err := signJWS(c.Key, c.AccountURL, nonce, url, body)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := c.HTTPClient.Post(url, contentTypeJOSE, bytes.NewReader(msg))
+		if err != nil {
+			return nil, err
+		}
+		c.storeNonce(resp)
+
+		if resp.StatusCode == http.StatusBadRequest && retry == 0 {
+			perr := responseError(resp)
+			if p, ok := perr.(*Problem); ok && p.Type == problemBadNonce {
+				// Nonce expired or was used, try once more with a fresh one
+				continue
+			}
+			return nil, perr
+		}
+
+		return resp, nil
+	}
+}
+
+func (c *Client) post(url string, payload, result interface{}, okStatus ...int) (*http.Response, error) {
+	resp, err := c.postRaw(url, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	ok := false
+	for _, s := range okStatus {
+		ok = ok || resp.StatusCode == s
+	}
+	if !ok {
+		return nil, responseError(resp)
+	}
+
+	if result != nil {
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxBodySize)).Decode(result); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func responseError(resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	resp.Body.Close()
+
+	p := &Problem{}
+	if err := json.Unmarshal(data, p); err != nil || p.Type == "" {
+		return fmt.Errorf("acme: unexpected status %d: %s", resp.StatusCode, string(data))
+	}
+	if p.Status == 0 {
+		p.Status = resp.StatusCode
+	}
+	return p
+}
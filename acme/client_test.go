@@ -0,0 +1,554 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCA is a minimal Pebble-like ACME server used to test the client
+type fakeCA struct {
+	sync.Mutex
+	t   *testing.T
+	srv *httptest.Server
+
+	eabKeyID string
+	eabKey   []byte
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	nonces   map[string]bool
+	accounts map[string]crypto.PublicKey
+	orders   map[string]*Order
+	authzs   map[string]*Authorization
+	certs    map[string][]byte
+	serial   int64
+
+	// validate returns the key authorization presented for the token
+	validate func(typ, domain, token string) string
+}
+
+func newFakeCA(t *testing.T) *fakeCA {
+	ca := &fakeCA{
+		t:        t,
+		nonces:   make(map[string]bool),
+		accounts: make(map[string]crypto.PublicKey),
+		orders:   make(map[string]*Order),
+		authzs:   make(map[string]*Authorization),
+		certs:    make(map[string][]byte),
+	}
+
+	ca.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME Root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &ca.caKey.PublicKey, ca.caKey)
+	if err != nil {
+		t.Fatalf("Unable to create CA: %s", err)
+	}
+	ca.caCert, _ = x509.ParseCertificate(der)
+
+	ca.srv = httptest.NewServer(http.HandlerFunc(ca.handle))
+	return ca
+}
+
+func (ca *fakeCA) url(path string) string { return ca.srv.URL + path }
+
+func (ca *fakeCA) newNonce(res http.ResponseWriter) {
+	ca.Lock()
+	defer ca.Unlock()
+	n := fmt.Sprintf("nonce-%d", len(ca.nonces))
+	ca.nonces[n] = true
+	res.Header().Set("Replay-Nonce", n)
+}
+
+func (ca *fakeCA) problem(res http.ResponseWriter, status int, typ, detail string) {
+	res.Header().Set("Content-Type", "application/problem+json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(Problem{Type: "urn:ietf:params:acme:error:" + typ, Detail: detail, Status: status})
+}
+
+func (ca *fakeCA) handle(res http.ResponseWriter, r *http.Request) {
+	ca.newNonce(res)
+
+	switch {
+	case r.URL.Path == "/dir":
+		dir := Directory{
+			NewNonce:   ca.url("/nonce"),
+			NewAccount: ca.url("/new-account"),
+			NewOrder:   ca.url("/new-order"),
+		}
+		dir.Meta.ExternalAccountRequired = ca.eabKeyID != ""
+		json.NewEncoder(res).Encode(dir)
+		return
+	case r.URL.Path == "/nonce":
+		return
+	}
+
+	if r.Method != "POST" || r.Header.Get("Content-Type") != contentTypeJOSE {
+		ca.problem(res, http.StatusMethodNotAllowed, "malformed", "expected JOSE POST")
+		return
+	}
+
+	header, payload, pub, err := ca.verify(r)
+	if err != nil {
+		if err == errBadNonce {
+			ca.problem(res, http.StatusBadRequest, "badNonce", err.Error())
+			return
+		}
+		ca.problem(res, http.StatusBadRequest, "malformed", err.Error())
+		return
+	}
+
+	ca.Lock()
+	defer ca.Unlock()
+
+	switch {
+	case r.URL.Path == "/new-account":
+		ca.newAccount(res, header, payload, pub)
+	case r.URL.Path == "/new-order":
+		ca.newOrder(res, payload)
+	case strings.HasPrefix(r.URL.Path, "/authz/"):
+		json.NewEncoder(res).Encode(ca.authzs[r.URL.Path])
+	case strings.HasPrefix(r.URL.Path, "/chal/"):
+		ca.acceptChallenge(res, r.URL.Path, pub)
+	case strings.HasPrefix(r.URL.Path, "/order/"):
+		json.NewEncoder(res).Encode(ca.orders[r.URL.Path])
+	case strings.HasPrefix(r.URL.Path, "/finalize/"):
+		ca.finalize(res, strings.Replace(r.URL.Path, "/finalize/", "/order/", 1), payload)
+	case strings.HasPrefix(r.URL.Path, "/cert/"):
+		res.Header().Set("Content-Type", "application/pem-certificate-chain")
+		res.Write(ca.certs[r.URL.Path])
+	default:
+		http.NotFound(res, r)
+	}
+}
+
+var errBadNonce = fmt.Errorf("invalid nonce")
+
+func (ca *fakeCA) verify(r *http.Request) (map[string]json.RawMessage, []byte, crypto.PublicKey, error) {
+	msg := jwsMessage{}
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		return nil, nil, nil, err
+	}
+
+	rawHeader, _ := base64.RawURLEncoding.DecodeString(msg.Protected)
+	header := map[string]json.RawMessage{}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var nonce, url, kid, alg string
+	json.Unmarshal(header["nonce"], &nonce)
+	json.Unmarshal(header["url"], &url)
+	json.Unmarshal(header["kid"], &kid)
+	json.Unmarshal(header["alg"], &alg)
+
+	ca.Lock()
+	validNonce := ca.nonces[nonce]
+	delete(ca.nonces, nonce)
+	pub := ca.accounts[kid]
+	ca.Unlock()
+
+	if !validNonce {
+		return nil, nil, nil, errBadNonce
+	}
+	if url != ca.url(r.URL.Path) {
+		return nil, nil, nil, fmt.Errorf("url mismatch: %s", url)
+	}
+
+	if kid == "" {
+		var err error
+		if pub, err = parseJWK(header["jwk"]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if pub == nil {
+		return nil, nil, nil, fmt.Errorf("unknown account %q", kid)
+	}
+
+	sig, _ := base64.RawURLEncoding.DecodeString(msg.Signature)
+	if err := verifySignature(pub, alg, []byte(msg.Protected+"."+msg.Payload), sig); err != nil {
+		return nil, nil, nil, err
+	}
+
+	payload, _ := base64.RawURLEncoding.DecodeString(msg.Payload)
+	return header, payload, pub, nil
+}
+
+func parseJWK(raw json.RawMessage) (crypto.PublicKey, error) {
+	k := struct{ Kty, Crv, X, Y, N, E string }{}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return nil, err
+	}
+	dec := func(s string) *big.Int {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return new(big.Int).SetBytes(b)
+	}
+
+	switch k.Kty {
+	case "EC":
+		curve := elliptic.P256()
+		if k.Crv == "P-384" {
+			curve = elliptic.P384()
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: dec(k.X), Y: dec(k.Y)}, nil
+	case "RSA":
+		return &rsa.PublicKey{N: dec(k.N), E: int(dec(k.E).Int64())}, nil
+	}
+	return nil, fmt.Errorf("unsupported kty %q", k.Kty)
+}
+
+func verifySignature(pub crypto.PublicKey, alg string, data, sig []byte) error {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		sum := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, sum[:], sig)
+	case *ecdsa.PublicKey:
+		hash := crypto.SHA256
+		if alg == "ES384" {
+			hash = crypto.SHA384
+		}
+		size := len(sig) / 2
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest(hash, data), r, s) {
+			return fmt.Errorf("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key")
+}
+
+func (ca *fakeCA) newAccount(res http.ResponseWriter, header map[string]json.RawMessage, payload []byte, pub crypto.PublicKey) {
+	if _, ok := header["kid"]; ok {
+		ca.problem(res, http.StatusBadRequest, "malformed", "newAccount must use jwk")
+		return
+	}
+
+	req := struct {
+		ExternalAccountBinding *jwsMessage `json:"externalAccountBinding"`
+	}{}
+	json.Unmarshal(payload, &req)
+
+	if ca.eabKeyID != "" {
+		eab := req.ExternalAccountBinding
+		if eab == nil {
+			ca.problem(res, http.StatusUnauthorized, "externalAccountRequired", "missing binding")
+			return
+		}
+		mac := hmac.New(sha256.New, ca.eabKey)
+		mac.Write([]byte(eab.Protected + "." + eab.Payload))
+		sig, _ := base64.RawURLEncoding.DecodeString(eab.Signature)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			ca.problem(res, http.StatusUnauthorized, "unauthorized", "invalid binding")
+			return
+		}
+	}
+
+	for kid, existing := range ca.accounts {
+		if fmt.Sprint(existing) == fmt.Sprint(pub) {
+			res.Header().Set("Location", kid)
+			json.NewEncoder(res).Encode(Account{Status: StatusValid})
+			return
+		}
+	}
+
+	kid := ca.url(fmt.Sprintf("/account/%d", len(ca.accounts)))
+	ca.accounts[kid] = pub
+	res.Header().Set("Location", kid)
+	res.WriteHeader(http.StatusCreated)
+	json.NewEncoder(res).Encode(Account{Status: StatusValid})
+}
+
+func (ca *fakeCA) newOrder(res http.ResponseWriter, payload []byte) {
+	req := Order{}
+	json.Unmarshal(payload, &req)
+
+	id := len(ca.orders)
+	order := &Order{
+		Status:      StatusPending,
+		Identifiers: req.Identifiers,
+		Finalize:    ca.url(fmt.Sprintf("/finalize/%d", id)),
+	}
+	for i, ident := range req.Identifiers {
+		path := fmt.Sprintf("/authz/%d-%d", id, i)
+		authz := &Authorization{Status: StatusPending, Identifier: ident}
+		if strings.HasPrefix(ident.Value, "*.") {
+			authz.Identifier.Value = ident.Value[2:]
+			authz.Wildcard = true
+		}
+		for _, typ := range []string{ChallengeHTTP01, ChallengeDNS01} {
+			authz.Challenges = append(authz.Challenges, Challenge{
+				Type:   typ,
+				URL:    ca.url(fmt.Sprintf("/chal/%d-%d-%s", id, i, typ)),
+				Status: StatusPending,
+				Token:  fmt.Sprintf("token-%d-%d", id, i),
+			})
+		}
+		ca.authzs[path] = authz
+		order.Authorizations = append(order.Authorizations, ca.url(path))
+	}
+	ca.orders[fmt.Sprintf("/order/%d", id)] = order
+
+	res.Header().Set("Location", ca.url(fmt.Sprintf("/order/%d", id)))
+	res.WriteHeader(http.StatusCreated)
+	json.NewEncoder(res).Encode(order)
+}
+
+func (ca *fakeCA) acceptChallenge(res http.ResponseWriter, path string, pub crypto.PublicKey) {
+	parts := strings.SplitN(strings.TrimPrefix(path, "/chal/"), "-", 3)
+	authz := ca.authzs["/authz/"+parts[0]+"-"+parts[1]]
+
+	for i := range authz.Challenges {
+		chal := &authz.Challenges[i]
+		if chal.Type != parts[2] {
+			continue
+		}
+
+		signer := pubSigner{pub}
+		thumb, _ := JWKThumbprint(signer)
+		expected := chal.Token + "." + thumb
+		if chal.Type == ChallengeDNS01 {
+			expected = DNS01Value(expected)
+		}
+
+		chal.Status = StatusValid
+		authz.Status = StatusValid
+		if ca.validate(chal.Type, authz.Identifier.Value, chal.Token) != expected {
+			chal.Status = StatusInvalid
+			chal.Error = &Problem{Type: "urn:ietf:params:acme:error:unauthorized", Detail: "key authorization mismatch"}
+			authz.Status = StatusInvalid
+		}
+		json.NewEncoder(res).Encode(chal)
+		return
+	}
+	http.NotFound(res, nil)
+}
+
+func (ca *fakeCA) finalize(res http.ResponseWriter, orderPath string, payload []byte) {
+	order := ca.orders[orderPath]
+	for _, u := range order.Authorizations {
+		if ca.authzs[strings.TrimPrefix(u, ca.srv.URL)].Status != StatusValid {
+			ca.problem(res, http.StatusForbidden, "orderNotReady", "authorizations pending")
+			return
+		}
+	}
+
+	req := struct{ CSR string }{}
+	json.Unmarshal(payload, &req)
+	der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		ca.problem(res, http.StatusBadRequest, "badCSR", err.Error())
+		return
+	}
+
+	ca.serial++
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial + 1),
+		Subject:      pkix.Name{CommonName: csr.DNSNames[0]},
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, tpl, ca.caCert, csr.PublicKey, ca.caKey)
+	if err != nil {
+		ca.problem(res, http.StatusInternalServerError, "serverInternal", err.Error())
+		return
+	}
+
+	certPath := strings.Replace(orderPath, "/order/", "/cert/", 1)
+	ca.certs[certPath] = append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.caCert.Raw})...,
+	)
+	order.Status = StatusValid
+	order.Certificate = ca.url(certPath)
+	json.NewEncoder(res).Encode(order)
+}
+
+// pubSigner wraps a public key to compute its thumbprint
+type pubSigner struct{ pub crypto.PublicKey }
+
+func (p pubSigner) Public() crypto.PublicKey { return p.pub }
+func (p pubSigner) Sign(_ io.Reader, _ []byte, _ crypto.SignerOpts) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+type memorySolver struct {
+	typ  string
+	data map[string]string
+}
+
+func (m *memorySolver) Type() string { return m.typ }
+
+func (m *memorySolver) Present(domain, token, keyAuth string) error {
+	if m.typ == ChallengeDNS01 {
+		keyAuth = DNS01Value(keyAuth)
+	}
+	m.data[domain+"|"+token] = keyAuth
+	return nil
+}
+
+func (m *memorySolver) CleanUp(domain, token, keyAuth string) error {
+	delete(m.data, domain+"|"+token)
+	return nil
+}
+
+func createCSR(t *testing.T, domains []string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		t.Fatalf("Unable to create CSR: %s", err)
+	}
+	return csr
+}
+
+func testObtain(t *testing.T, key crypto.Signer, solverType string, domains []string) {
+	ca := newFakeCA(t)
+	defer ca.srv.Close()
+
+	solver := &memorySolver{typ: solverType, data: make(map[string]string)}
+	ca.validate = func(typ, domain, token string) string {
+		if typ != solverType {
+			return ""
+		}
+		for k, v := range solver.data {
+			if strings.HasSuffix(k, "|"+token) && strings.TrimPrefix(strings.Split(k, "|")[0], "*.") == domain {
+				return v
+			}
+		}
+		return ""
+	}
+
+	c := NewClient(ca.url("/dir"), key)
+	c.PollInterval = 10 * time.Millisecond
+	if _, err := c.Register([]string{"mailto:admin@example.com"}, nil); err != nil {
+		t.Fatalf("Unable to register: %s", err)
+	}
+	if !strings.HasPrefix(c.AccountURL, ca.url("/account/")) {
+		t.Fatalf("Unexpected account URL %q", c.AccountURL)
+	}
+
+	chain, err := c.ObtainCertificate(domains, createCSR(t, domains), []Solver{solver})
+	if err != nil {
+		t.Fatalf("Unable to obtain certificate: %s", err)
+	}
+
+	if len(chain) != 2 {
+		t.Fatalf("Expected leaf and issuer in chain, got %d certificates", len(chain))
+	}
+	if strings.Join(chain[0].DNSNames, ",") != strings.Join(domains, ",") {
+		t.Errorf("Unexpected names in certificate: %v", chain[0].DNSNames)
+	}
+	if chain[1].Subject.CommonName != "Fake ACME Root" {
+		t.Errorf("Chain does not contain the issuer: %s", chain[1].Subject.CommonName)
+	}
+	if len(solver.data) != 0 {
+		t.Errorf("Solver was not cleaned up: %v", solver.data)
+	}
+}
+
+func TestObtainCertificateECDSA(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testObtain(t, key, ChallengeHTTP01, []string{"example.com", "www.example.com"})
+}
+
+func TestObtainCertificateRSA(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	testObtain(t, key, ChallengeHTTP01, []string{"example.com"})
+}
+
+func TestObtainCertificateWildcardDNS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	testObtain(t, key, ChallengeDNS01, []string{"*.example.com"})
+}
+
+func TestInvalidKeyAuthorization(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.srv.Close()
+	ca.validate = func(typ, domain, token string) string { return "wrong" }
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := NewClient(ca.url("/dir"), key)
+	c.PollInterval = 10 * time.Millisecond
+	if _, err := c.Register(nil, nil); err != nil {
+		t.Fatalf("Unable to register: %s", err)
+	}
+
+	solver := &memorySolver{typ: ChallengeHTTP01, data: make(map[string]string)}
+	_, err := c.ObtainCertificate([]string{"example.com"}, createCSR(t, []string{"example.com"}), []Solver{solver})
+	if p, ok := err.(*Problem); !ok || !strings.HasSuffix(p.Type, ":unauthorized") {
+		t.Errorf("Expected unauthorized problem, got %v", err)
+	}
+}
+
+func TestExternalAccountBinding(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.srv.Close()
+	ca.eabKeyID = "kid-1"
+	ca.eabKey = []byte("supersecretmackey")
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := NewClient(ca.url("/dir"), key)
+
+	if _, err := c.Register(nil, nil); err == nil {
+		t.Fatalf("Registration without binding succeeded")
+	}
+
+	if _, err := c.Register(nil, &ExternalAccountBinding{KeyID: "kid-1", HMACKey: b64([]byte("wrong"))}); err == nil {
+		t.Fatalf("Registration with wrong binding succeeded")
+	}
+
+	if _, err := c.Register(nil, &ExternalAccountBinding{KeyID: "kid-1", HMACKey: b64(ca.eabKey)}); err != nil {
+		t.Fatalf("Registration with binding failed: %s", err)
+	}
+
+	// Registering again must return the existing account
+	first := c.AccountURL
+	c.AccountURL = ""
+	if _, err := c.Register(nil, &ExternalAccountBinding{KeyID: "kid-1", HMACKey: b64(ca.eabKey)}); err != nil || c.AccountURL != first {
+		t.Errorf("Existing account not returned: %q != %q (%v)", c.AccountURL, first, err)
+	}
+}
+
+func TestBadNonceRetry(t *testing.T) {
+	ca := newFakeCA(t)
+	defer ca.srv.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := NewClient(ca.url("/dir"), key)
+	c.nonces = []string{"stale"}
+
+	if _, err := c.Register(nil, nil); err != nil {
+		t.Fatalf("Stale nonce was not retried: %s", err)
+	}
+}
@@ -0,0 +1,182 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+type jwsMessage struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// jwk returns the public key of the signer as JSON web key with its
+// members in lexicographic order as required for the thumbprint
+func jwk(key crypto.Signer) (string, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`,
+			b64(big.NewInt(int64(pub.E)).Bytes()),
+			b64(pub.N.Bytes()),
+		), nil
+
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`,
+			pub.Curve.Params().Name,
+			b64(padBytes(pub.X.Bytes(), size)),
+			b64(padBytes(pub.Y.Bytes(), size)),
+		), nil
+
+	default:
+		return "", fmt.Errorf("Unsupported key type %T", pub)
+	}
+}
+
+// JWKThumbprint calculates the RFC 7638 thumbprint of the public key
+func JWKThumbprint(key crypto.Signer) (string, error) {
+	k, err := jwk(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(k))
+	return b64(sum[:]), nil
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func jwsAlgorithm(key crypto.Signer) (string, crypto.Hash, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		return "RS256", crypto.SHA256, nil
+	case *ecdsa.PublicKey:
+		switch pub.Curve.Params().BitSize {
+		case 256:
+			return "ES256", crypto.SHA256, nil
+		case 384:
+			return "ES384", crypto.SHA384, nil
+		}
+	}
+	return "", 0, fmt.Errorf("Unsupported key type %T", key.Public())
+}
+
+func digest(hash crypto.Hash, data []byte) []byte {
+	switch hash {
+	case crypto.SHA384:
+		sum := sha512.Sum384(data)
+		return sum[:]
+	default:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	}
+}
+
+// signJWS creates a flattened JWS of the payload. If kid is empty the
+// public key is embedded into the protected header.
+func signJWS(key crypto.Signer, kid, nonce, url string, payload []byte) ([]byte, error) {
+	alg, hash, err := jwsAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := map[string]interface{}{
+		"alg":   alg,
+		"nonce": nonce,
+		"url":   url,
+	}
+	if kid != "" {
+		header["kid"] = kid
+	} else {
+		k, err := jwk(key)
+		if err != nil {
+			return nil, err
+		}
+		header["jwk"] = json.RawMessage(k)
+	}
+
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := jwsMessage{
+		Protected: b64(protected),
+		Payload:   b64(payload),
+	}
+
+	sig, err := sign(key, hash, []byte(msg.Protected+"."+msg.Payload))
+	if err != nil {
+		return nil, err
+	}
+	msg.Signature = b64(sig)
+
+	return json.Marshal(msg)
+}
+
+func sign(key crypto.Signer, hash crypto.Hash, data []byte) ([]byte, error) {
+	d := digest(hash, data)
+
+	if k, ok := key.(*ecdsa.PrivateKey); ok {
+		// JWS requires the raw r||s format instead of ASN.1
+		r, s, err := ecdsa.Sign(rand.Reader, k, d)
+		if err != nil {
+			return nil, err
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		return append(padBytes(r.Bytes(), size), padBytes(s.Bytes(), size)...), nil
+	}
+
+	return key.Sign(rand.Reader, d, hash)
+}
+
+// externalAccountBinding creates the RFC 8555 section 7.3.4 binding of
+// the account key to the external account
+func externalAccountBinding(key crypto.Signer, eab *ExternalAccountBinding, url string) (json.RawMessage, error) {
+	macKey, err := base64.RawURLEncoding.DecodeString(eab.HMACKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid EAB HMAC key: %s", err)
+	}
+
+	k, err := jwk(key)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := json.Marshal(map[string]string{
+		"alg": "HS256",
+		"kid": eab.KeyID,
+		"url": url,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	msg := jwsMessage{
+		Protected: b64(protected),
+		Payload:   b64([]byte(k)),
+	}
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write([]byte(msg.Protected + "." + msg.Payload))
+	msg.Signature = b64(mac.Sum(nil))
+
+	return json.Marshal(msg)
+}
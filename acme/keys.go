@@ -0,0 +1,71 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+// KeyType is the algorithm and size of a generated key
+type KeyType string
+
+// Supported key types for accounts and certificates
+const (
+	KeyP256    KeyType = "P-256"
+	KeyP384    KeyType = "P-384"
+	KeyRSA2048 KeyType = "RSA-2048"
+	KeyRSA4096 KeyType = "RSA-4096"
+)
+
+// ParseKeyType validates the name of a key type, the comparison is case
+// insensitive
+func ParseKeyType(name string) (KeyType, error) {
+	for _, kt := range []KeyType{KeyP256, KeyP384, KeyRSA2048, KeyRSA4096} {
+		if strings.EqualFold(name, string(kt)) {
+			return kt, nil
+		}
+	}
+	return "", fmt.Errorf("Unsupported key type %q", name)
+}
+
+// GenerateKey creates a new private key of the type
+func GenerateKey(kt KeyType) (crypto.Signer, error) {
+	switch kt {
+	case KeyP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("Unsupported key type %q", kt)
+	}
+}
+
+// KeyTypeOf returns the type of an existing key or an empty string if
+// the key does not match a supported type
+func KeyTypeOf(key crypto.PublicKey) KeyType {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch pub.N.BitLen() {
+		case 2048:
+			return KeyRSA2048
+		case 4096:
+			return KeyRSA4096
+		}
+	case *ecdsa.PublicKey:
+		switch pub.Curve {
+		case elliptic.P256():
+			return KeyP256
+		case elliptic.P384():
+			return KeyP384
+		}
+	}
+	return ""
+}
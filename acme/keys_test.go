@@ -0,0 +1,27 @@
+package acme
+
+import "testing"
+
+func TestGenerateKey(t *testing.T) {
+	for _, name := range []string{"p-256", "P-384", "rsa-2048"} {
+		kt, err := ParseKeyType(name)
+		if err != nil {
+			t.Fatalf("Unable to parse key type %q: %s", name, err)
+		}
+
+		key, err := GenerateKey(kt)
+		if err != nil {
+			t.Fatalf("Unable to generate %s key: %s", kt, err)
+		}
+		if got := KeyTypeOf(key.Public()); got != kt {
+			t.Errorf("Generated %s key has type %q", kt, got)
+		}
+		if _, _, err := jwsAlgorithm(key); err != nil {
+			t.Errorf("%s key cannot sign requests: %s", kt, err)
+		}
+	}
+
+	if _, err := ParseKeyType("ed448"); err == nil {
+		t.Errorf("Unsupported key type was accepted")
+	}
+}
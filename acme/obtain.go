@@ -0,0 +1,93 @@
+package acme
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+)
+
+// Solver fulfills one type of challenge
+type Solver interface {
+	// Type returns the challenge type handled by the solver
+	Type() string
+	// Present makes the key authorization available to the CA
+	Present(domain, token, keyAuth string) error
+	// CleanUp removes the data created by Present
+	CleanUp(domain, token, keyAuth string) error
+}
+
+// DomainFilter can be implemented by a Solver only able to fulfill
+// challenges for some of the domains
+type DomainFilter interface {
+	Accepts(domain string) bool
+}
+
+// ObtainCertificate orders a certificate for the domains, solves all
+// pending authorizations with the first matching solver and returns
+// the issued certificate chain
+func (c *Client) ObtainCertificate(domains []string, csr []byte, solvers []Solver) ([]*x509.Certificate, error) {
+	order, err := c.NewOrder(domains)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, authzURL := range order.Authorizations {
+		if err := c.authorize(authzURL, solvers); err != nil {
+			return nil, err
+		}
+	}
+
+	if order, err = c.Finalize(order, csr); err != nil {
+		return nil, err
+	}
+
+	return c.FetchCertificate(order.Certificate)
+}
+
+func (c *Client) authorize(url string, solvers []Solver) error {
+	authz, err := c.GetAuthorization(url)
+	if err != nil {
+		return err
+	}
+	if authz.Status == StatusValid {
+		return nil
+	}
+
+	domain := authz.Identifier.Value
+	if authz.Wildcard && !strings.HasPrefix(domain, "*.") {
+		domain = "*." + domain
+	}
+
+	for _, solver := range solvers {
+		if f, ok := solver.(DomainFilter); ok && !f.Accepts(domain) {
+			continue
+		}
+
+		for _, chal := range authz.Challenges {
+			if chal.Type != solver.Type() {
+				continue
+			}
+
+			keyAuth, err := c.KeyAuthorization(chal.Token)
+			if err != nil {
+				return err
+			}
+
+			// Clean up even if presenting failed as it might have been
+			// done partially
+			defer solver.CleanUp(domain, chal.Token, keyAuth)
+			if err := solver.Present(domain, chal.Token, keyAuth); err != nil {
+				return fmt.Errorf("Unable to present %s challenge for %s: %s", chal.Type, domain, err)
+			}
+
+			if err := c.AcceptChallenge(chal); err != nil {
+				return err
+			}
+
+			_, err = c.WaitAuthorization(url)
+			return err
+		}
+	}
+
+	return fmt.Errorf("No supported challenge for %s", domain)
+}
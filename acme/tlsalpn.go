@@ -0,0 +1,67 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+)
+
+// ALPNProto is the ALPN protocol used by the CA to validate tls-alpn-01
+// challenges (RFC 8737)
+const ALPNProto = "acme-tls/1"
+
+// idPeACMEIdentifier is the OID of the acmeIdentifier extension
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPN01ChallengeCert creates the self-signed certificate to be
+// served for the domain on handshakes negotiating ALPNProto
+func TLSALPN01ChallengeCert(domain, keyAuth string) (*tls.Certificate, error) {
+	sum := sha256.Sum256([]byte(keyAuth))
+	extValue, err := asn1.Marshal(sum[:])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "ACME challenge"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		DNSNames:     []string{domain},
+		ExtraExtensions: []pkix.Extension{
+			{Id: idPeACMEIdentifier, Critical: true, Value: extValue},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
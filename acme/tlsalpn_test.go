@@ -0,0 +1,35 @@
+package acme
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"testing"
+)
+
+func TestTLSALPN01ChallengeCert(t *testing.T) {
+	cert, err := TLSALPN01ChallengeCert("example.com", "token.thumb")
+	if err != nil {
+		t.Fatalf("Unable to create challenge certificate: %s", err)
+	}
+
+	if len(cert.Leaf.DNSNames) != 1 || cert.Leaf.DNSNames[0] != "example.com" {
+		t.Errorf("Unexpected names: %v", cert.Leaf.DNSNames)
+	}
+
+	sum := sha256.Sum256([]byte("token.thumb"))
+	for _, ext := range cert.Leaf.Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+		if !ext.Critical {
+			t.Errorf("acmeIdentifier extension is not critical")
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(ext.Value, &value); err != nil || !bytes.Equal(value, sum[:]) {
+			t.Errorf("Unexpected extension value: %x (%v)", value, err)
+		}
+		return
+	}
+	t.Errorf("acmeIdentifier extension missing")
+}
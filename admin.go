@@ -0,0 +1,231 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Luzifer/dockerproxy/health"
+	"github.com/gorilla/mux"
+)
+
+const redactedValue = "<redacted>"
+
+// drainedBackends contains backends manually taken out of rotation
+var drainedBackends = struct {
+	sync.RWMutex
+	backends map[string]bool
+}{backends: make(map[string]bool)}
+
+func isDrained(slug, address string) bool {
+	drainedBackends.RLock()
+	defer drainedBackends.RUnlock()
+	return drainedBackends.backends[slug+"|"+address]
+}
+
+func setDrained(slug, address string, drained bool) {
+	drainedBackends.Lock()
+	defer drainedBackends.Unlock()
+	if drained {
+		drainedBackends.backends[slug+"|"+address] = true
+	} else {
+		delete(drainedBackends.backends, slug+"|"+address)
+	}
+}
+
+type adminBackendStatus struct {
+	Slug    string         `json:"slug"`
+	Address string         `json:"address"`
+	Healthy bool           `json:"healthy"`
+	Ejected bool           `json:"ejected"`
+	Drained bool           `json:"drained"`
+	Check   *health.Status `json:"check,omitempty"`
+}
+
+// registerAdminAPI adds the admin endpoints to the router if the admin
+// API has an authentication method configured
+func registerAdminAPI(r *mux.Router) {
+	config := proxyConfiguration()
+	if config.Admin.Token == "" && config.Admin.ClientCA == "" {
+		return
+	}
+
+	api := r.PathPrefix("/api").Subrouter()
+	api.HandleFunc("/config", adminGetConfig).Methods("GET")
+	api.HandleFunc("/config/reload", adminReloadConfig).Methods("POST")
+	api.HandleFunc("/containers", adminGetContainers).Methods("GET")
+	api.HandleFunc("/docker/hosts", adminGetDockerHosts).Methods("GET")
+	api.HandleFunc("/backends", adminGetBackends).Methods("GET")
+	api.HandleFunc("/backends/{slug}/{address}/drain", adminDrainBackend).Methods("POST", "DELETE")
+	api.HandleFunc("/certificates", adminGetCertificates).Methods("GET")
+	api.HandleFunc("/certificates/renew", adminRenewCertificates).Methods("POST")
+	api.HandleFunc("/certificates/renewal", adminGetRenewal).Methods("GET")
+	api.HandleFunc("/challenges", adminGetChallenges).Methods("GET")
+}
+
+// adminAuth protects the admin API by a bearer token or a verified
+// client certificate
+func adminAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(res, r)
+			return
+		}
+
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			h.ServeHTTP(res, r)
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		config := proxyConfiguration()
+		if config.Admin.Token != "" && strings.HasPrefix(auth, "Bearer ") &&
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(config.Admin.Token)) == 1 {
+			h.ServeHTTP(res, r)
+			return
+		}
+
+		res.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(res, "Unauthorized.", http.StatusUnauthorized)
+	})
+}
+
+// adminTLSConfig builds the TLS configuration for the metrics listener
+// if client certificate authentication is configured
+func adminTLSConfig() (*tls.Config, error) {
+	config := proxyConfiguration()
+	if config.Admin.ClientCA == "" {
+		return nil, nil
+	}
+
+	caPEM, err := ioutil.ReadFile(config.Admin.ClientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("No certificates found in %s", config.Admin.ClientCA)
+	}
+
+	return &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  pool,
+	}, nil
+}
+
+func writeJSON(res http.ResponseWriter, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(res)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("[Admin] Unable to encode response: %s", err)
+	}
+}
+
+func adminGetConfig(res http.ResponseWriter, r *http.Request) {
+	config := proxyConfiguration()
+	// Copy the config to not leak secrets
+	redacted := *config
+	redacted.Admin.Token = redactedValue
+	redacted.Domains = make(map[string]domainConfig, len(config.Domains))
+	for domain, domainCFG := range config.Domains {
+		if domainCFG.Authentication.Config != nil {
+			domainCFG.Authentication.Config = redactedValue
+		}
+		if domainCFG.Sticky != nil {
+			sticky := *domainCFG.Sticky
+			sticky.Secret = redactedValue
+			domainCFG.Sticky = &sticky
+		}
+		redacted.Domains[domain] = domainCFG
+	}
+	redacted.DNSProviders = make(map[string]dnsProvider, len(config.DNSProviders))
+	for name, provider := range config.DNSProviders {
+		if provider.Config != nil {
+			provider.Config = redactedValue
+		}
+		redacted.DNSProviders[name] = provider
+	}
+
+	writeJSON(res, redacted)
+}
+
+func adminReloadConfig(res http.ResponseWriter, r *http.Request) {
+	if err := reloadConfiguration(); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func adminGetContainers(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, collectDockerContainer())
+}
+
+func adminGetDockerHosts(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, dockerDiscovery.Status())
+}
+
+func adminGetBackends(res http.ResponseWriter, r *http.Request) {
+	checks := make(map[string]health.Status)
+	for _, s := range healthChecker.Status() {
+		checks[s.Slug+"|"+s.Address] = s
+	}
+
+	result := []adminBackendStatus{}
+	for slug, addresses := range *collectDockerContainer() {
+		for _, address := range addresses {
+			status := adminBackendStatus{
+				Slug:    slug,
+				Address: address,
+				Healthy: healthChecker.Healthy(slug, address),
+				Ejected: outliers.Ejected(slug, address),
+				Drained: isDrained(slug, address),
+			}
+			if check, ok := checks[slug+"|"+address]; ok {
+				status.Check = &check
+			}
+			result = append(result, status)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Slug != result[j].Slug {
+			return result[i].Slug < result[j].Slug
+		}
+		return result[i].Address < result[j].Address
+	})
+
+	writeJSON(res, result)
+}
+
+func adminDrainBackend(res http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	setDrained(vars["slug"], vars["address"], r.Method == "POST")
+	res.WriteHeader(http.StatusNoContent)
+}
+
+func adminGetCertificates(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, sniServer.LoadedCertificates())
+}
+
+func adminGetRenewal(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, certRenewal.Status())
+}
+
+func adminRenewCertificates(res http.ResponseWriter, r *http.Request) {
+	leClient.DropCachedCertificates()
+	certRenewal.Trigger()
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func adminGetChallenges(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, leClient.Challenges())
+}
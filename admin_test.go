@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// loadTestConfig sets the global configuration from the YAML document
+func loadTestConfig(t *testing.T, config string) {
+	dir, err := ioutil.TempDir("", "dockerproxy")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	configFile := path.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatalf("Unable to write config: %s", err)
+	}
+	proxyCFG, err := newProxyConfig(configFile)
+	if err != nil {
+		t.Fatalf("Unable to load config: %s", err)
+	}
+	setProxyConfiguration(proxyCFG)
+}
+
+func TestAdminGetConfigRedactsSecrets(t *testing.T) {
+	loadTestConfig(t, `---
+admin:
+  token: admin-token-secret
+domains:
+  example.com:
+    slug: app
+    authentication:
+      type: basic-auth
+      config:
+        alice: basic-auth-secret
+dns_providers:
+  nameserver:
+    type: rfc2136
+    config:
+      nameserver: ns1.example.com
+      zone: example.com.
+      tsig_key: acme
+      tsig_secret: dHNpZy1zZWNyZXQ=
+  hook:
+    type: webhook
+    config:
+      url: https://dns.example.com/hook
+      headers:
+        Authorization: Bearer webhook-secret
+`)
+
+	rec := httptest.NewRecorder()
+	adminGetConfig(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+		t.Fatalf("Unexpected response %d: %q", rec.Code, rec.Body.String())
+	}
+	for _, secret := range []string{"admin-token-secret", "basic-auth-secret", "dHNpZy1zZWNyZXQ=", "webhook-secret"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("Secret %q is contained in the response", secret)
+		}
+	}
+
+	var body struct {
+		DNSProviders map[string]dnsProvider `json:"dns_providers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Unable to decode response: %s", err)
+	}
+	if p := body.DNSProviders["nameserver"]; p.Type != "rfc2136" || p.Config != redactedValue {
+		t.Errorf("Unexpected DNS provider in response: %#v", p)
+	}
+}
+
+func TestAdminAuthRequiresBearerScheme(t *testing.T) {
+	loadTestConfig(t, `---
+admin:
+  token: admin-token-secret
+`)
+
+	h := adminAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for header, expected := range map[string]int{
+		"Bearer admin-token-secret": http.StatusOK,
+		"admin-token-secret":        http.StatusUnauthorized,
+		"Basic admin-token-secret":  http.StatusUnauthorized,
+		"Bearer other-token":        http.StatusUnauthorized,
+		"":                          http.StatusUnauthorized,
+	} {
+		r := httptest.NewRequest("GET", "http://127.0.0.1:9000/api/backends", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != expected {
+			t.Errorf("%q: expected status %d, got %d", header, expected, w.Code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package balancer
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// WeightLabel is the container label defining the weight of a backend
+// for the weighted strategy
+const WeightLabel = "io.luzifer.dockerproxy.weight"
+
+// Backend is one of the targets the balancer can choose from
+type Backend struct {
+	Address string
+	Weight  int
+}
+
+// Balancer selects the backend to handle a request
+type Balancer interface {
+	// Pick chooses one of the given backends for the request. The
+	// returned function must be called after the request finished.
+	Pick(r *http.Request, backends []Backend) (Backend, func())
+}
+
+// Config selects the strategy to use
+type Config struct {
+	// Strategy is one of random, round_robin, least_conn, weighted or hash
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// HashBy is the key for the hash strategy: ip, header:<name> or cookie:<name>
+	HashBy string `json:"hash_by,omitempty" yaml:"hash_by,omitempty"`
+}
+
+// New creates a balancer for the given configuration
+func New(cfg Config) (Balancer, error) {
+	switch cfg.Strategy {
+	case "", "random":
+		return newRandom(), nil
+	case "round_robin":
+		return newRoundRobin(), nil
+	case "least_conn":
+		return newLeastConn(), nil
+	case "weighted":
+		return newWeighted(), nil
+	case "hash":
+		key, err := parseHashKey(cfg.HashBy)
+		if err != nil {
+			return nil, err
+		}
+		return newConsistentHash(key), nil
+	default:
+		return nil, fmt.Errorf("Unknown balancing strategy '%s'", cfg.Strategy)
+	}
+}
+
+func parseHashKey(hashBy string) (hashKey, error) {
+	parts := strings.SplitN(hashBy, ":", 2)
+	switch {
+	case hashBy == "" || hashBy == "ip":
+		return hashKey{source: "ip"}, nil
+	case len(parts) == 2 && (parts[0] == "header" || parts[0] == "cookie") && parts[1] != "":
+		return hashKey{source: parts[0], name: parts[1]}, nil
+	default:
+		return hashKey{}, fmt.Errorf("Invalid hash key '%s'", hashBy)
+	}
+}
+
+func noop() {}
@@ -0,0 +1,118 @@
+package balancer
+
+import (
+	"net/http"
+	"testing"
+)
+
+var testBackends = []Backend{
+	{Address: "10.0.0.1:80", Weight: 1},
+	{Address: "10.0.0.2:80", Weight: 1},
+	{Address: "10.0.0.3:80", Weight: 1},
+}
+
+func mustNew(t *testing.T, cfg Config) Balancer {
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Unable to create balancer %#v: %s", cfg, err)
+	}
+	return b
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := mustNew(t, Config{Strategy: "round_robin"})
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	for i := 0; i < 6; i++ {
+		backend, done := b.Pick(r, testBackends)
+		done()
+		if expected := testBackends[i%3].Address; backend.Address != expected {
+			t.Errorf("Pick %d: expected %s, got %s", i, expected, backend.Address)
+		}
+	}
+}
+
+func TestLeastConn(t *testing.T) {
+	b := mustNew(t, Config{Strategy: "least_conn"})
+	r, _ := http.NewRequest("GET", "/", nil)
+
+	seen := map[string]func(){}
+	for i := 0; i < 3; i++ {
+		backend, done := b.Pick(r, testBackends)
+		if _, ok := seen[backend.Address]; ok {
+			t.Fatalf("Backend %s was picked twice while others were idle", backend.Address)
+		}
+		seen[backend.Address] = done
+	}
+
+	// Release one backend which then is the only one with least connections
+	seen[testBackends[1].Address]()
+	if backend, _ := b.Pick(r, testBackends); backend.Address != testBackends[1].Address {
+		t.Errorf("Expected released backend %s, got %s", testBackends[1].Address, backend.Address)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	b := mustNew(t, Config{Strategy: "weighted"})
+	r, _ := http.NewRequest("GET", "/", nil)
+	backends := []Backend{
+		{Address: "stable:80", Weight: 9},
+		{Address: "canary:80", Weight: 1},
+		{Address: "drained:80", Weight: 0},
+	}
+
+	hits := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		backend, _ := b.Pick(r, backends)
+		hits[backend.Address]++
+	}
+
+	if hits["drained:80"] != 0 {
+		t.Errorf("Backend with weight 0 received traffic")
+	}
+	if hits["canary:80"] < 700 || hits["canary:80"] > 1300 {
+		t.Errorf("Canary received unexpected share of traffic: %d", hits["canary:80"])
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	b := mustNew(t, Config{Strategy: "hash", HashBy: "header:X-User"})
+
+	pick := func(user string, backends []Backend) string {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-User", user)
+		backend, _ := b.Pick(r, backends)
+		return backend.Address
+	}
+
+	users := []string{"alice", "bob", "carol", "dave", "eve", "frank", "grace", "heidi"}
+	before := map[string]string{}
+	for _, u := range users {
+		before[u] = pick(u, testBackends)
+		if again := pick(u, testBackends); again != before[u] {
+			t.Errorf("User %s moved from %s to %s without change", u, before[u], again)
+		}
+	}
+
+	// Removing a backend must only move the users of that backend
+	for _, u := range users {
+		if before[u] == testBackends[2].Address {
+			continue
+		}
+		if after := pick(u, testBackends[:2]); after != before[u] {
+			t.Errorf("User %s moved from %s to %s after unrelated removal", u, before[u], after)
+		}
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{Strategy: "fastest"},
+		{Strategy: "hash", HashBy: "header:"},
+		{Strategy: "hash", HashBy: "query:id"},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Invalid config %#v was accepted", cfg)
+		}
+	}
+}
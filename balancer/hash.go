@@ -0,0 +1,98 @@
+package balancer
+
+import (
+	"hash/crc32"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const virtualNodes = 100
+
+type hashKey struct {
+	source string
+	name   string
+}
+
+func (k hashKey) value(r *http.Request) string {
+	switch k.source {
+	case "header":
+		if v := r.Header.Get(k.name); v != "" {
+			return v
+		}
+	case "cookie":
+		if c, err := r.Cookie(k.name); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+
+	// Fall back to the client address if the key is not present
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type hashRing struct {
+	points []uint32
+	owners map[uint32]Backend
+}
+
+func newHashRing(backends []Backend) *hashRing {
+	ring := &hashRing{owners: make(map[uint32]Backend)}
+	for _, backend := range backends {
+		for i := 0; i < virtualNodes; i++ {
+			point := crc32.ChecksumIEEE([]byte(backend.Address + "#" + strconv.Itoa(i)))
+			if _, ok := ring.owners[point]; ok {
+				continue
+			}
+			ring.owners[point] = backend
+			ring.points = append(ring.points, point)
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+func (h *hashRing) get(key string) Backend {
+	point := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(h.points), func(i int) bool { return h.points[i] >= point })
+	if idx == len(h.points) {
+		idx = 0
+	}
+	return h.owners[h.points[idx]]
+}
+
+type consistentHash struct {
+	sync.Mutex
+	key     hashKey
+	ringFor string
+	ring    *hashRing
+}
+
+func newConsistentHash(key hashKey) *consistentHash {
+	return &consistentHash{key: key}
+}
+
+func (b *consistentHash) Pick(r *http.Request, backends []Backend) (Backend, func()) {
+	addresses := make([]string, len(backends))
+	for i, backend := range backends {
+		addresses[i] = backend.Address
+	}
+	sort.Strings(addresses)
+	ringFor := strings.Join(addresses, ",")
+
+	b.Lock()
+	if b.ring == nil || b.ringFor != ringFor {
+		b.ring = newHashRing(backends)
+		b.ringFor = ringFor
+	}
+	ring := b.ring
+	b.Unlock()
+
+	return ring.get(b.key.value(r)), noop
+}
@@ -0,0 +1,97 @@
+package balancer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultStickyCookie = "dockerproxy_affinity"
+
+// StickyConfig enables session affinity through a signed cookie
+type StickyConfig struct {
+	// Cookie is the name of the affinity cookie
+	Cookie string `json:"cookie" yaml:"cookie"`
+	// Secret is the HMAC key to sign the cookie with
+	Secret string `json:"secret" yaml:"secret"`
+	// TTL is the lifetime of the cookie, session cookies are used if unset
+	TTL time.Duration `json:"ttl" yaml:"ttl"`
+}
+
+// Sticky binds clients to the backend they were sent to first
+type Sticky struct {
+	cookie string
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSticky creates a Sticky from the config using fallbackSecret if
+// the config does not contain a secret
+func NewSticky(cfg StickyConfig, fallbackSecret []byte) *Sticky {
+	s := &Sticky{
+		cookie: cfg.Cookie,
+		secret: []byte(cfg.Secret),
+		ttl:    cfg.TTL,
+	}
+	if s.cookie == "" {
+		s.cookie = defaultStickyCookie
+	}
+	if len(s.secret) == 0 {
+		s.secret = fallbackSecret
+	}
+	return s
+}
+
+func (s *Sticky) sign(address string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(address))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Lookup returns the backend stored in the affinity cookie of the
+// request if the signature is valid and the backend is still available
+func (s *Sticky) Lookup(r *http.Request, backends []Backend) (Backend, bool) {
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return Backend{}, false
+	}
+
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 {
+		return Backend{}, false
+	}
+	rawAddress, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return Backend{}, false
+	}
+	address := string(rawAddress)
+	if !hmac.Equal([]byte(parts[1]), []byte(s.sign(address))) {
+		return Backend{}, false
+	}
+
+	for _, backend := range backends {
+		if backend.Address == address {
+			return backend, true
+		}
+	}
+	return Backend{}, false
+}
+
+// Set stores the backend in the affinity cookie of the response
+func (s *Sticky) Set(w http.ResponseWriter, r *http.Request, backend Backend) {
+	c := &http.Cookie{
+		Name:     s.cookie,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(backend.Address)) + "." + s.sign(backend.Address),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+	}
+	if s.ttl > 0 {
+		c.MaxAge = int(s.ttl / time.Second)
+		c.Expires = time.Now().Add(s.ttl)
+	}
+	http.SetCookie(w, c)
+}
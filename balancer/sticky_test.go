@@ -0,0 +1,37 @@
+package balancer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSticky(t *testing.T) {
+	s := NewSticky(StickyConfig{Secret: "topsecret"}, nil)
+
+	res := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/", nil)
+	s.Set(res, r, testBackends[1])
+
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != defaultStickyCookie {
+		t.Fatalf("Affinity cookie was not set: %v", cookies)
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.AddCookie(cookies[0])
+	if backend, ok := s.Lookup(r, testBackends); !ok || backend.Address != testBackends[1].Address {
+		t.Errorf("Affinity cookie was not honoured: %v %v", backend, ok)
+	}
+
+	// Backend vanished from the pool
+	if _, ok := s.Lookup(r, []Backend{testBackends[0], testBackends[2]}); ok {
+		t.Errorf("Affinity to removed backend was honoured")
+	}
+
+	// Cookie signed with another secret
+	other := NewSticky(StickyConfig{Secret: "othersecret"}, nil)
+	if _, ok := other.Lookup(r, testBackends); ok {
+		t.Errorf("Cookie with invalid signature was accepted")
+	}
+}
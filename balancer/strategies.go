@@ -0,0 +1,126 @@
+package balancer
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type random struct {
+	sync.Mutex
+	rnd *rand.Rand
+}
+
+func newRandom() *random {
+	return &random{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *random) intn(n int) int {
+	b.Lock()
+	defer b.Unlock()
+	return b.rnd.Intn(n)
+}
+
+func (b *random) Pick(r *http.Request, backends []Backend) (Backend, func()) {
+	return backends[b.intn(len(backends))], noop
+}
+
+type roundRobin struct {
+	sync.Mutex
+	next int
+}
+
+func newRoundRobin() *roundRobin {
+	return &roundRobin{}
+}
+
+func (b *roundRobin) Pick(r *http.Request, backends []Backend) (Backend, func()) {
+	b.Lock()
+	defer b.Unlock()
+	backend := backends[b.next%len(backends)]
+	b.next = (b.next + 1) % len(backends)
+	return backend, noop
+}
+
+type leastConn struct {
+	sync.Mutex
+	active map[string]int
+	random *random
+}
+
+func newLeastConn() *leastConn {
+	return &leastConn{
+		active: make(map[string]int),
+		random: newRandom(),
+	}
+}
+
+func (b *leastConn) Pick(r *http.Request, backends []Backend) (Backend, func()) {
+	b.Lock()
+	defer b.Unlock()
+
+	candidates := []Backend{}
+	min := -1
+	for _, backend := range backends {
+		switch n := b.active[backend.Address]; {
+		case min == -1 || n < min:
+			min = n
+			candidates = []Backend{backend}
+		case n == min:
+			candidates = append(candidates, backend)
+		}
+	}
+
+	// Choose randomly between equally loaded backends to not always hit the first one
+	chosen := candidates[b.random.intn(len(candidates))]
+	b.active[chosen.Address]++
+
+	var once sync.Once
+	return chosen, func() {
+		once.Do(func() {
+			b.Lock()
+			defer b.Unlock()
+			b.active[chosen.Address]--
+			if b.active[chosen.Address] <= 0 {
+				delete(b.active, chosen.Address)
+			}
+		})
+	}
+}
+
+type weighted struct {
+	random *random
+}
+
+func newWeighted() *weighted {
+	return &weighted{random: newRandom()}
+}
+
+func (b *weighted) Pick(r *http.Request, backends []Backend) (Backend, func()) {
+	total := 0
+	for _, backend := range backends {
+		if backend.Weight > 0 {
+			total += backend.Weight
+		}
+	}
+
+	if total == 0 {
+		// Nobody wants traffic but somebody has to take it
+		return b.random.Pick(r, backends)
+	}
+
+	n := b.random.intn(total)
+	for _, backend := range backends {
+		if backend.Weight <= 0 {
+			continue
+		}
+		if n < backend.Weight {
+			return backend, noop
+		}
+		n -= backend.Weight
+	}
+
+	// Unreachable as n < total
+	return backends[len(backends)-1], noop
+}
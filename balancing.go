@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/rand"
+	"log"
+	"strconv"
+	"sync"
+
+	"github.com/Luzifer/dockerproxy/balancer"
+	"github.com/Luzifer/dockerproxy/discovery"
+)
+
+type balancerEntry struct {
+	cfg      balancer.Config
+	balancer balancer.Balancer
+}
+
+var (
+	balancers     = make(map[string]balancerEntry)
+	balancersLock sync.Mutex
+
+	// stickySecret signs affinity cookies of domains without own secret
+	stickySecret = make([]byte, 32)
+)
+
+func init() {
+	if _, err := rand.Read(stickySecret); err != nil {
+		log.Fatalf("Unable to generate secret for affinity cookies: %s", err)
+	}
+}
+
+// getBalancer returns the balancer for the scope (domain or slug) and
+// keeps it between requests to let it maintain its state
+func getBalancer(scope string, cfg balancer.Config) balancer.Balancer {
+	balancersLock.Lock()
+	defer balancersLock.Unlock()
+
+	if entry, ok := balancers[scope]; ok && entry.cfg == cfg {
+		return entry.balancer
+	}
+
+	b, err := balancer.New(cfg)
+	if err != nil {
+		log.Printf("[Balancer] Invalid configuration for %s, using random: %s", scope, err)
+		b, _ = balancer.New(balancer.Config{})
+	}
+	balancers[scope] = balancerEntry{cfg: cfg, balancer: b}
+	return b
+}
+
+// balancerBackends converts the discovered backends into balancer
+// backends reading the weight from the container labels
+func balancerBackends(backends []discovery.Backend) []balancer.Backend {
+	result := make([]balancer.Backend, 0, len(backends))
+	for _, backend := range backends {
+		weight := 1
+		if w, ok := backend.Labels[balancer.WeightLabel]; ok {
+			var err error
+			if weight, err = strconv.Atoi(w); err != nil || weight < 0 {
+				log.Printf("[Balancer] Invalid weight %q on %s, using 1", w, backend.Address)
+				weight = 1
+			}
+		}
+		result = append(result, balancer.Backend{Address: backend.Address, Weight: weight})
+	}
+	return result
+}
@@ -1,260 +0,0 @@
-// Code generated by go-bindata.
-// sources:
-// assets/lets-encrypt-x1-cross-signed.pem
-// assets/lets-encrypt-x3-cross-signed.pem
-// DO NOT EDIT!
-
-package main
-
-import (
-	"bytes"
-	"compress/gzip"
-	"fmt"
-	"io"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strings"
-	"time"
-)
-
-func bindataRead(data []byte, name string) ([]byte, error) {
-	gz, err := gzip.NewReader(bytes.NewBuffer(data))
-	if err != nil {
-		return nil, fmt.Errorf("Read %q: %v", name, err)
-	}
-
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, gz)
-	clErr := gz.Close()
-
-	if err != nil {
-		return nil, fmt.Errorf("Read %q: %v", name, err)
-	}
-	if clErr != nil {
-		return nil, err
-	}
-
-	return buf.Bytes(), nil
-}
-
-type asset struct {
-	bytes []byte
-	info  os.FileInfo
-}
-
-type bindataFileInfo struct {
-	name    string
-	size    int64
-	mode    os.FileMode
-	modTime time.Time
-}
-
-func (fi bindataFileInfo) Name() string {
-	return fi.name
-}
-func (fi bindataFileInfo) Size() int64 {
-	return fi.size
-}
-func (fi bindataFileInfo) Mode() os.FileMode {
-	return fi.mode
-}
-func (fi bindataFileInfo) ModTime() time.Time {
-	return fi.modTime
-}
-func (fi bindataFileInfo) IsDir() bool {
-	return false
-}
-func (fi bindataFileInfo) Sys() interface{} {
-	return nil
-}
-
-var _assetsLetsEncryptX1CrossSignedPem = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x6c\x55\x4b\xb3\x82\x3a\x1a\xdc\xf3\x2b\x66\x6f\x4d\x09\x08\x2a\x8b\x59\x24\x24\xbc\x24\x70\x82\xa0\xc2\x8e\x87\xc2\xe1\xed\x41\x0c\xf0\xeb\x87\xe3\x4c\xdd\x5b\x73\xef\xb0\x82\x2f\xf9\x8a\xee\x4e\x77\xbe\x7f\xfe\x3e\x10\xeb\xa6\xf3\x0f\x15\x7b\xbe\xa9\x99\x2a\xf0\xf1\xa7\xca\x11\xd3\xc4\x4f\xa4\xaa\x40\x56\x73\xc0\x4c\x08\x72\xd3\x03\x56\xee\x2b\x46\xe0\xcb\xb7\xc0\xa6\x9b\x0c\x19\x7d\x7a\xf7\x6c\x9e\x21\x1a\x5a\xa7\x2e\x32\x8b\x77\xea\x00\x8a\x6d\x48\x01\xe3\xbe\x16\x5c\x11\xd5\xd4\x81\x10\x60\xb5\x20\x89\xa7\xd7\x6d\x7c\xf3\x8a\x44\x85\x7e\x7c\xcd\xc6\xf0\xe6\x09\x69\x13\xe4\x17\xc3\x12\xd2\x1d\xcd\xa9\x78\x1c\x89\x97\x32\x8d\x86\xe8\x42\x29\xe2\x30\x93\x71\x20\xd0\x3c\x68\x94\x77\xa6\x42\x44\xcf\x30\x24\x0b\xb8\x6b\x8c\x9f\x1c\x1f\x33\xe2\x57\x33\x29\xc9\x42\x96\x28\x5e\x6b\x33\x41\xff\x5b\xe3\x08\xee\x26\x75\x01\x16\xcc\x9d\x0b\x04\xa1\x0f\xea\x8b\x4f\xbc\x90\x69\xe0\xf3\x87\x13\x66\x02\x89\x6e\xb4\x4d\x67\xa8\x25\x8d\x33\xdf\x6f\x90\x27\x67\xc2\xcc\x3f\x10\x4c\xfd\xdf\x36\x98\x58\x13\x32\x7d\x65\xda\xd4\xfc\xfd\x17\x91\xbf\x6a\x74\x36\xff\xaa\x01\x5c\x35\x40\x5c\x9e\xe3\x2f\xf0\x2b\x22\xed\xd4\xf5\x1d\x02\x6b\xf1\xd1\x17\x8c\xe5\xb3\x6c\xbc\x76\x56\xe6\x48\xee\x12\xeb\x84\xed\x5f\xa9\x60\x15\x55\x2d\x25\xe2\xc6\x79\x68\xac\xfa\xde\xec\x46\xfc\x82\x1c\x8c\xc7\xbe\x2d\x03\xf3\xea\xce\xd3\x69\xf0\xba\x82\x8d\xa5\xb4\xbb\x55\xf2\xdb\x6d\xc3\x56\xdf\xdf\x43\x2d\x37\x94\xbb\xd7\xf4\xdb\x85\x37\x8a\x36\x4d\x8b\x1e\xf5\x57\x6f\xd9\x1e\x9a\xe6\x5e\xe7\xdc\x17\x2e\xc9\x63\xe8\x9d\x6c\x32\xab\x36\x48\x93\xeb\x38\xca\x07\x28\xed\x00\x9c\xd3\x1f\x63\x6c\xe1\xfd\xc7\x3d\x8f\xa3\x42\xef\x81\xd8\xd8\xad\xbd\xbd\xf2\xc7\xba\x29\x1f\xe6\xdc\xab\x15\x98\x75\x2e\xd3\x1f\xe6\x63\x7f\x8d\x47\xcd\x2a\x34\x48\xb6\xd1\xbd\x51\x8b\xe3\x3b\xd9\xe4\xf2\x55\xe9\x62\xeb\x28\x05\xdb\x5a\x8a\xdf\x83\xc3\xe2\x83\x38\x38\xb5\x17\x29\x93\x3a\xe6\x51\x72\x8a\x20\x8a\x04\x2e\xd7\xc7\xe1\xfc\x26\x49\x85\x6b\xc9\xde\x9f\xae\xfe\xdc\xe5\xd6\xb9\xc2\x53\xeb\x03\x1e\x19\x4e\xb9\x60\xa9\xf6\xe2\xfd\x13\xb9\x12\xdd\xea\x93\x71\x24\xec\xb1\xb7\x64\xe2\x11\xc5\xf6\x13\x89\x93\xb6\xcb\x4c\xc4\xa7\xec\xfa\x86\xf6\x73\x3c\x3b\xc8\x13\x2a\xad\x74\x9f\x9b\x8e\xf6\xaf\x17\xb5\x99\x53\x28\x4c\x26\x2a\x60\x18\x80\xd8\x55\x41\x64\xb2\x3c\xd7\x5d\x02\x3f\xe6\xcb\x56\x23\x61\xb8\x65\xd4\x24\x20\x84\xe0\x71\x5c\x4f\x03\x30\x94\xaf\x67\xec\xf1\x5f\x80\x1a\x5b\x08\x28\x02\xb9\xae\x13\xe3\xa8\xab\xea\xa0\x03\x1a\x68\x70\xed\x81\x06\x61\xa9\x0f\x66\x0e\xe6\xf9\x0f\xcc\xb1\x06\x69\xca\x40\x18\x36\xb1\xe1\xf1\x29\xea\xde\xb6\x58\x2f\x69\x93\x8e\xd9\xc7\xc2\x5e\x1f\xa9\xf2\x3b\xdc\x39\xcc\x6e\xea\x2a\xba\xca\x7c\xda\x5e\x96\x4c\x95\x4b\x2e\x11\x79\xe6\xb2\xd0\x3c\xb1\x70\x35\x46\x60\x10\x70\xd2\x6d\xb1\xe0\x33\x03\xec\xed\x59\x29\x52\x03\x2e\x7f\x6d\x5a\x7b\x56\x93\xad\xd6\x37\xc8\x9b\x8b\x0c\x87\xff\x7c\xe8\x4e\x71\x47\x64\x4c\x51\x56\x12\x78\xfc\xf0\x33\x19\x0d\x09\x8c\x81\x46\xce\xed\x10\x7b\x07\xdb\x30\xf6\xe2\x46\xb3\x2b\xe3\xb6\x9d\xe0\x25\x2f\xc2\x8a\x72\x44\xa3\xff\xd9\x0c\x3c\x87\xe0\x81\xa9\x20\xd4\x23\x09\x13\x40\x4d\x48\xd0\xca\x7b\xfe\xe5\x4d\x61\xfe\x3a\xfa\xbf\xf6\x25\x08\x30\x3b\xff\x13\x31\x07\x72\x7c\x35\x9b\x3f\x20\x97\xa9\xb1\xa2\xf8\x24\x53\x90\xc8\x59\x1e\xa2\x9b\xb7\xac\xf0\xcb\xb4\xad\xd9\x0a\x7f\x85\x9e\xb2\x2f\xf0\x11\xf9\x01\x51\xc0\xb8\x35\xba\x53\xa7\x6e\x72\x3b\x0c\x7f\xfe\x14\xd0\x99\x13\x55\xee\x23\xfd\xf2\x5f\x11\xe9\x18\x8a\xca\xcb\xe6\x3d\xff\xa2\x59\x5f\xbe\xe0\x21\x7a\xc9\x17\x2a\x58\x84\xb3\xd7\x04\x26\x08\xf8\xbf\x71\x36\x3c\x09\x23\x04\x4e\x1d\xcd\x19\xec\x4c\x6c\x37\x42\xbf\xae\x65\x9f\x35\x2a\x61\x2d\xa7\xc1\x13\xf7\xcf\x90\x79\xca\x2e\xf9\x69\x78\xbf\xd9\x71\x7d\x75\xa9\x0f\x5b\xb7\x3b\xac\xc9\xff\x3f\x57\x16\xfa\x24\xd4\x31\x4c\xb3\x1a\x87\xc3\x86\x58\xdf\x51\x44\x87\x50\x90\x52\xb5\x83\xba\x50\x64\xdc\x9b\xb7\x44\x7e\xab\xcd\xd7\x4e\xee\xfb\xf6\x51\xda\x87\xe3\x59\xac\xa4\x41\xd4\x6d\xcf\x3a\x7c\x23\x25\x42\x27\xfc\xd3\x66\xef\xc4\xd1\xf4\x74\xb8\x6e\x94\xea\x74\xda\xfa\x6d\x28\x0a\x45\x2f\xa1\x8c\x33\xfd\xf7\xf1\xac\x44\x21\x3d\x74\x71\xf7\x1c\x0e\x06\x2b\x30\x09\x95\xe1\x3b\xc1\x48\x8a\x6f\x8c\x57\xa6\x1f\x2b\xf2\x55\x65\x39\x09\xa3\xf9\xb8\xee\x5f\x72\x66\xd0\x72\x74\xaf\xef\x8d\x61\x74\x57\x2e\x6a\xc7\x7e\x9e\xfa\x01\x07\x35\x8e\xb5\x64\xb3\x3d\xab\xa6\x74\x52\xcf\x30\x03\x43\x38\x81\x74\x30\x42\x53\x9e\x26\x6c\x4a\xf6\xf8\x32\xfa\xfd\xb0\x73\x7d\x51\x1b\x5d\x85\xbf\x3e\xb2\xc1\xac\xb8\xfd\xf3\x70\x74\xc9\x39\x6b\x8f\x07\x39\x71\xca\x0c\x86\xe0\x39\x05\xbd\xb8\xb5\xb1\x69\x3c\x10\xac\xec\x8e\x2e\x7c\xa1\x59\x0d\x03\x49\x18\x17\xcf\x53\x6c\xb7\x87\xdd\x17\x00\x8d\x70\x13\xab\x92\x7b\x08\xec\x88\xb2\xb6\x8a\x13\xd7\xd6\xef\xee\x25\x2d\x95\x75\x16\x0c\xfb\xc3\x1b\xce\xd5\x24\xc5\xad\x5f\x06\x5e\x95\x3c\xa3\xa1\x0e\x70\x30\xb4\x62\x25\x4f\xf7\x31\x16\x97\xa0\xfa\x17\xf7\x19\x2d\xd8\x41\x7f\x1f\x37\xff\x0e\x00\x00\xff\xff\x9e\xa1\x94\x32\x8b\x06\x00\x00")
-
-func assetsLetsEncryptX1CrossSignedPemBytes() ([]byte, error) {
-	return bindataRead(
-		_assetsLetsEncryptX1CrossSignedPem,
-		"assets/lets-encrypt-x1-cross-signed.pem",
-	)
-}
-
-func assetsLetsEncryptX1CrossSignedPem() (*asset, error) {
-	bytes, err := assetsLetsEncryptX1CrossSignedPemBytes()
-	if err != nil {
-		return nil, err
-	}
-
-	info := bindataFileInfo{name: "assets/lets-encrypt-x1-cross-signed.pem", size: 1675, mode: os.FileMode(420), modTime: time.Unix(1453760080, 0)}
-	a := &asset{bytes: bytes, info: info}
-	return a, nil
-}
-
-var _assetsLetsEncryptX3CrossSignedPem = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x64\x95\xc9\xd2\xba\x4a\x12\xc5\xf7\x3c\x45\xef\x8d\x0e\x11\x04\x71\x71\x17\x55\x50\x0c\x1f\x16\xca\x3c\xec\x98\x64\x16\x15\xa5\x80\xa7\x6f\xb4\x3b\x6e\xdf\xf8\x7f\xec\xc8\x48\x82\x53\x27\xcf\xaf\xf2\xdf\x9f\x07\x22\x45\x33\xfe\x25\x22\xcb\xd1\x64\x4d\x04\x0e\xfa\x56\x29\xac\x69\xa8\xa9\x45\x11\xb0\x8f\x02\x10\x0d\x82\x42\x33\xc5\x42\x86\x66\x01\x00\xf0\xce\x72\xca\xf4\xa7\x32\x9f\x6f\xa2\x04\x0c\x58\x34\x8f\xb2\xa9\x94\x23\xa1\x21\x30\x07\x19\x48\x60\x4b\x61\xdb\x24\x5a\x11\x4a\x9e\x69\xea\x68\x7a\xa1\xd8\xcf\xee\x99\x22\x0f\x9a\x6c\xdc\x23\x86\x2b\x33\xd5\x9b\x23\x1b\xba\xe9\xcd\x5b\x32\x11\x4a\xc9\xbc\x9f\xe4\x05\x78\xb0\x30\x3c\x08\xb0\x43\x49\x8d\xe5\x78\x22\xb4\x13\xe6\x48\x6b\xc8\x80\x9a\x5c\x2c\x18\xee\x03\xc9\x41\x0c\x96\xf0\x64\x2c\x88\x31\x24\x40\x1b\x6d\xbf\xd6\xb4\xe9\x8f\x1a\xa1\xec\x1a\x9d\x30\x68\x14\xb0\x73\x11\x2c\xb1\xe8\x79\x78\x92\x6b\xe0\xfe\xf7\x0f\xbd\x23\xb9\x53\x9b\x89\xd9\xa2\x21\xef\x1d\xb2\x3f\x5c\xaa\x9a\x93\xb6\x80\xf2\x6f\x05\x4a\xf3\xab\xa1\x30\x03\x8f\x8e\x95\xe3\x1c\x07\x16\xf7\x55\xa4\x69\x50\xab\xff\xf4\x00\xc9\x00\x9c\x45\x0a\x98\x02\xf8\x34\x88\x85\x2e\xae\x45\x70\x33\x30\x16\xe4\x67\x7b\x6a\x72\x36\x6d\x69\xb6\x38\x18\x7d\xb8\x48\x8f\xdd\xe2\x76\x8a\x1d\x94\x63\xb2\xdf\x09\x81\x68\x9f\x0e\xf9\xde\xa6\x91\x4c\x3d\xf8\x2e\x37\xcc\x32\x3c\x9c\xd0\x63\x52\x2a\x55\xe4\x2f\x75\x96\x3b\x9d\xc0\x67\x55\x9a\xdc\xb9\xc2\x07\xd7\x1d\xa7\xc4\xb7\xed\xc5\xcc\x95\x6c\x9a\x95\xe6\xdc\x46\xea\x65\xfb\x8e\x23\xde\x07\x02\x65\xe3\x69\x33\x37\x3b\x16\x55\x76\x66\x4d\xaf\x98\x3f\xdc\x06\xb5\x4e\x81\xfa\x33\x0f\x39\x9f\xca\xfc\xc0\xe9\xfc\x61\x07\x39\x27\x0e\xdf\xe9\x78\x4c\x9c\xd9\x8f\x0d\xa1\xd6\x1b\xdd\x94\xb4\x88\xa6\x22\xa1\xdc\xde\xa3\xc7\xde\xed\x90\x8b\x96\x63\xcb\x87\xba\x3a\x1f\x47\x5e\x6a\x13\xa6\xec\x6f\x4b\xe9\x6c\x82\xf2\xb1\x21\x2c\x7c\x8e\x31\x61\x3c\xf9\xc6\x22\x9d\x87\xed\x70\x6f\x90\x71\xf3\x01\x15\xf3\x93\x2e\x4c\x6f\xd3\x0e\x8a\xb1\xbf\x47\x17\xbd\x02\xad\x6e\x3a\x4a\x86\x25\x13\xa7\xcc\x05\x3b\x95\x27\x3f\x1f\x3d\x3e\x94\x92\x90\xa0\x2b\x59\xe0\xb6\xbf\x35\x13\x5a\xe8\x97\x31\xd6\x35\xb5\xbd\x68\x4b\xfc\x6c\x38\x9c\xfa\xe3\xa4\xd1\x86\xea\x9b\x3e\xe6\x9f\x7c\x29\x76\xcc\x0e\x8c\x80\x51\x59\xa9\x21\x9a\x04\x4c\x00\xfb\xbd\x06\xaf\xce\x9a\xda\xa0\x21\xe8\x13\x3e\x8b\x76\xd6\x29\xa8\xdb\x35\xbf\x04\x16\x08\x6e\x3f\x51\x96\xc0\xf9\x33\x63\xd5\x14\x20\xb8\x0a\x68\x9d\xb5\x08\xc2\x90\x5c\x49\xa8\xe9\x24\x84\xd0\x74\xd5\x75\x5a\x28\x5d\xe0\x1a\x2a\x4d\xa1\x44\x71\x50\x80\xe9\xca\x70\x01\xb0\xac\xa2\x3e\x53\x2d\x72\xae\x84\x31\x0e\x8c\x39\x9a\x39\xfa\x1b\x61\xa5\x6d\x4e\xdd\xb1\x4e\x59\xf0\x8e\x7d\xab\x4d\x6e\xd6\x9c\x05\x06\x7d\xea\x8c\x91\x4a\x1c\x70\x80\x45\xf1\x5c\x15\xac\x04\xa5\x04\xf4\xe1\x18\xab\x16\x9d\x4a\xfd\x78\x62\x64\x92\xaa\xf8\xd7\x47\x89\x7d\x9c\x3f\xd1\x4f\xe7\x63\x43\xa5\xac\xf5\x7d\x09\x19\x79\x8f\x67\x8e\x18\x0c\x26\x2a\xf9\x9e\xaf\x86\xb0\x20\x72\x0f\xdc\x49\xcf\xa7\xbb\x3a\x0c\xe9\xf5\x99\xec\xdd\xb7\x99\x5d\xb7\x48\xf6\x45\xb9\xb2\x00\xa1\x3c\xf0\x6d\x2e\x20\xa2\x89\xbd\x00\x0d\x16\xd1\xad\x30\xc9\x6a\x0b\x22\x17\x12\x9e\xbe\xe7\x06\x8a\xc8\x4e\xf8\x13\x5f\x82\x25\xf0\xfe\x87\xe2\x35\xca\x56\x58\xfd\x5f\xb2\x41\xd2\x99\xfb\x2a\x3a\x05\xc5\x74\xea\x56\x4e\x54\xa3\x4d\x3a\x63\xce\x03\xb8\xca\x3f\xce\xd1\x02\x84\xaf\xc9\x96\x80\x0c\x07\x2c\x14\x96\x94\xe2\xc4\x83\x57\x59\xbd\xfe\x36\x70\x85\x6a\x38\x75\x6d\x13\xf9\xff\x33\x51\xe4\xea\x84\xa1\x47\x4b\x36\x5c\xb7\x39\x5e\xbc\x15\x7b\x5f\x32\x24\xb7\x21\x6f\xea\xd3\x8c\x21\xfd\xc1\x39\x93\x0a\xd3\x87\xd0\xee\xed\xee\x5e\x43\x95\xcd\xde\xef\xc4\x3a\x27\x79\x67\xf9\xc1\x28\xf0\xf5\xd0\x3b\xbf\x6f\x25\xea\x83\xe4\x87\x42\xd6\xb9\x04\xe8\x6a\xd4\xbe\x54\x67\x0a\x0c\x0e\xa2\xe7\x6f\xb2\x36\xe6\x52\x54\xb5\xf1\x7a\x1f\xe5\x82\xd6\x88\x3f\xa7\xc9\x2f\x8f\x3a\xaa\x1a\xf6\x47\xb5\x2c\x55\xf9\xe9\xa9\x37\x66\xbc\x54\xb9\xb6\x47\xde\x16\x9c\xaa\xb4\x96\x68\xec\x9f\x7d\x9e\xf1\xaf\x8a\xf7\x1f\x08\x76\xaf\x85\xb0\x7e\x9f\xc5\xaf\x62\xe3\xcd\xe7\xfc\x5e\xee\x51\x78\x7f\x6e\x77\x24\xd0\x5f\x93\xb0\xa5\x08\xb8\x6b\xe3\x8f\x4d\x5e\x9d\x57\xed\xb1\xec\x72\x31\x7e\x3c\x6d\x09\xf1\x79\x7c\x60\x71\xcd\xbc\x52\x3c\xf7\x5c\x8d\x33\xbe\xee\x72\xdf\x55\x75\x61\xe8\xb7\x75\xef\xbb\xbd\x7a\x76\x8b\xd5\x83\x60\x7f\xe9\x77\x66\xb8\x6c\xd8\x6c\x58\x1a\xe9\x81\xef\xfb\x6b\xd3\x4e\x90\x04\xd6\xe0\xef\x68\x3d\x58\x56\x9e\xa2\xcd\x70\xbe\x80\x31\x9f\xa7\xea\x96\x75\x75\xe3\x0b\xad\x32\x6f\xcc\xc1\x6a\x15\xea\x72\x8d\x36\x0a\x1f\xf1\xe5\xa1\xab\xf3\x8e\x0e\x37\x95\xdf\x36\x61\xea\xed\x2f\x9a\x7f\xda\x55\x04\x56\xc2\x10\x8b\x89\x62\x73\xb5\xc1\xdc\x05\xbc\x09\x36\xe6\xc1\x35\x74\xd4\xac\xfe\xb2\x06\x4f\xe9\xe7\x47\xf3\xe8\xb8\x83\xa3\xae\xdc\xe5\xd2\x0f\x68\xec\x5b\xc9\x6f\x25\x43\x7e\xd3\x66\xf1\xd7\x5f\xd4\x77\x7d\x20\x43\xfa\xbd\x52\xfe\x13\x00\x00\xff\xff\x30\x45\xe0\xb2\x6f\x06\x00\x00")
-
-func assetsLetsEncryptX3CrossSignedPemBytes() ([]byte, error) {
-	return bindataRead(
-		_assetsLetsEncryptX3CrossSignedPem,
-		"assets/lets-encrypt-x3-cross-signed.pem",
-	)
-}
-
-func assetsLetsEncryptX3CrossSignedPem() (*asset, error) {
-	bytes, err := assetsLetsEncryptX3CrossSignedPemBytes()
-	if err != nil {
-		return nil, err
-	}
-
-	info := bindataFileInfo{name: "assets/lets-encrypt-x3-cross-signed.pem", size: 1647, mode: os.FileMode(420), modTime: time.Unix(1458943973, 0)}
-	a := &asset{bytes: bytes, info: info}
-	return a, nil
-}
-
-// Asset loads and returns the asset for the given name.
-// It returns an error if the asset could not be found or
-// could not be loaded.
-func Asset(name string) ([]byte, error) {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	if f, ok := _bindata[cannonicalName]; ok {
-		a, err := f()
-		if err != nil {
-			return nil, fmt.Errorf("Asset %s can't read by error: %v", name, err)
-		}
-		return a.bytes, nil
-	}
-	return nil, fmt.Errorf("Asset %s not found", name)
-}
-
-// MustAsset is like Asset but panics when Asset would return an error.
-// It simplifies safe initialization of global variables.
-func MustAsset(name string) []byte {
-	a, err := Asset(name)
-	if err != nil {
-		panic("asset: Asset(" + name + "): " + err.Error())
-	}
-
-	return a
-}
-
-// AssetInfo loads and returns the asset info for the given name.
-// It returns an error if the asset could not be found or
-// could not be loaded.
-func AssetInfo(name string) (os.FileInfo, error) {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	if f, ok := _bindata[cannonicalName]; ok {
-		a, err := f()
-		if err != nil {
-			return nil, fmt.Errorf("AssetInfo %s can't read by error: %v", name, err)
-		}
-		return a.info, nil
-	}
-	return nil, fmt.Errorf("AssetInfo %s not found", name)
-}
-
-// AssetNames returns the names of the assets.
-func AssetNames() []string {
-	names := make([]string, 0, len(_bindata))
-	for name := range _bindata {
-		names = append(names, name)
-	}
-	return names
-}
-
-// _bindata is a table, holding each asset generator, mapped to its name.
-var _bindata = map[string]func() (*asset, error){
-	"assets/lets-encrypt-x1-cross-signed.pem": assetsLetsEncryptX1CrossSignedPem,
-	"assets/lets-encrypt-x3-cross-signed.pem": assetsLetsEncryptX3CrossSignedPem,
-}
-
-// AssetDir returns the file names below a certain
-// directory embedded in the file by go-bindata.
-// For example if you run go-bindata on data/... and data contains the
-// following hierarchy:
-//     data/
-//       foo.txt
-//       img/
-//         a.png
-//         b.png
-// then AssetDir("data") would return []string{"foo.txt", "img"}
-// AssetDir("data/img") would return []string{"a.png", "b.png"}
-// AssetDir("foo.txt") and AssetDir("notexist") would return an error
-// AssetDir("") will return []string{"data"}.
-func AssetDir(name string) ([]string, error) {
-	node := _bintree
-	if len(name) != 0 {
-		cannonicalName := strings.Replace(name, "\\", "/", -1)
-		pathList := strings.Split(cannonicalName, "/")
-		for _, p := range pathList {
-			node = node.Children[p]
-			if node == nil {
-				return nil, fmt.Errorf("Asset %s not found", name)
-			}
-		}
-	}
-	if node.Func != nil {
-		return nil, fmt.Errorf("Asset %s not found", name)
-	}
-	rv := make([]string, 0, len(node.Children))
-	for childName := range node.Children {
-		rv = append(rv, childName)
-	}
-	return rv, nil
-}
-
-type bintree struct {
-	Func     func() (*asset, error)
-	Children map[string]*bintree
-}
-var _bintree = &bintree{nil, map[string]*bintree{
-	"assets": &bintree{nil, map[string]*bintree{
-		"lets-encrypt-x1-cross-signed.pem": &bintree{assetsLetsEncryptX1CrossSignedPem, map[string]*bintree{}},
-		"lets-encrypt-x3-cross-signed.pem": &bintree{assetsLetsEncryptX3CrossSignedPem, map[string]*bintree{}},
-	}},
-}}
-
-// RestoreAsset restores an asset under the given directory
-func RestoreAsset(dir, name string) error {
-	data, err := Asset(name)
-	if err != nil {
-		return err
-	}
-	info, err := AssetInfo(name)
-	if err != nil {
-		return err
-	}
-	err = os.MkdirAll(_filePath(dir, filepath.Dir(name)), os.FileMode(0755))
-	if err != nil {
-		return err
-	}
-	err = ioutil.WriteFile(_filePath(dir, name), data, info.Mode())
-	if err != nil {
-		return err
-	}
-	err = os.Chtimes(_filePath(dir, name), info.ModTime(), info.ModTime())
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-// RestoreAssets restores an asset under the given directory recursively
-func RestoreAssets(dir, name string) error {
-	children, err := AssetDir(name)
-	// File
-	if err != nil {
-		return RestoreAsset(dir, name)
-	}
-	// Dir
-	for _, child := range children {
-		err = RestoreAssets(dir, filepath.Join(name, child))
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func _filePath(dir, name string) string {
-	cannonicalName := strings.Replace(name, "\\", "/", -1)
-	return filepath.Join(append([]string{dir}, strings.Split(cannonicalName, "/")...)...)
-}
-
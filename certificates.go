@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/renewal"
+	"github.com/Luzifer/dockerproxy/sni"
+)
+
+const certificateWatchInterval = 10 * time.Second
+
+var (
+	certificateRefreshLock sync.Mutex
+	certRenewal            *renewal.Manager
+)
+
+func newCertRenewal() *renewal.Manager {
+	return renewal.NewManager(
+		renewal.Config{RenewBefore: renewTimeLeft},
+		fetchLetsEncryptCertificate,
+		certificateRenewed,
+		certificateRenewalFailed,
+	)
+}
+
+// diskCertificates collects the certificates configured from files
+func diskCertificates() []sni.Certificates {
+	var certs []sni.Certificates
+	for _, domain := range proxyConfiguration().Domains {
+		for _, ssl := range append([]sslConfig{domain.SSL}, domain.SSL.Additional...) {
+			if ssl.Cert != "" {
+				certs = append(certs, sni.Certificates{
+					CertFile: ssl.Cert,
+					KeyFile:  ssl.Key,
+				})
+			}
+		}
+	}
+	return certs
+}
+
+// letsEncryptDomains lists all names to request certificates for
+// including wildcard names validated through DNS providers
+func letsEncryptDomains() []string {
+	config := proxyConfiguration()
+	leDomains := []string{}
+	for domain, domainCFG := range config.Domains {
+		if domainCFG.UseLetsEncrypt {
+			leDomains = append(leDomains, domain)
+			if domainCFG.Wildcard {
+				leDomains = append(leDomains, "*."+domain)
+			}
+		}
+	}
+	if config.GenericDNS != "" {
+		leDomains = append(leDomains, genericWildcard())
+	}
+	sort.Strings(leDomains)
+	return leDomains
+}
+
+// letsEncryptGroups returns the names of the LetsEncrypt certificates
+// to request, one certificate per second level domain and one for every
+// host issued on-demand
+func letsEncryptGroups() [][]string {
+	groups := [][]string{}
+	for _, names := range createDomainMap(letsEncryptDomains()) {
+		groups = append(groups, names)
+	}
+	// On-demand certificates are requested for every host on its own
+	for _, name := range onDemandTLS.Names() {
+		groups = append(groups, []string{name})
+	}
+	return groups
+}
+
+// fetchLetsEncryptCertificate is the renewal.Fetcher obtaining one
+// certificate per configured key type from LetsEncrypt
+func fetchLetsEncryptCertificate(names []string) ([]sni.Certificates, error) {
+	certs := []sni.Certificates{}
+	for _, keyType := range leClient.KeyTypes() {
+		chain, key, err := leClient.FetchMultiDomainCertificate(names, keyType)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, sni.Certificates{
+			Certificate: chain[0],
+			Key:         key,
+			Chain:       chain[1:],
+		})
+	}
+	return certs, nil
+}
+
+func certificateRenewed() {
+	if err := refreshCertificates(); err != nil {
+		log.Printf("Unable to serve renewed certificates: %s", err)
+	}
+}
+
+func certificateRenewalFailed(names []string, err error) {
+	certRenewalFailures.WithLabelValues(strings.Join(names, ",")).Inc()
+	log.Printf("[LetsEncrypt] Unable to renew certificate for %s, keeping current one: %s", strings.Join(names, ", "), err)
+}
+
+// collectCertificates combines the certificates from disk with the
+// current LetsEncrypt certificates
+func collectCertificates() []sni.Certificates {
+	return append(diskCertificates(), certRenewal.Certificates()...)
+}
+
+// refreshCertificates swaps the certificates served by the SNI server,
+// certificates which could not be loaded are skipped and returned as
+// error
+func refreshCertificates() error {
+	certificateRefreshLock.Lock()
+	defer certificateRefreshLock.Unlock()
+
+	if err := sniServer.UpdateCertificates(collectCertificates()); err != nil {
+		return err
+	}
+
+	// Certificates with different key types share their names, the
+	// first one to expire is reported
+	expiry := make(map[string]time.Time)
+	for _, c := range sniServer.LoadedCertificates() {
+		names := strings.Join(c.Names, ",")
+		if t, ok := expiry[names]; !ok || c.NotAfter.Before(t) {
+			expiry[names] = c.NotAfter
+		}
+	}
+
+	certExpiry.Reset()
+	for names, t := range expiry {
+		certExpiry.WithLabelValues(names).Set(float64(t.Unix()))
+	}
+	return nil
+}
+
+// certificateSignature summarizes everything a change of the served
+// certificates depends on: the configured files including their
+// modification and the LetsEncrypt domains
+func certificateSignature() string {
+	parts := []string{}
+	for _, c := range diskCertificates() {
+		for _, file := range []string{c.CertFile, c.KeyFile} {
+			if stat, err := os.Stat(file); err == nil {
+				parts = append(parts, fmt.Sprintf("%s:%d:%d", file, stat.ModTime().UnixNano(), stat.Size()))
+			} else {
+				parts = append(parts, file+":missing")
+			}
+		}
+	}
+	sort.Strings(parts)
+	parts = append(parts, letsEncryptDomains()...)
+	return strings.Join(parts, "\n")
+}
+
+// watchCertificates refreshes the certificates whenever certificate
+// files change or domains are added or removed in the configuration
+func watchCertificates() {
+	last := certificateSignature()
+	for range time.Tick(certificateWatchInterval) {
+		// Hosts whose containers are gone do not get renewed
+		if onDemandTLS.Prune() {
+			certRenewal.Sync(letsEncryptGroups())
+		}
+
+		current := certificateSignature()
+		if current == last {
+			continue
+		}
+
+		certRenewal.Sync(letsEncryptGroups())
+		if err := refreshCertificates(); err != nil {
+			log.Printf("Unable to refresh certificates: %s", err)
+			continue
+		}
+		log.Printf("Reloaded certificates after configuration or file change")
+		last = current
+	}
+}
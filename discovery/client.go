@@ -0,0 +1,68 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// NewDockerClient creates a Client for the docker API of the host whose
+// requests fail after timeout, zero disables the timeout. The timeout
+// only limits connecting to the event stream, not the stream itself.
+func NewDockerClient(host Host, timeout time.Duration) (Client, error) {
+	var (
+		client *docker.Client
+		err    error
+	)
+	if host.Cert != "" {
+		client, err = docker.NewTLSClient(host.Endpoint, host.Cert, host.Key, host.CA)
+	} else {
+		client, err = docker.NewClient(host.Endpoint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client.Dialer.Timeout = timeout
+	client.HTTPClient.Timeout = timeout
+
+	endpoint, err := url.Parse(host.Endpoint)
+	if err != nil || endpoint.Scheme != "unix" {
+		return client, nil
+	}
+
+	// The HTTP client go-dockerclient uses for unix sockets can not be
+	// configured so the API is queried through a client dialing the
+	// socket instead of an address
+	api, err := docker.NewClient("http://docker")
+	if err != nil {
+		return nil, err
+	}
+	api.HTTPClient = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return client.Dialer.DialContext(ctx, "unix", endpoint.Path)
+			},
+		},
+	}
+	return unixClient{Client: client, api: api}, nil
+}
+
+// unixClient subscribes to events through the client of the socket and
+// sends all other requests through api
+type unixClient struct {
+	*docker.Client
+	api *docker.Client
+}
+
+func (c unixClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	return c.api.ListContainers(opts)
+}
+
+func (c unixClient) InspectContainer(id string) (*docker.Container, error) {
+	return c.api.InspectContainer(id)
+}
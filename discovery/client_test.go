@@ -0,0 +1,57 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+func TestDockerClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixServer := &httptest.Server{Listener: listener, Config: &http.Server{Handler: handler}}
+	unixServer.Start()
+	defer unixServer.Close()
+
+	// Closing the servers waits for the hanging requests
+	defer close(release)
+
+	for _, endpoint := range []string{server.URL, "unix://" + socket} {
+		client, err := NewDockerClient(Host{Endpoint: endpoint}, 50*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Unable to create client for %s: %s", endpoint, err)
+		}
+
+		start := time.Now()
+		if _, err := client.ListContainers(docker.ListContainersOptions{}); err == nil {
+			t.Errorf("Listing containers on %s did not fail", endpoint)
+		}
+		if _, err := client.InspectContainer("abc"); err == nil {
+			t.Errorf("Inspecting container on %s did not fail", endpoint)
+		}
+		if d := time.Since(start); d > 2*time.Second {
+			t.Errorf("Requests to %s took %s", endpoint, d)
+		}
+	}
+}
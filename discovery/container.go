@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// Labels describing how the proxy routes to a container
+const (
+	SlugLabel = "io.luzifer.dockerproxy.slug"
+	PortLabel = "io.luzifer.dockerproxy.port"
+)
+
+// Backend is a single container reachable for the proxy
+type Backend struct {
+	Slug    string
+	Address string
+	Labels  map[string]string
+}
+
+// Addressing describes how the containers of a docker daemon are reached
+type Addressing struct {
+	// Host is the host/ip the published ports of the containers are
+	// reachable on
+	Host string
+	// Network is the name of a docker network, if set the IP of the
+	// container in that network and its internal port are used instead
+	// of the published ports
+	Network string
+}
+
+// containerInfo is what is known about a container to build its
+// backend, filled from the container listing, an event or an inspect
+type containerInfo struct {
+	Labels map[string]string
+	// Env holds the ROUTER_* variables, nil if not inspected
+	Env   map[string]string
+	Ports []docker.APIPort
+	// IPs of the container by network name
+	IPs map[string]string
+}
+
+func infoFromAPIContainer(c docker.APIContainers) containerInfo {
+	info := containerInfo{
+		Labels: c.Labels,
+		Ports:  c.Ports,
+		IPs:    make(map[string]string),
+	}
+	for name, network := range c.Networks.Networks {
+		info.IPs[name] = network.IPAddress
+	}
+	return info
+}
+
+func infoFromContainer(c *docker.Container) containerInfo {
+	info := containerInfo{
+		Labels: c.Config.Labels,
+		Env:    routerEnv(c.Config.Env),
+		IPs:    make(map[string]string),
+	}
+	if c.NetworkSettings != nil {
+		info.Ports = c.NetworkSettings.PortMappingAPI()
+		for name, network := range c.NetworkSettings.Networks {
+			info.IPs[name] = network.IPAddress
+		}
+	}
+	if len(info.Ports) == 0 {
+		// Ports are not reported for containers which are not running
+		for port := range c.Config.ExposedPorts {
+			p, _ := strconv.ParseInt(port.Port(), 10, 64)
+			info.Ports = append(info.Ports, docker.APIPort{PrivatePort: p, Type: port.Proto()})
+		}
+	}
+	return info
+}
+
+// routerEnv reads the ROUTER_* variables from the environment of a
+// container
+func routerEnv(env []string) map[string]string {
+	result := make(map[string]string)
+	for _, envVar := range env {
+		t := strings.SplitN(envVar, "=", 2)
+		if len(t) == 2 && strings.HasPrefix(t[0], "ROUTER_") {
+			result[t[0]] = t[1]
+		}
+	}
+	return result
+}
+
+// routing returns slug and configured port of the container from its
+// labels or its environment
+func (c containerInfo) routing() (slug, port string, ok bool) {
+	// Load slug and port from container labels
+	if slug, ok := c.Labels[SlugLabel]; ok {
+		return slug, c.Labels[PortLabel], true
+	}
+
+	// Load ROUTER_SLUG and ROUTER_PORT from environment configuration of that container
+	if slug, ok := c.Env["ROUTER_SLUG"]; ok {
+		return slug, c.Env["ROUTER_PORT"], true
+	}
+
+	return "", "", false
+}
+
+// backend builds the backend of the container, it is not ok for
+// containers not routed by the proxy and returns an error for routed
+// containers which can't be addressed
+func (c containerInfo) backend(addr Addressing) (Backend, bool, error) {
+	slug, port, ok := c.routing()
+	if !ok {
+		return Backend{}, false, nil
+	}
+
+	host := addr.Host
+	if addr.Network != "" {
+		if host = c.IPs[addr.Network]; host == "" {
+			return Backend{}, false, fmt.Errorf("Container is not attached to network %s", addr.Network)
+		}
+	}
+
+	if port == "" {
+		// The internal port is used within the network, on the host
+		// the port it is published on
+		if port = detectPort(c.Ports, addr.Network == ""); port == "" {
+			return Backend{}, false, fmt.Errorf("Unable to detect port of slug %s", slug)
+		}
+	}
+
+	return Backend{
+		Slug:    slug,
+		Address: net.JoinHostPort(host, port),
+		Labels:  c.Labels,
+	}, true, nil
+}
+
+// detectPort picks the lowest exposed TCP port of the container, if
+// published is set only published ports are considered and the public
+// port is returned
+func detectPort(ports []docker.APIPort, published bool) string {
+	sorted := append([]docker.APIPort{}, ports...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PrivatePort < sorted[j].PrivatePort })
+
+	for _, p := range sorted {
+		if p.Type != "" && p.Type != "tcp" {
+			continue
+		}
+		switch {
+		case !published:
+			return strconv.FormatInt(p.PrivatePort, 10)
+		case p.PublicPort > 0:
+			return strconv.FormatInt(p.PublicPort, 10)
+		}
+	}
+	return ""
+}
+
+// BackendFromContainer reads slug and port of the container from its
+// labels or its environment and builds the backend address using addr.
+// Without configured port the lowest exposed TCP port is used.
+func BackendFromContainer(container *docker.Container, addr Addressing) (Backend, bool) {
+	if container == nil || container.Config == nil {
+		return Backend{}, false
+	}
+	backend, ok, err := infoFromContainer(container).backend(addr)
+	return backend, ok && err == nil
+}
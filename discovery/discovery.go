@@ -0,0 +1,188 @@
+package discovery
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Host describes a docker daemon to watch
+type Host struct {
+	// Endpoint is the address of the docker API
+	Endpoint string
+	// Address is the host/ip traffic to the containers is sent to
+	Address string
+	// Network is the docker network to address the containers in, empty
+	// to use Config.Network
+	Network string
+	// CA, Cert and Key are the files to authenticate against the docker
+	// API using TLS, all empty for plain connections
+	CA, Cert, Key string
+}
+
+func (h Host) key() string {
+	key := h.Endpoint + "=" + h.Address
+	if h.Network != "" {
+		key += "@" + h.Network
+	}
+	if h.Cert != "" {
+		// Changed certificates need a new client
+		key += "|" + h.CA + "|" + h.Cert + "|" + h.Key
+	}
+	return key
+}
+
+// ClientFactory creates a Client for the docker API of the host whose
+// requests fail after timeout
+type ClientFactory func(host Host, timeout time.Duration) (Client, error)
+
+// Config describes how docker daemons are queried and the handling of
+// unreachable daemons
+type Config struct {
+	// StaleAfter removes the containers of a docker daemon which could
+	// not be synced for this duration, zero keeps them forever
+	StaleAfter time.Duration
+	// Timeout limits every request to the docker API, zero disables it.
+	// It is passed to the ClientFactory so changing it replaces the
+	// clients of all hosts.
+	Timeout time.Duration
+	// LabelsOnly lets the docker daemon filter the containers by their
+	// slug label and disables the ROUTER_SLUG environment fallback
+	LabelsOnly bool
+	// Network is the docker network to address the containers in if
+	// the host does not configure one, empty to use published ports
+	Network string
+}
+
+// Discovery manages one Watcher per configured docker host
+type Discovery struct {
+	sync.Mutex
+	registry  *Registry
+	newClient ClientFactory
+	watchers  map[string]*Watcher
+	hosts     map[string]Host
+	timeout   time.Duration
+	// failed holds the status of hosts no client could be created for
+	failed map[string]HostStatus
+
+	// OnSuccess and OnError are called with the endpoint of the docker
+	// daemon after every successful sync and for every error
+	OnSuccess func(endpoint string)
+	OnError   func(endpoint string, err error)
+}
+
+// New creates a Discovery feeding the given registry
+func New(registry *Registry, newClient ClientFactory) *Discovery {
+	return &Discovery{
+		registry:  registry,
+		newClient: newClient,
+		watchers:  make(map[string]*Watcher),
+		hosts:     make(map[string]Host),
+		failed:    make(map[string]HostStatus),
+	}
+}
+
+// Sync starts watchers for new docker hosts and stops those for hosts
+// no longer present in the list
+func (d *Discovery) Sync(hosts []Host, cfg Config) {
+	d.Lock()
+	defer d.Unlock()
+
+	wanted := make(map[string]Host)
+	for _, host := range hosts {
+		wanted[host.key()] = host
+	}
+
+	for key, watcher := range d.watchers {
+		if _, ok := wanted[key]; !ok {
+			watcher.Stop()
+			d.registry.RemoveHost(key)
+			delete(d.watchers, key)
+			delete(d.hosts, key)
+		}
+	}
+	d.failed = make(map[string]HostStatus)
+
+	// The containers and the status of replaced watchers are kept until
+	// the new client synced
+	previous := make(map[string]HostStatus)
+	if cfg.Timeout != d.timeout {
+		for key, watcher := range d.watchers {
+			watcher.Stop()
+			previous[key] = watcher.Status()
+			delete(d.watchers, key)
+		}
+		d.timeout = cfg.Timeout
+	}
+
+	for key, host := range wanted {
+		if watcher, ok := d.watchers[key]; ok {
+			watcher.SetConfig(cfg)
+			continue
+		}
+
+		client, err := d.newClient(host, cfg.Timeout)
+		if err != nil {
+			log.Printf("[Docker] Unable to create client for %s: %s", host.Endpoint, err)
+			// Containers of a replaced watcher would never be updated
+			d.registry.RemoveHost(key)
+			delete(d.hosts, key)
+			d.failed[key] = HostStatus{
+				Endpoint:    host.Endpoint,
+				Address:     host.Address,
+				LastError:   err.Error(),
+				LastErrorAt: time.Now(),
+				Errors:      1,
+			}
+			if d.OnError != nil {
+				d.OnError(host.Endpoint, err)
+			}
+			continue
+		}
+
+		watcher := NewWatcher(key, Addressing{Host: host.Address, Network: host.Network}, client, d.registry)
+		watcher.SetConfig(cfg)
+		if status, ok := previous[key]; ok {
+			watcher.status = status
+		}
+		endpoint := host.Endpoint
+		if d.OnSuccess != nil {
+			onSuccess := d.OnSuccess
+			watcher.OnSuccess = func() { onSuccess(endpoint) }
+		}
+		if d.OnError != nil {
+			onError := d.OnError
+			watcher.OnError = func(err error) { onError(endpoint, err) }
+		}
+		d.watchers[key] = watcher
+		d.hosts[key] = host
+		go watcher.Run()
+	}
+}
+
+// Status returns the state of all configured docker daemons ordered by
+// their endpoint
+func (d *Discovery) Status() []HostStatus {
+	d.Lock()
+	defer d.Unlock()
+
+	result := []HostStatus{}
+	for key, watcher := range d.watchers {
+		status := watcher.Status()
+		status.Endpoint = d.hosts[key].Endpoint
+		status.Address = d.hosts[key].Address
+		result = append(result, status)
+	}
+	for _, status := range d.failed {
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Endpoint != result[j].Endpoint {
+			return result[i].Endpoint < result[j].Endpoint
+		}
+		return result[i].Address < result[j].Address
+	})
+	return result
+}
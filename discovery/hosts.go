@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default ports of the docker API
+const (
+	DefaultPort    = 2375
+	DefaultTLSPort = 2376
+)
+
+// HostTLS holds the files to authenticate against a docker API
+// protected by TLS client certificates
+type HostTLS struct {
+	CA   string `json:"ca" yaml:"ca"`
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+	// CertPath is a directory containing ca.pem, cert.pem and key.pem
+	// like DOCKER_CERT_PATH, single files take precedence
+	CertPath string `json:"cert_path" yaml:"cert_path"`
+}
+
+// HostConfig describes a docker daemon in the configuration. In its
+// short form the config is a string containing the Address.
+type HostConfig struct {
+	// Endpoint of the docker API like "unix:///var/run/docker.sock",
+	// "tcp://10.0.0.1:2375", "tcp+tls://docker01:2376" or "docker01:2375",
+	// defaults to the name of the host in the configuration
+	Endpoint string `json:"endpoint" yaml:"endpoint"`
+	// Address is the host/ip traffic to the containers is sent to,
+	// defaults to the host of a TCP endpoint or 127.0.0.1
+	Address string `json:"address" yaml:"address"`
+	// Port of the docker API if not given in the endpoint
+	Port int      `json:"port" yaml:"port"`
+	TLS  *HostTLS `json:"tls" yaml:"tls"`
+	// Network addresses the containers by their IP in this docker
+	// network instead of the published ports
+	Network string `json:"network" yaml:"network"`
+}
+
+// UnmarshalJSON reads the short form or the full config
+func (h *HostConfig) UnmarshalJSON(data []byte) error {
+	var address string
+	if err := json.Unmarshal(data, &address); err == nil {
+		*h = HostConfig{Address: address}
+		return nil
+	}
+	type plain HostConfig
+	return json.Unmarshal(data, (*plain)(h))
+}
+
+// UnmarshalYAML reads the short form or the full config
+func (h *HostConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var address string
+	if err := unmarshal(&address); err == nil {
+		*h = HostConfig{Address: address}
+		return nil
+	}
+	type plain HostConfig
+	return unmarshal((*plain)(h))
+}
+
+// ParseHosts builds the hosts to watch from the configuration. Without
+// configured hosts a host is derived from DOCKER_HOST, DOCKER_CERT_PATH
+// and DOCKER_TLS_VERIFY read through getenv.
+func ParseHosts(configs map[string]HostConfig, defaultPort int, getenv func(string) string) ([]Host, error) {
+	if len(configs) == 0 {
+		if endpoint := getenv("DOCKER_HOST"); endpoint != "" {
+			cfg := HostConfig{Endpoint: endpoint}
+			certPath := getenv("DOCKER_CERT_PATH")
+			if certPath != "" || getenv("DOCKER_TLS_VERIFY") != "" {
+				if certPath == "" {
+					certPath = filepath.Join(getenv("HOME"), ".docker")
+				}
+				cfg.TLS = &HostTLS{CertPath: certPath}
+			}
+			configs = map[string]HostConfig{"DOCKER_HOST": cfg}
+		}
+	}
+
+	names := []string{}
+	for name := range configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hosts := []Host{}
+	for _, name := range names {
+		host, err := parseHost(name, configs[name], defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid docker host %s: %s", name, err)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// ParseHostsFromEnv is ParseHosts reading the environment of the process
+func ParseHostsFromEnv(configs map[string]HostConfig, defaultPort int) ([]Host, error) {
+	return ParseHosts(configs, defaultPort, os.Getenv)
+}
+
+func parseHost(name string, cfg HostConfig, defaultPort int) (Host, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = name
+	}
+
+	scheme, rest := "tcp", endpoint
+	if idx := strings.Index(endpoint, "://"); idx >= 0 {
+		scheme, rest = endpoint[:idx], endpoint[idx+3:]
+	}
+
+	host := Host{Address: cfg.Address, Network: cfg.Network}
+	useTLS := cfg.TLS != nil
+
+	switch scheme {
+	case "unix":
+		if useTLS {
+			return host, fmt.Errorf("TLS is not supported for unix sockets")
+		}
+		host.Endpoint = endpoint
+		if host.Address == "" {
+			host.Address = "127.0.0.1"
+		}
+		return host, nil
+
+	case "tcp+tls":
+		if !useTLS {
+			return host, fmt.Errorf("tcp+tls requires a tls configuration")
+		}
+	case "tcp":
+	default:
+		return host, fmt.Errorf("Unsupported endpoint scheme %q", scheme)
+	}
+
+	hostname, port, err := net.SplitHostPort(rest)
+	if err != nil {
+		// No port given in the endpoint
+		hostname = strings.TrimSuffix(strings.TrimPrefix(rest, "["), "]")
+		switch {
+		case cfg.Port > 0:
+			port = strconv.Itoa(cfg.Port)
+		case defaultPort > 0:
+			port = strconv.Itoa(defaultPort)
+		case useTLS:
+			port = strconv.Itoa(DefaultTLSPort)
+		default:
+			port = strconv.Itoa(DefaultPort)
+		}
+	}
+	if hostname == "" {
+		return host, fmt.Errorf("No host given in endpoint %q", endpoint)
+	}
+
+	host.Endpoint = "tcp://" + net.JoinHostPort(hostname, port)
+	if host.Address == "" {
+		host.Address = hostname
+	}
+
+	if useTLS {
+		host.CA = tlsFile(cfg.TLS.CA, cfg.TLS.CertPath, "ca.pem")
+		host.Cert = tlsFile(cfg.TLS.Cert, cfg.TLS.CertPath, "cert.pem")
+		host.Key = tlsFile(cfg.TLS.Key, cfg.TLS.CertPath, "key.pem")
+		if host.CA == "" || host.Cert == "" || host.Key == "" {
+			return host, fmt.Errorf("TLS requires ca, cert and key or a cert_path")
+		}
+	}
+
+	return host, nil
+}
+
+func tlsFile(file, certPath, name string) string {
+	if file != "" || certPath == "" {
+		return file
+	}
+	return filepath.Join(certPath, name)
+}
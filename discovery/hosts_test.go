@@ -0,0 +1,98 @@
+package discovery
+
+import (
+	"encoding/json"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestHostConfigShortForm(t *testing.T) {
+	var fromYAML struct {
+		Hosts map[string]HostConfig `yaml:"hosts"`
+	}
+	if err := yaml.Unmarshal([]byte("hosts:\n  localhost: docker01.example.com\n  secure:\n    endpoint: tcp+tls://10.0.0.2\n    tls:\n      cert_path: /certs\n"), &fromYAML); err != nil {
+		t.Fatalf("Unable to read YAML: %s", err)
+	}
+	if fromYAML.Hosts["localhost"].Address != "docker01.example.com" || fromYAML.Hosts["secure"].TLS == nil {
+		t.Errorf("Unexpected YAML config: %#v", fromYAML.Hosts)
+	}
+
+	var fromJSON map[string]HostConfig
+	if err := json.Unmarshal([]byte(`{"localhost": "docker01.example.com", "sock": {"endpoint": "unix:///var/run/docker.sock"}}`), &fromJSON); err != nil {
+		t.Fatalf("Unable to read JSON: %s", err)
+	}
+	if fromJSON["localhost"].Address != "docker01.example.com" || fromJSON["sock"].Endpoint != "unix:///var/run/docker.sock" {
+		t.Errorf("Unexpected JSON config: %#v", fromJSON)
+	}
+}
+
+func TestParseHosts(t *testing.T) {
+	noEnv := func(string) string { return "" }
+
+	hosts, err := ParseHosts(map[string]HostConfig{
+		"localhost":     {Address: "docker01.example.com"},
+		"docker02":      {Port: 2380},
+		"docker03:4243": {},
+		"sock":          {Endpoint: "unix:///var/run/docker.sock", Address: "10.0.0.3"},
+		"secure":        {Endpoint: "tcp+tls://10.0.0.4", TLS: &HostTLS{CertPath: "/certs", CA: "/ca.pem"}},
+	}, 9999, noEnv)
+	if err != nil {
+		t.Fatalf("Unable to parse hosts: %s", err)
+	}
+
+	expected := []Host{
+		{Endpoint: "tcp://docker02:2380", Address: "docker02"},
+		{Endpoint: "tcp://docker03:4243", Address: "docker03"},
+		{Endpoint: "tcp://localhost:9999", Address: "docker01.example.com"},
+		{Endpoint: "tcp://10.0.0.4:9999", Address: "10.0.0.4", CA: "/ca.pem", Cert: "/certs/cert.pem", Key: "/certs/key.pem"},
+		{Endpoint: "unix:///var/run/docker.sock", Address: "10.0.0.3"},
+	}
+	if len(hosts) != len(expected) {
+		t.Fatalf("Expected %d hosts, got %#v", len(expected), hosts)
+	}
+	for i := range expected {
+		if hosts[i] != expected[i] {
+			t.Errorf("Expected %#v, got %#v", expected[i], hosts[i])
+		}
+	}
+
+	for name, cfg := range map[string]HostConfig{
+		"tls without files": {Endpoint: "tcp+tls://10.0.0.4"},
+		"incomplete tls":    {Endpoint: "tcp://10.0.0.4", TLS: &HostTLS{Cert: "/cert.pem"}},
+		"unix with tls":     {Endpoint: "unix:///var/run/docker.sock", TLS: &HostTLS{CertPath: "/certs"}},
+		"unknown scheme":    {Endpoint: "ssh://docker01"},
+	} {
+		if _, err := ParseHosts(map[string]HostConfig{"host": cfg}, 0, noEnv); err == nil {
+			t.Errorf("Config %q was accepted", name)
+		}
+	}
+}
+
+func TestParseHostsFromDockerEnv(t *testing.T) {
+	env := map[string]string{
+		"DOCKER_HOST":       "tcp://192.168.99.100:2376",
+		"DOCKER_TLS_VERIFY": "1",
+		"HOME":              "/home/user",
+	}
+	hosts, err := ParseHosts(nil, 0, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("Unable to parse hosts: %s", err)
+	}
+	expected := Host{
+		Endpoint: "tcp://192.168.99.100:2376",
+		Address:  "192.168.99.100",
+		CA:       "/home/user/.docker/ca.pem",
+		Cert:     "/home/user/.docker/cert.pem",
+		Key:      "/home/user/.docker/key.pem",
+	}
+	if len(hosts) != 1 || hosts[0] != expected {
+		t.Errorf("Unexpected hosts %#v", hosts)
+	}
+
+	// Configured hosts take precedence
+	hosts, _ = ParseHosts(map[string]HostConfig{"unix:///var/run/docker.sock": {}}, 0, func(key string) string { return env[key] })
+	if len(hosts) != 1 || hosts[0].Endpoint != "unix:///var/run/docker.sock" || hosts[0].Address != "127.0.0.1" {
+		t.Errorf("Unexpected hosts %#v", hosts)
+	}
+}
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"sort"
+	"sync"
+)
+
+// Containers maps a slug to the addresses of all backends serving it
+type Containers map[string][]string
+
+// Registry holds the backends of all watched docker hosts and publishes
+// them as a Containers routing table
+type Registry struct {
+	sync.RWMutex
+	hosts       map[string]map[string]Backend
+	table       Containers
+	bySlug      map[string][]Backend
+	subscribers []chan struct{}
+}
+
+// NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{
+		hosts:  make(map[string]map[string]Backend),
+		table:  make(Containers),
+		bySlug: make(map[string][]Backend),
+	}
+}
+
+// Get returns the backend addresses currently known for the slug
+func (r *Registry) Get(slug string) ([]string, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	target, ok := r.table[slug]
+	return target, ok
+}
+
+// Lookup returns the backends currently known for the slug ordered by
+// their address
+func (r *Registry) Lookup(slug string) []Backend {
+	r.RLock()
+	defer r.RUnlock()
+	return append([]Backend{}, r.bySlug[slug]...)
+}
+
+// Containers returns a copy of the whole routing table
+func (r *Registry) Containers() Containers {
+	r.RLock()
+	defer r.RUnlock()
+	result := make(Containers, len(r.table))
+	for slug, target := range r.table {
+		result[slug] = append([]string{}, target...)
+	}
+	return result
+}
+
+// Backends returns all currently known backends
+func (r *Registry) Backends() []Backend {
+	r.RLock()
+	defer r.RUnlock()
+	result := []Backend{}
+	for _, backends := range r.hosts {
+		for _, backend := range backends {
+			result = append(result, backend)
+		}
+	}
+	return result
+}
+
+// CountHost returns the number of backends known for a docker host
+func (r *Registry) CountHost(host string) int {
+	r.RLock()
+	defer r.RUnlock()
+	return len(r.hosts[host])
+}
+
+// Subscribe returns a channel receiving a notification whenever the
+// routing table changed. Notifications are coalesced if the receiver
+// is not ready.
+func (r *Registry) Subscribe() <-chan struct{} {
+	r.Lock()
+	defer r.Unlock()
+	ch := make(chan struct{}, 1)
+	r.subscribers = append(r.subscribers, ch)
+	return ch
+}
+
+// ReplaceHost sets the full list of backends of a docker host
+func (r *Registry) ReplaceHost(host string, backends map[string]Backend) {
+	r.Lock()
+	defer r.Unlock()
+	r.hosts[host] = backends
+	r.rebuild()
+}
+
+// RemoveHost drops all backends of a docker host
+func (r *Registry) RemoveHost(host string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.hosts, host)
+	r.rebuild()
+}
+
+// Set adds or updates a single container on a docker host
+func (r *Registry) Set(host, containerID string, backend Backend) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.hosts[host]; !ok {
+		r.hosts[host] = make(map[string]Backend)
+	}
+	r.hosts[host][containerID] = backend
+	r.rebuild()
+}
+
+// Remove drops a single container from a docker host
+func (r *Registry) Remove(host, containerID string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.hosts[host][containerID]; !ok {
+		return
+	}
+	delete(r.hosts[host], containerID)
+	r.rebuild()
+}
+
+func (r *Registry) rebuild() {
+	bySlug := make(map[string][]Backend)
+	for _, backends := range r.hosts {
+		for _, backend := range backends {
+			bySlug[backend.Slug] = append(bySlug[backend.Slug], backend)
+		}
+	}
+
+	table := make(Containers)
+	for slug, backends := range bySlug {
+		sort.Slice(backends, func(i, j int) bool { return backends[i].Address < backends[j].Address })
+		for _, backend := range backends {
+			table[slug] = append(table[slug], backend.Address)
+		}
+	}
+	r.table = table
+	r.bySlug = bySlug
+
+	for _, ch := range r.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
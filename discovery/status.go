@@ -0,0 +1,20 @@
+package discovery
+
+import "time"
+
+// HostStatus describes the connection to a docker daemon
+type HostStatus struct {
+	Endpoint string `json:"endpoint"`
+	Address  string `json:"address"`
+	// Connected is set while the event stream of the daemon is received
+	Connected bool `json:"connected"`
+	// Stale is set if the containers of the daemon were removed as it
+	// could not be synced within the staleness limit
+	Stale bool `json:"stale"`
+	// LastSuccess is the last time the known containers were up to date
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastErrorAt time.Time `json:"last_error_at"`
+	Errors      int       `json:"errors"`
+	Backends    int       `json:"backends"`
+}
@@ -0,0 +1,394 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+const (
+	watcherMinBackoff = time.Second
+	watcherMaxBackoff = 2 * time.Minute
+)
+
+// Client is the subset of the go-dockerclient API used for the container
+// discovery. It is satisfied by *docker.Client and exists to be able to
+// drive the discovery with fake events.
+type Client interface {
+	ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error)
+	InspectContainer(id string) (*docker.Container, error)
+	AddEventListener(listener chan<- *docker.APIEvents) error
+	RemoveEventListener(listener chan *docker.APIEvents) error
+}
+
+// Watcher keeps the Registry in sync with one docker daemon by
+// subscribing to its event stream
+type Watcher struct {
+	key      string
+	addr     Addressing
+	client   Client
+	registry *Registry
+
+	// OnSuccess and OnError are called after every successful resync
+	// and for every failure talking to the docker daemon
+	OnSuccess func()
+	OnError   func(err error)
+
+	statusLock sync.Mutex
+	status     HostStatus
+	config     Config
+
+	// inspected caches the ROUTER_* environment of inspected containers
+	// by their ID, only used from the Run goroutine
+	inspected map[string]map[string]string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWatcher creates a Watcher storing the containers of client under
+// key in the registry. Backends are addressed using addr.
+func NewWatcher(key string, addr Addressing, client Client, registry *Registry) *Watcher {
+	return &Watcher{
+		key:      key,
+		addr:     addr,
+		client:   client,
+		registry: registry,
+
+		inspected: make(map[string]map[string]string),
+
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+func (w *Watcher) log(format string, args ...interface{}) {
+	log.Printf("[Docker] "+format, args...)
+}
+
+// SetConfig updates the query and staleness configuration, it takes
+// effect on the next call to the docker daemon
+func (w *Watcher) SetConfig(cfg Config) {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+	w.config = cfg
+}
+
+func (w *Watcher) currentConfig() Config {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+	return w.config
+}
+
+// addressing applies the default network of the config to the
+// addressing of the host
+func (w *Watcher) addressing(cfg Config) Addressing {
+	addr := w.addr
+	if addr.Network == "" {
+		addr.Network = cfg.Network
+	}
+	return addr
+}
+
+// Status returns the state of the connection to the docker daemon
+func (w *Watcher) Status() HostStatus {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+	status := w.status
+	status.Backends = w.registry.CountHost(w.key)
+	return status
+}
+
+func (w *Watcher) reportSuccess() {
+	w.statusLock.Lock()
+	w.status.Connected = true
+	w.status.Stale = false
+	w.status.LastSuccess = time.Now()
+	w.statusLock.Unlock()
+
+	if w.OnSuccess != nil {
+		w.OnSuccess()
+	}
+}
+
+func (w *Watcher) reportError(err error) {
+	w.statusLock.Lock()
+	if w.status.Connected {
+		// The snapshot was up to date until the connection dropped
+		w.status.LastSuccess = time.Now()
+	}
+	w.status.Connected = false
+	w.status.LastError = err.Error()
+	w.status.LastErrorAt = time.Now()
+	w.status.Errors++
+	w.statusLock.Unlock()
+
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
+
+// staleTimer fires when the last snapshot of the docker daemon exceeds
+// the staleness limit, it returns nil if the snapshot is already
+// dropped or kept forever
+func (w *Watcher) staleTimer() <-chan time.Time {
+	w.statusLock.Lock()
+	defer w.statusLock.Unlock()
+	if w.status.Stale || w.config.StaleAfter <= 0 {
+		return nil
+	}
+	remaining := w.config.StaleAfter - time.Since(w.status.LastSuccess)
+	if w.status.LastSuccess.IsZero() || remaining < 0 {
+		remaining = 0
+	}
+	return time.After(remaining)
+}
+
+// dropStale removes the containers of the docker daemon from the
+// registry as they are not known to be up to date anymore
+func (w *Watcher) dropStale() {
+	w.statusLock.Lock()
+	w.status.Stale = true
+	w.statusLock.Unlock()
+
+	if w.registry.CountHost(w.key) > 0 {
+		w.log("Removing containers of %s, no successful sync for %s", w.key, w.currentConfig().StaleAfter)
+	}
+	w.registry.RemoveHost(w.key)
+}
+
+// Run subscribes to the event stream and reconnects with backoff until
+// Stop is called. Every (re)connect triggers a full resync.
+func (w *Watcher) Run() {
+	defer close(w.done)
+
+	backoff := watcherMinBackoff
+	for {
+		connected, err := w.watch()
+		if connected {
+			backoff = watcherMinBackoff
+		}
+
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		w.reportError(err)
+		w.log("Event stream of %s dropped (%v), reconnecting in %s", w.key, err, backoff)
+		wait := time.After(backoff)
+		for waiting := true; waiting; {
+			select {
+			case <-w.stop:
+				return
+			case <-w.staleTimer():
+				w.dropStale()
+			case <-wait:
+				waiting = false
+			}
+		}
+
+		backoff *= 2
+		if backoff > watcherMaxBackoff {
+			backoff = watcherMaxBackoff
+		}
+	}
+}
+
+// Stop terminates the Watcher and waits for it to exit
+func (w *Watcher) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *Watcher) watch() (bool, error) {
+	events := make(chan *docker.APIEvents, 10)
+	if err := w.client.AddEventListener(events); err != nil {
+		return false, err
+	}
+	defer w.removeListener(events)
+
+	// Subscribe before the resync to not lose events between both
+	if err := w.resync(); err != nil {
+		return false, err
+	}
+	w.reportSuccess()
+
+	for {
+		select {
+		case <-w.stop:
+			return true, nil
+		case ev, ok := <-events:
+			if !ok || ev == docker.EOFEvent {
+				return true, fmt.Errorf("event stream closed")
+			}
+			w.handleEvent(ev)
+		}
+	}
+}
+
+// removeListener unsubscribes from the event stream. The docker client
+// holds the lock RemoveEventListener needs while it blocks sending to a
+// full listener, so the events are drained until it returned.
+func (w *Watcher) removeListener(events chan *docker.APIEvents) {
+	removed := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-events:
+			case <-removed:
+				return
+			}
+		}
+	}()
+	w.client.RemoveEventListener(events)
+	close(removed)
+}
+
+// resync does a full listing of the containers of the docker host
+func (w *Watcher) resync() error {
+	cfg := w.currentConfig()
+
+	opts := docker.ListContainersOptions{}
+	if cfg.LabelsOnly {
+		opts.Filters = map[string][]string{"label": {SlugLabel}}
+	}
+	apiContainers, err := w.client.ListContainers(opts)
+	if err != nil {
+		return err
+	}
+
+	addr := w.addressing(cfg)
+	backends := make(map[string]Backend)
+	listed := make(map[string]bool)
+	for _, apiContainer := range apiContainers {
+		listed[apiContainer.ID] = true
+		if strings.Contains(apiContainer.Status, "(unhealthy)") {
+			continue
+		}
+
+		info := infoFromAPIContainer(apiContainer)
+		if _, ok := info.Labels[SlugLabel]; !ok {
+			if cfg.LabelsOnly {
+				continue
+			}
+			// Only the environment fallback requires an inspect
+			env, err := w.inspectEnv(apiContainer.ID)
+			if err != nil {
+				continue
+			}
+			info.Env = env
+		}
+
+		if backend, ok := w.backend(apiContainer.ID, info, addr); ok {
+			backends[apiContainer.ID] = backend
+		}
+	}
+
+	for id := range w.inspected {
+		if !listed[id] {
+			delete(w.inspected, id)
+		}
+	}
+
+	w.registry.ReplaceHost(w.key, backends)
+	return nil
+}
+
+// backend builds the backend of the container and logs routed
+// containers which can't be addressed
+func (w *Watcher) backend(id string, info containerInfo, addr Addressing) (Backend, bool) {
+	backend, ok, err := info.backend(addr)
+	if err != nil {
+		w.log("Unable to address container %s on %s: %s", id, w.key, err)
+	}
+	return backend, ok
+}
+
+// inspectEnv returns the ROUTER_* environment of the container, it
+// never changes so it is cached by the ID of the container
+func (w *Watcher) inspectEnv(id string) (map[string]string, error) {
+	if env, ok := w.inspected[id]; ok {
+		return env, nil
+	}
+	info, err := w.inspect(id)
+	return info.Env, err
+}
+
+func (w *Watcher) inspect(id string) (containerInfo, error) {
+	container, err := w.client.InspectContainer(id)
+	if err == nil && container.Config == nil {
+		err = fmt.Errorf("No config returned")
+	}
+	if err != nil {
+		w.log("Unable to inspect container %s on %s: %s", id, w.key, err)
+		if w.OnError != nil {
+			w.OnError(err)
+		}
+		return containerInfo{}, err
+	}
+
+	info := infoFromContainer(container)
+	w.inspected[id] = info.Env
+	return info, nil
+}
+
+func (w *Watcher) handleEvent(ev *docker.APIEvents) {
+	if ev.Type != "" && ev.Type != "container" {
+		return
+	}
+
+	id := ev.Actor.ID
+	if id == "" {
+		id = ev.ID
+	}
+	action := ev.Action
+	if action == "" {
+		action = ev.Status
+	}
+
+	switch {
+	case action == "start", action == "health_status: healthy":
+		w.handleStart(id, ev.Actor.Attributes)
+
+	case action == "die", action == "stop", action == "health_status: unhealthy":
+		w.registry.Remove(w.key, id)
+
+	case action == "destroy":
+		w.registry.Remove(w.key, id)
+		delete(w.inspected, id)
+	}
+}
+
+// handleStart adds a started or recovered container. The attributes of
+// the event carry the labels of the container, it is only inspected if
+// they are not sufficient to address it.
+func (w *Watcher) handleStart(id string, attributes map[string]string) {
+	cfg := w.currentConfig()
+	addr := w.addressing(cfg)
+
+	info := containerInfo{Labels: attributes}
+	if !cfg.LabelsOnly {
+		info.Env = w.inspected[id]
+	}
+
+	_, port, routed := info.routing()
+	if !routed && (cfg.LabelsOnly || info.Env != nil) {
+		return
+	}
+	if !routed || port == "" || addr.Network != "" {
+		var err error
+		if info, err = w.inspect(id); err != nil {
+			return
+		}
+	}
+
+	if backend, ok := w.backend(id, info, addr); ok {
+		w.registry.Set(w.key, id, backend)
+	}
+}
@@ -0,0 +1,438 @@
+package discovery
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+type fakeClient struct {
+	sync.Mutex
+	containers  map[string]*docker.Container
+	listener    chan<- *docker.APIEvents
+	listings    int
+	inspections int
+	failing     bool
+	// sendLock is held while sending events like the listener lock of
+	// go-dockerclient
+	sendLock sync.RWMutex
+	flooding bool
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{containers: make(map[string]*docker.Container)}
+}
+
+func (f *fakeClient) addContainer(id, slug, port string) {
+	f.Lock()
+	defer f.Unlock()
+	f.containers[id] = &docker.Container{
+		ID: id,
+		Config: &docker.Config{Labels: map[string]string{
+			"io.luzifer.dockerproxy.slug": slug,
+			"io.luzifer.dockerproxy.port": port,
+		}},
+	}
+}
+
+func (f *fakeClient) addEnvContainer(id, slug, port string) {
+	f.Lock()
+	defer f.Unlock()
+	f.containers[id] = &docker.Container{
+		ID:     id,
+		Config: &docker.Config{Env: []string{"ROUTER_SLUG=" + slug, "ROUTER_PORT=" + port}},
+	}
+}
+
+func (f *fakeClient) ListContainers(opts docker.ListContainersOptions) ([]docker.APIContainers, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.listings++
+	result := []docker.APIContainers{}
+	for id, c := range f.containers {
+		if len(opts.Filters["label"]) > 0 {
+			if _, ok := c.Config.Labels[opts.Filters["label"][0]]; !ok {
+				continue
+			}
+		}
+		apiContainer := docker.APIContainers{ID: id, Labels: c.Config.Labels}
+		if c.NetworkSettings != nil {
+			apiContainer.Ports = c.NetworkSettings.PortMappingAPI()
+			apiContainer.Networks.Networks = c.NetworkSettings.Networks
+		}
+		result = append(result, apiContainer)
+	}
+	return result, nil
+}
+
+func (f *fakeClient) InspectContainer(id string) (*docker.Container, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.inspections++
+	if c, ok := f.containers[id]; ok {
+		return c, nil
+	}
+	return nil, &docker.NoSuchContainer{ID: id}
+}
+
+func (f *fakeClient) AddEventListener(listener chan<- *docker.APIEvents) error {
+	f.Lock()
+	defer f.Unlock()
+	if f.failing {
+		return errors.New("connection refused")
+	}
+	f.listener = listener
+	return nil
+}
+
+func (f *fakeClient) RemoveEventListener(listener chan *docker.APIEvents) error {
+	f.Lock()
+	flooding := f.flooding
+	f.Unlock()
+	// Events arriving faster than the listener is removed fill it up
+	for i := 0; flooding && i < 20 && len(listener) < cap(listener); i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	f.sendLock.Lock()
+	defer f.sendLock.Unlock()
+	f.Lock()
+	defer f.Unlock()
+	f.listener = nil
+	return nil
+}
+
+// flood sends events until the listener is removed
+func (f *fakeClient) flood() {
+	f.Lock()
+	f.flooding = true
+	f.Unlock()
+
+	for {
+		f.sendLock.RLock()
+		f.Lock()
+		l := f.listener
+		f.Unlock()
+		if l == nil {
+			f.sendLock.RUnlock()
+			return
+		}
+		l <- &docker.APIEvents{Type: "container", Action: "exec_start", Actor: docker.APIActor{ID: "a"}}
+		f.sendLock.RUnlock()
+	}
+}
+
+func (f *fakeClient) emit(action, id string) {
+	f.Lock()
+	l := f.listener
+	var attributes map[string]string
+	if c, ok := f.containers[id]; ok {
+		attributes = c.Config.Labels
+	}
+	f.Unlock()
+	l <- &docker.APIEvents{Type: "container", Action: action, Actor: docker.APIActor{ID: id, Attributes: attributes}}
+}
+
+func (f *fakeClient) inspectCount() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.inspections
+}
+
+func (f *fakeClient) drop() {
+	f.Lock()
+	defer f.Unlock()
+	close(f.listener)
+	f.listener = nil
+}
+
+func (f *fakeClient) subscribed() bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.listener != nil
+}
+
+func waitFor(t *testing.T, desc string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", desc)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func hasTarget(r *Registry, slug string, expected []string) func() bool {
+	return func() bool {
+		target, ok := r.Get(slug)
+		if expected == nil {
+			return !ok
+		}
+		return reflect.DeepEqual(target, expected)
+	}
+}
+
+func TestWatcherEvents(t *testing.T) {
+	client := newFakeClient()
+	client.addContainer("a", "app", "8080")
+
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "subscription", client.subscribed)
+	waitFor(t, "initial resync", hasTarget(registry, "app", []string{"docker01:8080"}))
+
+	client.addContainer("b", "app", "8081")
+	client.emit("start", "b")
+	waitFor(t, "started container", hasTarget(registry, "app", []string{"docker01:8080", "docker01:8081"}))
+
+	client.emit("die", "a")
+	waitFor(t, "died container", hasTarget(registry, "app", []string{"docker01:8081"}))
+
+	client.emit("health_status: unhealthy", "b")
+	waitFor(t, "unhealthy container", hasTarget(registry, "app", nil))
+
+	client.emit("health_status: healthy", "b")
+	waitFor(t, "recovered container", hasTarget(registry, "app", []string{"docker01:8081"}))
+}
+
+func TestWatcherResyncOnDrop(t *testing.T) {
+	client := newFakeClient()
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "subscription", client.subscribed)
+
+	// Container starts while the stream is down and must be picked up by the resync
+	client.drop()
+	client.addContainer("c", "other", "80")
+
+	waitFor(t, "resync after reconnect", hasTarget(registry, "other", []string{"docker01:80"}))
+
+	client.Lock()
+	defer client.Unlock()
+	if client.listings < 2 {
+		t.Errorf("Expected a full resync after reconnect, got %d listings", client.listings)
+	}
+}
+
+func TestWatcherDropsStaleHost(t *testing.T) {
+	client := newFakeClient()
+	client.addContainer("a", "app", "8080")
+
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	watcher.SetConfig(Config{StaleAfter: 100 * time.Millisecond})
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "initial resync", hasTarget(registry, "app", []string{"docker01:8080"}))
+	if s := watcher.Status(); !s.Connected || s.LastSuccess.IsZero() || s.Backends != 1 {
+		t.Errorf("Unexpected status after sync: %#v", s)
+	}
+
+	// The snapshot is kept while the daemon is unreachable until it is stale
+	client.Lock()
+	client.failing = true
+	client.Unlock()
+	client.drop()
+
+	waitFor(t, "disconnect", func() bool { return !watcher.Status().Connected })
+	if _, ok := registry.Get("app"); !ok && !watcher.Status().Stale {
+		t.Errorf("Snapshot was dropped before it was stale")
+	}
+	waitFor(t, "stale snapshot", hasTarget(registry, "app", nil))
+
+	s := watcher.Status()
+	if !s.Stale || s.Errors == 0 || s.LastError == "" || s.Backends != 0 {
+		t.Errorf("Unexpected status of stale host: %#v", s)
+	}
+}
+
+func TestWatcherInspectsOnlyEnvContainers(t *testing.T) {
+	client := newFakeClient()
+	client.addContainer("a", "app", "8080")
+	client.addEnvContainer("e", "legacy", "80")
+
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "initial resync", hasTarget(registry, "legacy", []string{"docker01:80"}))
+	if n := client.inspectCount(); n != 1 {
+		t.Errorf("Expected only the env container to be inspected, got %d inspections", n)
+	}
+
+	// Labels are taken from the event, the env container from the cache
+	client.addContainer("b", "app", "8081")
+	client.emit("start", "b")
+	client.emit("health_status: healthy", "e")
+	waitFor(t, "started container", hasTarget(registry, "app", []string{"docker01:8080", "docker01:8081"}))
+
+	client.drop()
+	waitFor(t, "resync", func() bool {
+		client.Lock()
+		listings := client.listings
+		client.Unlock()
+		return listings >= 2 && watcher.Status().Connected
+	})
+	if _, ok := registry.Get("legacy"); !ok {
+		t.Errorf("Env container is missing after resync")
+	}
+	if n := client.inspectCount(); n != 1 {
+		t.Errorf("Expected inspect results to be cached, got %d inspections", n)
+	}
+}
+
+func TestWatcherLabelsOnly(t *testing.T) {
+	client := newFakeClient()
+	client.addContainer("a", "app", "8080")
+	client.addEnvContainer("e", "legacy", "80")
+
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	watcher.SetConfig(Config{LabelsOnly: true})
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "initial resync", hasTarget(registry, "app", []string{"docker01:8080"}))
+	if _, ok := registry.Get("legacy"); ok {
+		t.Errorf("Env container was discovered with labels only")
+	}
+	if n := client.inspectCount(); n != 0 {
+		t.Errorf("Expected no inspections, got %d", n)
+	}
+}
+
+func TestBackendFromContainerEnv(t *testing.T) {
+	backend, ok := BackendFromContainer(&docker.Container{
+		Config: &docker.Config{Env: []string{"ROUTER_SLUG=envslug", "ROUTER_PORT=1234", "EMPTY"}},
+	}, Addressing{Host: "docker02"})
+	if !ok {
+		t.Fatalf("Container with ROUTER_SLUG was not detected")
+	}
+	if backend.Slug != "envslug" || backend.Address != "docker02:1234" {
+		t.Errorf("Unexpected backend: %#v", backend)
+	}
+
+	if _, ok := BackendFromContainer(nil, Addressing{Host: "docker02"}); ok {
+		t.Errorf("Nil container was accepted")
+	}
+}
+
+func TestWatcherStopWithFullListener(t *testing.T) {
+	client := newFakeClient()
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	go watcher.Run()
+
+	waitFor(t, "subscription", client.subscribed)
+	go client.flood()
+	waitFor(t, "flood", func() bool {
+		client.Lock()
+		defer client.Unlock()
+		return client.flooding
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		watcher.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Watcher did not stop while events were sent")
+	}
+}
+
+func TestBackendFromContainerAddressing(t *testing.T) {
+	container := &docker.Container{
+		Config: &docker.Config{Labels: map[string]string{"io.luzifer.dockerproxy.slug": "app"}},
+		NetworkSettings: &docker.NetworkSettings{
+			Networks: map[string]docker.ContainerNetwork{"backend": {IPAddress: "172.18.0.5"}},
+			Ports: map[docker.Port][]docker.PortBinding{
+				"9090/tcp": nil,
+				"8080/tcp": {{HostIP: "0.0.0.0", HostPort: "32768"}},
+				"53/udp":   {{HostIP: "0.0.0.0", HostPort: "53"}},
+			},
+		},
+	}
+
+	for addr, expected := range map[Addressing]string{
+		{Host: "docker01"}:                     "docker01:32768",
+		{Host: "docker01", Network: "backend"}: "172.18.0.5:8080",
+		{Host: "docker01", Network: "other"}:   "",
+	} {
+		backend, ok := BackendFromContainer(container, addr)
+		if expected == "" {
+			if ok {
+				t.Errorf("%#v: unexpected backend %#v", addr, backend)
+			}
+			continue
+		}
+		if !ok || backend.Address != expected {
+			t.Errorf("%#v: expected %s, got %#v", addr, expected, backend)
+		}
+	}
+
+	// A configured port is used as the internal port within the network
+	container.Config.Labels["io.luzifer.dockerproxy.port"] = "9090"
+	if backend, _ := BackendFromContainer(container, Addressing{Network: "backend"}); backend.Address != "172.18.0.5:9090" {
+		t.Errorf("Unexpected backend %#v", backend)
+	}
+
+	// Exposed ports are used for containers without port mapping
+	noPorts := &docker.Container{Config: &docker.Config{
+		Env:          []string{"ROUTER_SLUG=app"},
+		ExposedPorts: map[docker.Port]struct{}{"3000/tcp": {}},
+	}}
+	if _, ok := BackendFromContainer(noPorts, Addressing{Host: "docker01"}); ok {
+		t.Errorf("Container without published port was accepted")
+	}
+	noPorts.NetworkSettings = &docker.NetworkSettings{Networks: map[string]docker.ContainerNetwork{"backend": {IPAddress: "172.18.0.6"}}}
+	if backend, _ := BackendFromContainer(noPorts, Addressing{Network: "backend"}); backend.Address != "172.18.0.6:3000" {
+		t.Errorf("Unexpected backend %#v", backend)
+	}
+}
+
+func TestWatcherNetworkAddressing(t *testing.T) {
+	client := newFakeClient()
+	client.addContainer("a", "app", "")
+	client.containers["a"].NetworkSettings = &docker.NetworkSettings{
+		Networks: map[string]docker.ContainerNetwork{"backend": {IPAddress: "172.18.0.5"}},
+		Ports:    map[docker.Port][]docker.PortBinding{"8080/tcp": nil},
+	}
+
+	registry := NewRegistry()
+	watcher := NewWatcher("test", Addressing{Host: "docker01"}, client, registry)
+	watcher.SetConfig(Config{Network: "backend"})
+	go watcher.Run()
+	defer watcher.Stop()
+
+	waitFor(t, "initial resync", hasTarget(registry, "app", []string{"172.18.0.5:8080"}))
+	if n := client.inspectCount(); n != 0 {
+		t.Errorf("Expected the listing to be sufficient, got %d inspections", n)
+	}
+
+	// Events don't carry the IP of the container
+	client.addContainer("b", "app", "")
+	client.Lock()
+	client.containers["b"].NetworkSettings = &docker.NetworkSettings{
+		Networks: map[string]docker.ContainerNetwork{"backend": {IPAddress: "172.18.0.6"}},
+		Ports:    map[docker.Port][]docker.PortBinding{"8080/tcp": nil},
+	}
+	client.Unlock()
+	client.emit("start", "b")
+	waitFor(t, "started container", hasTarget(registry, "app", []string{"172.18.0.5:8080", "172.18.0.6:8080"}))
+}
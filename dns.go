@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/dns01"
+	_ "github.com/Luzifer/dockerproxy/dns01/exec"
+	_ "github.com/Luzifer/dockerproxy/dns01/rfc2136"
+	_ "github.com/Luzifer/dockerproxy/dns01/webhook"
+)
+
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	propagationCheckInterval  = 5 * time.Second
+)
+
+// genericWildcard returns the wildcard name covering the generic suffix
+func genericWildcard() string {
+	return "*." + strings.Trim(proxyConfiguration().Generic, ".")
+}
+
+// dnsProviderFor returns the name of the DNS provider configured to
+// answer challenges for the domain
+func dnsProviderFor(domain string) string {
+	config := proxyConfiguration()
+	if config.GenericDNS != "" && domain == genericWildcard() {
+		return config.GenericDNS
+	}
+	if domainCFG, ok := config.Domains[domain]; ok {
+		return domainCFG.DNSProvider
+	}
+	return config.Domains[strings.TrimPrefix(domain, "*.")].DNSProvider
+}
+
+// dnsSolver answers dns-01 challenges for domains having a DNS provider
+// configured
+type dnsSolver struct {
+	client *letsEncryptClient
+}
+
+func (d dnsSolver) Type() string { return acme.ChallengeDNS01 }
+
+func (d dnsSolver) Accepts(domain string) bool { return dnsProviderFor(domain) != "" }
+
+func (d dnsSolver) provider(domain string) (dns01.Provider, dnsProvider, error) {
+	name := dnsProviderFor(domain)
+	cfg, ok := proxyConfiguration().DNSProviders[name]
+	if !ok {
+		return nil, cfg, fmt.Errorf("No DNS provider configured for %s", domain)
+	}
+	p, err := dns01.New(cfg.Type, cfg.Config)
+	return p, cfg, err
+}
+
+func (d dnsSolver) Present(domain, token, keyAuth string) error {
+	d.client.log("Authorizing domain using DNS: %s", domain)
+
+	p, cfg, err := d.provider(domain)
+	if err != nil {
+		return err
+	}
+
+	fqdn, value := dns01.ChallengeFQDN(domain), acme.DNS01Value(keyAuth)
+	if err := p.Present(fqdn, value); err != nil {
+		return err
+	}
+
+	d.client.challengesLock.Lock()
+	d.client.challenges[domain] = letsEncryptClientChallenge{
+		Type:     acme.ChallengeDNS01,
+		Path:     fqdn,
+		Response: value,
+	}
+	d.client.challengesLock.Unlock()
+
+	timeout := cfg.PropagationTimeout
+	if timeout == 0 {
+		timeout = defaultPropagationTimeout
+	}
+	return dns01.WaitForRecord(fqdn, value, timeout, propagationCheckInterval)
+}
+
+func (d dnsSolver) CleanUp(domain, token, keyAuth string) error {
+	d.client.challengesLock.Lock()
+	delete(d.client.challenges, domain)
+	d.client.challengesLock.Unlock()
+
+	p, _, err := d.provider(domain)
+	if err != nil {
+		return err
+	}
+	return p.CleanUp(dns01.ChallengeFQDN(domain), acme.DNS01Value(keyAuth))
+}
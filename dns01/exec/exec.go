@@ -0,0 +1,70 @@
+// Package exec provides a DNS provider running an external command to
+// create and remove challenge records
+package exec // import "github.com/Luzifer/dockerproxy/dns01/exec"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/dns01"
+)
+
+func init() {
+	dns01.RegisterProvider("exec", New)
+}
+
+type config struct {
+	Command string        `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Provider calls the command with `present <fqdn> <value>` and
+// `cleanup <fqdn> <value>`
+type Provider struct {
+	command string
+	timeout time.Duration
+}
+
+// New creates an exec provider from its configuration
+func New(cfg interface{}) (dns01.Provider, error) {
+	c := config{}
+	if err := dns01.RemapConfiguration(cfg, &c); err != nil {
+		return nil, err
+	}
+	if c.Command == "" {
+		return nil, fmt.Errorf("exec provider requires a command")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = time.Minute
+	}
+	return &Provider{command: c.Command, timeout: c.Timeout}, nil
+}
+
+// Present implements dns01.Provider
+func (p *Provider) Present(fqdn, value string) error {
+	return p.run("present", fqdn, value)
+}
+
+// CleanUp implements dns01.Provider
+func (p *Provider) CleanUp(fqdn, value string) error {
+	return p.run("cleanup", fqdn, value)
+}
+
+func (p *Provider) run(action, fqdn, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	out := new(bytes.Buffer)
+	cmd := exec.CommandContext(ctx, p.command, action, fqdn, value)
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("Command %s %s failed: %s (%s)", p.command, action, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Package dns01 contains the registry of DNS providers used to answer
+// ACME dns-01 challenges
+package dns01 // import "github.com/Luzifer/dockerproxy/dns01"
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Provider creates and removes the TXT records of dns-01 challenges
+type Provider interface {
+	// Present creates a TXT record with the value for the FQDN
+	Present(fqdn, value string) error
+	// CleanUp removes the TXT record created by Present
+	CleanUp(fqdn, value string) error
+}
+
+// Factory creates a Provider from its configuration
+type Factory func(config interface{}) (Provider, error)
+
+var providers = make(map[string]Factory)
+
+// RegisterProvider makes a provider type available by name
+func RegisterProvider(name string, fn Factory) {
+	if _, existing := providers[name]; existing {
+		panic(fmt.Sprintf("DNS provider with name '%s' already exists", name))
+	}
+	providers[name] = fn
+}
+
+// New creates a provider of the named type
+func New(name string, config interface{}) (Provider, error) {
+	fn, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("Unable to find DNS provider type '%s'", name)
+	}
+	return fn(config)
+}
+
+// RemapConfiguration converts the generic configuration into the
+// provider specific struct
+func RemapConfiguration(i interface{}, o interface{}) error {
+	tmp, err := yaml.Marshal(i)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(tmp, o)
+}
+
+// ChallengeFQDN returns the name of the TXT record for the domain,
+// wildcard prefixes are removed
+func ChallengeFQDN(domain string) string {
+	return "_acme-challenge." + strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".") + "."
+}
+
+// WaitForRecord polls the resolver until the TXT record contains the
+// value or the timeout is reached
+func WaitForRecord(fqdn, value string, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		records, _ := net.LookupTXT(fqdn)
+		for _, r := range records {
+			if r == value {
+				return nil
+			}
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("TXT record %s did not propagate within %s", fqdn, timeout)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,285 @@
+// Package rfc2136 provides a DNS provider using dynamic updates as
+// described in RFC 2136 optionally signed by TSIG (RFC 8945)
+package rfc2136 // import "github.com/Luzifer/dockerproxy/dns01/rfc2136"
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/dns01"
+)
+
+func init() {
+	dns01.RegisterProvider("rfc2136", New)
+}
+
+const (
+	typeSOA  = 6
+	typeTXT  = 16
+	typeTSIG = 250
+
+	classIN   = 1
+	classNONE = 254
+	classANY  = 255
+
+	opcodeUpdate = 5
+	tsigFudge    = 300
+)
+
+var rcodes = map[byte]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+}
+
+var tsigAlgorithms = map[string]func() hash.Hash{
+	"hmac-sha1.":   sha1.New,
+	"hmac-sha256.": sha256.New,
+	"hmac-sha512.": sha512.New,
+}
+
+type config struct {
+	Nameserver    string        `yaml:"nameserver"`
+	Zone          string        `yaml:"zone"`
+	TSIGKey       string        `yaml:"tsig_key"`
+	TSIGSecret    string        `yaml:"tsig_secret"`
+	TSIGAlgorithm string        `yaml:"tsig_algorithm"`
+	TTL           uint32        `yaml:"ttl"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// Provider sends dynamic updates to the primary nameserver of the zone
+type Provider struct {
+	nameserver string
+	zone       string
+	ttl        uint32
+	timeout    time.Duration
+
+	keyName   string
+	secret    []byte
+	algorithm string
+
+	now func() time.Time
+}
+
+// New creates a RFC 2136 provider from its configuration
+func New(cfg interface{}) (dns01.Provider, error) {
+	c := config{}
+	if err := dns01.RemapConfiguration(cfg, &c); err != nil {
+		return nil, err
+	}
+
+	if c.Nameserver == "" || c.Zone == "" {
+		return nil, fmt.Errorf("rfc2136 provider requires nameserver and zone")
+	}
+	if _, _, err := net.SplitHostPort(c.Nameserver); err != nil {
+		c.Nameserver = net.JoinHostPort(c.Nameserver, "53")
+	}
+	if c.TTL == 0 {
+		c.TTL = 60
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	p := &Provider{
+		nameserver: c.Nameserver,
+		zone:       fqdn(c.Zone),
+		ttl:        c.TTL,
+		timeout:    c.Timeout,
+		now:        time.Now,
+	}
+
+	if c.TSIGKey != "" {
+		secret, err := base64.StdEncoding.DecodeString(c.TSIGSecret)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid TSIG secret: %s", err)
+		}
+		if c.TSIGAlgorithm == "" {
+			c.TSIGAlgorithm = "hmac-sha256"
+		}
+		p.algorithm = fqdn(strings.ToLower(c.TSIGAlgorithm))
+		if _, ok := tsigAlgorithms[p.algorithm]; !ok {
+			return nil, fmt.Errorf("Unsupported TSIG algorithm %s", c.TSIGAlgorithm)
+		}
+		p.keyName = fqdn(strings.ToLower(c.TSIGKey))
+		p.secret = secret
+	}
+
+	return p, nil
+}
+
+// Present implements dns01.Provider
+func (p *Provider) Present(name, value string) error {
+	return p.update(name, value, classIN, p.ttl)
+}
+
+// CleanUp implements dns01.Provider
+func (p *Provider) CleanUp(name, value string) error {
+	// Class NONE deletes the RR matching name, type and data
+	return p.update(name, value, classNONE, 0)
+}
+
+func (p *Provider) update(name, value string, class uint16, ttl uint32) error {
+	if len(value) > 255 {
+		return fmt.Errorf("TXT value too long")
+	}
+
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], opcodeUpdate<<11)
+	binary.BigEndian.PutUint16(msg[4:], 1) // zone
+	binary.BigEndian.PutUint16(msg[8:], 1) // update
+
+	// Zone section
+	msg = appendName(msg, p.zone)
+	msg = appendUint16(msg, typeSOA)
+	msg = appendUint16(msg, classIN)
+
+	// Update section
+	rdata := append([]byte{byte(len(value))}, value...)
+	msg = appendName(msg, fqdn(name))
+	msg = appendUint16(msg, typeTXT)
+	msg = appendUint16(msg, class)
+	msg = appendUint32(msg, ttl)
+	msg = appendUint16(msg, uint16(len(rdata)))
+	msg = append(msg, rdata...)
+
+	if p.keyName != "" {
+		msg = p.sign(msg, id)
+	}
+
+	resp, err := p.exchange(msg)
+	if err != nil {
+		return err
+	}
+
+	if len(resp) < 12 || binary.BigEndian.Uint16(resp[0:]) != id {
+		return fmt.Errorf("Invalid response from %s", p.nameserver)
+	}
+	if rcode := resp[3] & 0x0f; rcode != 0 {
+		rname, ok := rcodes[rcode]
+		if !ok {
+			rname = fmt.Sprintf("RCODE%d", rcode)
+		}
+		return fmt.Errorf("Update of %s rejected by %s: %s", fqdn(name), p.nameserver, rname)
+	}
+	return nil
+}
+
+// sign appends a TSIG record to the message
+func (p *Provider) sign(msg []byte, id uint16) []byte {
+	signed := uint64(p.now().Unix())
+	timeSigned := []byte{
+		byte(signed >> 40), byte(signed >> 32), byte(signed >> 24),
+		byte(signed >> 16), byte(signed >> 8), byte(signed),
+	}
+
+	// TSIG variables as defined in RFC 8945 section 4.3.3
+	vars := appendName(nil, p.keyName)
+	vars = appendUint16(vars, classANY)
+	vars = appendUint32(vars, 0)
+	vars = appendName(vars, p.algorithm)
+	vars = append(vars, timeSigned...)
+	vars = appendUint16(vars, tsigFudge)
+	vars = appendUint16(vars, 0) // error
+	vars = appendUint16(vars, 0) // other len
+
+	mac := hmac.New(tsigAlgorithms[p.algorithm], p.secret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	rdata := appendName(nil, p.algorithm)
+	rdata = append(rdata, timeSigned...)
+	rdata = appendUint16(rdata, tsigFudge)
+	rdata = appendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = appendUint16(rdata, id)
+	rdata = appendUint16(rdata, 0) // error
+	rdata = appendUint16(rdata, 0) // other len
+
+	msg = appendName(msg, p.keyName)
+	msg = appendUint16(msg, typeTSIG)
+	msg = appendUint16(msg, classANY)
+	msg = appendUint32(msg, 0)
+	msg = appendUint16(msg, uint16(len(rdata)))
+	msg = append(msg, rdata...)
+
+	binary.BigEndian.PutUint16(msg[10:], binary.BigEndian.Uint16(msg[10:])+1)
+	return msg
+}
+
+// exchange sends the message using TCP to avoid truncation
+func (p *Provider) exchange(msg []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", p.nameserver, p.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.timeout))
+
+	if _, err := conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return nil, err
+	}
+
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
@@ -0,0 +1,160 @@
+package rfc2136
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+type update struct {
+	zone  string
+	name  string
+	class uint16
+	value string
+	mac   bool
+}
+
+func readName(msg []byte, off int) (string, int) {
+	labels := []string{}
+	for msg[off] != 0 {
+		l := int(msg[off])
+		labels = append(labels, string(msg[off+1:off+1+l]))
+		off += l + 1
+	}
+	return strings.Join(labels, ".") + ".", off + 1
+}
+
+// fakeNameserver accepts a single update and answers with rcode
+func fakeNameserver(t *testing.T, secret []byte, rcode byte, updates chan<- update) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %s", err)
+	}
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var lb [2]byte
+		io.ReadFull(conn, lb[:])
+		msg := make([]byte, binary.BigEndian.Uint16(lb[:]))
+		io.ReadFull(conn, msg)
+
+		u := update{}
+		if msg[2]>>3 != opcodeUpdate {
+			t.Errorf("Unexpected opcode %d", msg[2]>>3)
+		}
+
+		off := 12
+		u.zone, off = readName(msg, off)
+		off += 4
+		u.name, off = readName(msg, off)
+		u.class = binary.BigEndian.Uint16(msg[off+2:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		u.value = string(msg[off+11 : off+10+rdlen])
+		off += 10 + rdlen
+
+		if binary.BigEndian.Uint16(msg[10:]) == 1 {
+			// Verify TSIG by recalculating the MAC over the unsigned message
+			tsigStart := off
+			keyName, off := readName(msg, off)
+			rdata := msg[off+10:]
+			alg, roff := readName(rdata, 0)
+			timeFudge := rdata[roff : roff+8]
+			macLen := int(binary.BigEndian.Uint16(rdata[roff+8:]))
+			mac := rdata[roff+10 : roff+10+macLen]
+
+			unsigned := append([]byte{}, msg[:tsigStart]...)
+			binary.BigEndian.PutUint16(unsigned[10:], 0)
+
+			h := hmac.New(sha256.New, secret)
+			h.Write(unsigned)
+			h.Write(msg[tsigStart : tsigStart+len(keyName)+1])
+			h.Write([]byte{0, 255, 0, 0, 0, 0})
+			h.Write(rdata[:len(alg)+1])
+			h.Write(timeFudge)
+			h.Write([]byte{0, 0, 0, 0})
+			u.mac = hmac.Equal(h.Sum(nil), mac) && alg == "hmac-sha256."
+		}
+		updates <- u
+
+		resp := append([]byte{}, msg[:12]...)
+		resp[2] |= 0x80
+		resp[3] = rcode
+		conn.Write(append([]byte{0, byte(len(resp))}, resp...))
+	}()
+
+	return l.Addr().String()
+}
+
+func TestPresentSigned(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	updates := make(chan update, 1)
+	addr := fakeNameserver(t, secret, 0, updates)
+
+	p, err := New(map[string]interface{}{
+		"nameserver":  addr,
+		"zone":        "example.com",
+		"tsig_key":    "acme-key",
+		"tsig_secret": base64.StdEncoding.EncodeToString(secret),
+	})
+	if err != nil {
+		t.Fatalf("Unable to create provider: %s", err)
+	}
+
+	if err := p.Present("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatalf("Present failed: %s", err)
+	}
+
+	u := <-updates
+	if u.zone != "example.com." || u.name != "_acme-challenge.example.com." || u.class != classIN || u.value != "value" {
+		t.Errorf("Unexpected update: %#v", u)
+	}
+	if !u.mac {
+		t.Errorf("TSIG signature invalid")
+	}
+}
+
+func TestCleanUpRefused(t *testing.T) {
+	updates := make(chan update, 1)
+	addr := fakeNameserver(t, nil, 5, updates)
+
+	p, err := New(map[string]interface{}{"nameserver": addr, "zone": "example.com."})
+	if err != nil {
+		t.Fatalf("Unable to create provider: %s", err)
+	}
+
+	err = p.CleanUp("_acme-challenge.example.com.", "value")
+	if err == nil || !strings.Contains(err.Error(), "REFUSED") {
+		t.Errorf("Expected REFUSED error, got %v", err)
+	}
+
+	if u := <-updates; u.class != classNONE || u.mac {
+		t.Errorf("Unexpected delete: %#v", u)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New(map[string]interface{}{"zone": "example.com"}); err == nil {
+		t.Errorf("Missing nameserver was accepted")
+	}
+	_, err := New(map[string]interface{}{
+		"nameserver":     "127.0.0.1",
+		"zone":           "example.com",
+		"tsig_key":       "key",
+		"tsig_secret":    "c2VjcmV0",
+		"tsig_algorithm": "hmac-md5",
+	})
+	if err == nil || !strings.Contains(err.Error(), "hmac-md5") {
+		t.Errorf("Unsupported algorithm was accepted: %v", err)
+	}
+}
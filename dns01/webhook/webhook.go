@@ -0,0 +1,97 @@
+// Package webhook provides a DNS provider posting challenge records to
+// an HTTP endpoint
+package webhook // import "github.com/Luzifer/dockerproxy/dns01/webhook"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/dns01"
+)
+
+func init() {
+	dns01.RegisterProvider("webhook", New)
+}
+
+type config struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+}
+
+// Request is the JSON body sent to the webhook
+type Request struct {
+	Action string `json:"action"`
+	FQDN   string `json:"fqdn"`
+	Value  string `json:"value"`
+}
+
+// Provider sends a POST request for every record change and expects
+// a 2xx status code
+type Provider struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// New creates a webhook provider from its configuration
+func New(cfg interface{}) (dns01.Provider, error) {
+	c := config{}
+	if err := dns01.RemapConfiguration(cfg, &c); err != nil {
+		return nil, err
+	}
+	if c.URL == "" {
+		return nil, fmt.Errorf("webhook provider requires an url")
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Second
+	}
+	return &Provider{
+		url:     c.URL,
+		headers: c.Headers,
+		client:  &http.Client{Timeout: c.Timeout},
+	}, nil
+}
+
+// Present implements dns01.Provider
+func (p *Provider) Present(fqdn, value string) error {
+	return p.send(Request{Action: "present", FQDN: fqdn, Value: value})
+}
+
+// CleanUp implements dns01.Provider
+func (p *Provider) CleanUp(fqdn, value string) error {
+	return p.send(Request{Action: "cleanup", FQDN: fqdn, Value: value})
+}
+
+func (p *Provider) send(r Request) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook returned status %d for %s", resp.StatusCode, r.Action)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var got []Request
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		req := Request{}
+		json.NewDecoder(r.Body).Decode(&req)
+		got = append(got, req)
+	}))
+	defer srv.Close()
+
+	p, err := New(map[string]interface{}{
+		"url":     srv.URL,
+		"headers": map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to create provider: %s", err)
+	}
+
+	if err := p.Present("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatalf("Present failed: %s", err)
+	}
+	if err := p.CleanUp("_acme-challenge.example.com.", "value"); err != nil {
+		t.Fatalf("CleanUp failed: %s", err)
+	}
+
+	if len(got) != 2 || got[0].Action != "present" || got[1].Action != "cleanup" || got[0].FQDN != "_acme-challenge.example.com." {
+		t.Errorf("Unexpected requests: %#v", got)
+	}
+
+	p, _ = New(map[string]interface{}{"url": srv.URL})
+	if err := p.Present("_acme-challenge.example.com.", "value"); err == nil {
+		t.Errorf("Failed webhook call did not return an error")
+	}
+}
@@ -1,45 +1,49 @@
 package main
 
 import (
-	"fmt"
-	"strings"
+	"time"
 
-	"github.com/fsouza/go-dockerclient"
+	"github.com/Luzifer/dockerproxy/discovery"
 )
 
 type dockerContainers map[string][]string
 
 func collectDockerContainer() *dockerContainers {
-	result := make(dockerContainers)
-
-	for dockerHostPrivate, dockerHost := range proxyConfiguration.Docker.Hosts {
-		// Connect every docker host and get its containers
-		endpoint := fmt.Sprintf("tcp://%s:%d", dockerHostPrivate, proxyConfiguration.Docker.Port)
-		client, _ := docker.NewClient(endpoint)
-		containers, _ := client.ListContainers(docker.ListContainersOptions{})
-
-		for _, apiContainer := range containers {
-			container, _ := client.InspectContainer(apiContainer.ID)
-
-			// Load slug and port from container labels
-			if routerSlug, ok := container.Config.Labels["io.luzifer.dockerproxy.slug"]; ok {
-				routerPort := container.Config.Labels["io.luzifer.dockerproxy.port"]
-				result[routerSlug] = append(result[routerSlug], fmt.Sprintf("%s:%s", dockerHost, routerPort))
-				continue // If new configuration is present don't parse env configuration
-			}
+	result := dockerContainers(containers.Containers())
+	return &result
+}
 
-			// Load ROUTER_SLUG and ROUTER_PORT from environment configuration of that container
-			currentEnv := make(map[string]string)
-			for _, envVar := range container.Config.Env {
-				t := strings.Split(envVar, "=")
-				currentEnv[t[0]] = t[1]
-			}
-			if slug, ok := currentEnv["ROUTER_SLUG"]; ok {
-				port := currentEnv["ROUTER_PORT"]
-				result[slug] = append(result[slug], fmt.Sprintf("%s:%s", dockerHost, port))
+func (c dockerConfig) discoveryConfig() discovery.Config {
+	return discovery.Config{
+		StaleAfter: c.StaleAfter,
+		Timeout:    c.Timeout,
+		LabelsOnly: c.LabelsOnly,
+		Network:    c.Network,
+	}
+}
+
+func discoverySucceeded(endpoint string) {
+	discoveryLastSuccess.WithLabelValues(endpoint).Set(float64(time.Now().Unix()))
+}
+
+func discoveryFailed(endpoint string, err error) {
+	discoveryErrors.WithLabelValues(endpoint).Inc()
+}
+
+// watchDiscoveredBackends exports the number of containers per slug
+func watchDiscoveredBackends(changes <-chan struct{}) {
+	known := make(map[string]bool)
+	for range changes {
+		current := make(map[string]bool)
+		for slug, addresses := range containers.Containers() {
+			discoveredBackends.WithLabelValues(slug).Set(float64(len(addresses)))
+			current[slug] = true
+		}
+		for slug := range known {
+			if !current[slug] {
+				discoveredBackends.DeleteLabelValues(slug)
 			}
 		}
+		known = current
 	}
-
-	return &result
 }
@@ -0,0 +1,228 @@
+package health
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target is a backend of a slug which should be checked
+type Target struct {
+	Slug    string
+	Address string
+	Config  Config
+}
+
+func (t Target) key() string {
+	return t.Slug + "|" + t.Address
+}
+
+// Status represents the current state of a checked backend
+type Status struct {
+	Slug      string    `json:"slug"`
+	Address   string    `json:"address"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// StateFunc is called whenever a backend changed its health state
+type StateFunc func(slug, address string, healthy bool)
+
+// Checker actively probes backends and keeps track of their health
+type Checker struct {
+	sync.RWMutex
+	checks   map[string]*check
+	onChange StateFunc
+	client   *http.Client
+}
+
+type check struct {
+	target Target
+	stop   chan struct{}
+
+	healthy   bool
+	successes int
+	failures  int
+	lastCheck time.Time
+	lastError string
+}
+
+// NewChecker creates a Checker calling onChange on every health state
+// change. onChange may be nil.
+func NewChecker(onChange StateFunc) *Checker {
+	if onChange == nil {
+		onChange = func(string, string, bool) {}
+	}
+
+	return &Checker{
+		checks:   make(map[string]*check),
+		onChange: onChange,
+		client: &http.Client{
+			// Redirects are a valid answer of a healthy backend
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Sync starts checks for new targets, restarts checks whose config
+// changed and stops checks for targets no longer present
+func (c *Checker) Sync(targets []Target) {
+	c.Lock()
+	defer c.Unlock()
+
+	wanted := make(map[string]Target)
+	for _, t := range targets {
+		wanted[t.key()] = t
+	}
+
+	for key, chk := range c.checks {
+		if t, ok := wanted[key]; !ok || t.Config != chk.target.Config {
+			close(chk.stop)
+			delete(c.checks, key)
+		}
+	}
+
+	for key, t := range wanted {
+		if _, ok := c.checks[key]; ok {
+			continue
+		}
+
+		// Backends are considered healthy until proven otherwise
+		chk := &check{
+			target:  t,
+			stop:    make(chan struct{}),
+			healthy: true,
+		}
+		c.checks[key] = chk
+		c.onChange(t.Slug, t.Address, true)
+		go c.run(chk)
+	}
+}
+
+// Healthy reports whether the backend of the slug may receive traffic.
+// Backends without a health check are always healthy.
+func (c *Checker) Healthy(slug, address string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if chk, ok := c.checks[Target{Slug: slug, Address: address}.key()]; ok {
+		return chk.healthy
+	}
+	return true
+}
+
+// Filter removes all unhealthy backends from the addresses of the slug
+func (c *Checker) Filter(slug string, addresses []string) []string {
+	result := []string{}
+	for _, addr := range addresses {
+		if c.Healthy(slug, addr) {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
+
+// Status returns the state of all checked backends
+func (c *Checker) Status() []Status {
+	c.RLock()
+	defer c.RUnlock()
+
+	result := []Status{}
+	for _, chk := range c.checks {
+		result = append(result, Status{
+			Slug:      chk.target.Slug,
+			Address:   chk.target.Address,
+			Healthy:   chk.healthy,
+			LastCheck: chk.lastCheck,
+			LastError: chk.lastError,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Slug != result[j].Slug {
+			return result[i].Slug < result[j].Slug
+		}
+		return result[i].Address < result[j].Address
+	})
+	return result
+}
+
+func (c *Checker) run(chk *check) {
+	ticker := time.NewTicker(chk.target.Config.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.record(chk, c.probe(chk.target))
+
+		select {
+		case <-chk.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Checker) probe(t Target) error {
+	req, err := http.NewRequest("GET", "http://"+t.Address+t.Config.Path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "dockerproxy-healthcheck")
+
+	client := *c.client
+	client.Timeout = t.Config.Timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 400 {
+		return statusError(resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *Checker) record(chk *check, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	select {
+	case <-chk.stop:
+		// Check was removed while probing
+		return
+	default:
+	}
+
+	chk.lastCheck = time.Now()
+	if err != nil {
+		chk.lastError = err.Error()
+		chk.successes = 0
+		chk.failures++
+		if chk.healthy && chk.failures >= chk.target.Config.UnhealthyThreshold {
+			chk.healthy = false
+			c.onChange(chk.target.Slug, chk.target.Address, false)
+		}
+		return
+	}
+
+	chk.lastError = ""
+	chk.failures = 0
+	chk.successes++
+	if !chk.healthy && chk.successes >= chk.target.Config.HealthyThreshold {
+		chk.healthy = true
+		c.onChange(chk.target.Slug, chk.target.Address, true)
+	}
+}
+
+type statusError int
+
+func (s statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", int(s))
+}
@@ -0,0 +1,94 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckerEjectsAndRecovers(t *testing.T) {
+	var failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(res, r)
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(res, "down", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	address := strings.TrimPrefix(srv.URL, "http://")
+
+	changes := make(chan bool, 10)
+	checker := NewChecker(func(slug, addr string, healthy bool) {
+		if slug == "app" && addr == address {
+			changes <- healthy
+		}
+	})
+	cfg := Config{
+		Path:               "/health",
+		Interval:           10 * time.Millisecond,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 2,
+	}.WithDefaults()
+	checker.Sync([]Target{{Slug: "app", Address: address, Config: cfg}})
+	defer checker.Sync(nil)
+
+	if !<-changes {
+		t.Fatalf("New backend was not considered healthy")
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	select {
+	case healthy := <-changes:
+		if healthy {
+			t.Fatalf("Expected backend to become unhealthy")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Backend was not ejected")
+	}
+
+	if f := checker.Filter("app", []string{address, "other:80"}); len(f) != 1 || f[0] != "other:80" {
+		t.Errorf("Unhealthy backend was not filtered: %v", f)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	select {
+	case healthy := <-changes:
+		if !healthy {
+			t.Fatalf("Expected backend to recover")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Backend was not re-added")
+	}
+
+	if !checker.Healthy("app", address) {
+		t.Errorf("Recovered backend is not healthy")
+	}
+}
+
+func TestConfigFromLabels(t *testing.T) {
+	if cfg, err := ConfigFromLabels(nil, map[string]string{"foo": "bar"}); err != nil || cfg != nil {
+		t.Errorf("Health check enabled without configuration: %v, %v", cfg, err)
+	}
+
+	base := &Config{Path: "/status", Interval: time.Minute}
+	cfg, err := ConfigFromLabels(base, map[string]string{
+		LabelPrefix + "interval":          "5s",
+		LabelPrefix + "healthy_threshold": "4",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cfg.Path != "/status" || cfg.Interval != 5*time.Second || cfg.HealthyThreshold != 4 || cfg.UnhealthyThreshold != 3 {
+		t.Errorf("Unexpected config: %#v", cfg)
+	}
+
+	if _, err := ConfigFromLabels(nil, map[string]string{LabelPrefix + "timeout": "soon"}); err == nil {
+		t.Errorf("Invalid duration was accepted")
+	}
+}
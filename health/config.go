@@ -0,0 +1,83 @@
+package health
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LabelPrefix is the prefix of container labels configuring the health check
+const LabelPrefix = "io.luzifer.dockerproxy.healthcheck."
+
+// Config describes how to check a backend
+type Config struct {
+	Path               string        `json:"path" yaml:"path"`
+	Interval           time.Duration `json:"interval" yaml:"interval"`
+	Timeout            time.Duration `json:"timeout" yaml:"timeout"`
+	HealthyThreshold   int           `json:"healthy_threshold" yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `json:"unhealthy_threshold" yaml:"unhealthy_threshold"`
+}
+
+// WithDefaults fills all unset values with their defaults
+func (c Config) WithDefaults() Config {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.Interval <= 0 {
+		c.Interval = 10 * time.Second
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = 2
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = 3
+	}
+	return c
+}
+
+// ConfigFromLabels merges the health check labels of a container into
+// the base configuration. It returns nil if neither base nor labels
+// enable a health check.
+func ConfigFromLabels(base *Config, labels map[string]string) (*Config, error) {
+	var result Config
+	enabled := base != nil
+	if base != nil {
+		result = *base
+	}
+
+	for key, value := range labels {
+		if !strings.HasPrefix(key, LabelPrefix) {
+			continue
+		}
+		enabled = true
+
+		var err error
+		switch strings.TrimPrefix(key, LabelPrefix) {
+		case "path":
+			result.Path = value
+		case "interval":
+			result.Interval, err = time.ParseDuration(value)
+		case "timeout":
+			result.Timeout, err = time.ParseDuration(value)
+		case "healthy_threshold":
+			result.HealthyThreshold, err = strconv.Atoi(value)
+		case "unhealthy_threshold":
+			result.UnhealthyThreshold, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("Unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid label %s=%q: %s", key, value, err)
+		}
+	}
+
+	if !enabled {
+		return nil, nil
+	}
+	result = result.WithDefaults()
+	return &result, nil
+}
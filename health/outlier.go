@@ -0,0 +1,102 @@
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+// OutlierConfig describes when to eject backends based on failed requests
+type OutlierConfig struct {
+	// ConsecutiveFailures is the number of connection failures in a row
+	// after which the backend is ejected
+	ConsecutiveFailures int `json:"consecutive_failures" yaml:"consecutive_failures"`
+	// EjectionTime is the duration the backend is kept out of rotation
+	EjectionTime time.Duration `json:"ejection_time" yaml:"ejection_time"`
+}
+
+// WithDefaults fills all unset values with their defaults
+func (o OutlierConfig) WithDefaults() OutlierConfig {
+	if o.ConsecutiveFailures <= 0 {
+		o.ConsecutiveFailures = 5
+	}
+	if o.EjectionTime <= 0 {
+		o.EjectionTime = 30 * time.Second
+	}
+	return o
+}
+
+// OutlierDetector passively tracks the results of proxied requests and
+// temporarily ejects backends failing consecutively
+type OutlierDetector struct {
+	sync.Mutex
+	backends map[string]*outlierState
+	now      func() time.Time
+}
+
+type outlierState struct {
+	failures     int
+	ejectedUntil time.Time
+}
+
+// NewOutlierDetector creates an empty OutlierDetector
+func NewOutlierDetector() *OutlierDetector {
+	return &OutlierDetector{
+		backends: make(map[string]*outlierState),
+		now:      time.Now,
+	}
+}
+
+// ReportSuccess resets the failure counter of the backend. An active
+// ejection is kept as the request may have been started before it.
+func (o *OutlierDetector) ReportSuccess(slug, address string) {
+	o.Lock()
+	defer o.Unlock()
+
+	key := Target{Slug: slug, Address: address}.key()
+	state, ok := o.backends[key]
+	if !ok {
+		return
+	}
+	if o.now().Before(state.ejectedUntil) {
+		state.failures = 0
+		return
+	}
+	delete(o.backends, key)
+}
+
+// ReportFailure counts a failed connection to the backend and returns
+// true if this failure caused the backend to be ejected
+func (o *OutlierDetector) ReportFailure(slug, address string, cfg OutlierConfig) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	key := Target{Slug: slug, Address: address}.key()
+	state, ok := o.backends[key]
+	if !ok {
+		state = &outlierState{}
+		o.backends[key] = state
+	}
+
+	if o.now().Before(state.ejectedUntil) {
+		// Already ejected, request was started before
+		return false
+	}
+
+	state.failures++
+	if state.failures < cfg.ConsecutiveFailures {
+		return false
+	}
+
+	state.failures = 0
+	state.ejectedUntil = o.now().Add(cfg.EjectionTime)
+	return true
+}
+
+// Ejected reports whether the backend is currently out of rotation
+func (o *OutlierDetector) Ejected(slug, address string) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	state, ok := o.backends[Target{Slug: slug, Address: address}.key()]
+	return ok && o.now().Before(state.ejectedUntil)
+}
@@ -0,0 +1,57 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlierDetector(t *testing.T) {
+	now := time.Now()
+	o := NewOutlierDetector()
+	o.now = func() time.Time { return now }
+	cfg := OutlierConfig{ConsecutiveFailures: 3, EjectionTime: time.Minute}
+
+	o.ReportFailure("app", "a:80", cfg)
+	o.ReportFailure("app", "a:80", cfg)
+	o.ReportSuccess("app", "a:80")
+	o.ReportFailure("app", "a:80", cfg)
+	o.ReportFailure("app", "a:80", cfg)
+	if o.Ejected("app", "a:80") {
+		t.Fatalf("Backend was ejected without consecutive failures")
+	}
+
+	if !o.ReportFailure("app", "a:80", cfg) {
+		t.Fatalf("Third consecutive failure did not eject backend")
+	}
+	if !o.Ejected("app", "a:80") || o.Ejected("app", "b:80") {
+		t.Errorf("Ejection state is wrong")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if o.Ejected("app", "a:80") {
+		t.Errorf("Backend is still ejected after ejection time")
+	}
+}
+
+func TestOutlierSuccessKeepsEjection(t *testing.T) {
+	now := time.Now()
+	o := NewOutlierDetector()
+	o.now = func() time.Time { return now }
+	cfg := OutlierConfig{ConsecutiveFailures: 1, EjectionTime: time.Minute}
+
+	if !o.ReportFailure("app", "a:80", cfg) {
+		t.Fatalf("Failure did not eject backend")
+	}
+
+	// A slow request started before the ejection finishes successfully
+	o.ReportSuccess("app", "a:80")
+	if !o.Ejected("app", "a:80") {
+		t.Errorf("Success of an earlier request lifted the ejection")
+	}
+
+	now = now.Add(2 * time.Minute)
+	o.ReportSuccess("app", "a:80")
+	if o.Ejected("app", "a:80") {
+		t.Errorf("Backend is still ejected after ejection time")
+	}
+}
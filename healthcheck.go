@@ -0,0 +1,92 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/Luzifer/dockerproxy/discovery"
+	"github.com/Luzifer/dockerproxy/health"
+)
+
+// healthTargets collects all backends having a health check configured
+// either in the configuration file or through container labels
+func healthTargets() []health.Target {
+	targets := []health.Target{}
+	for _, backend := range containers.Backends() {
+		var base *health.Config
+		if c, ok := proxyConfiguration().HealthChecks[backend.Slug]; ok {
+			base = &c
+		}
+
+		cfg, err := health.ConfigFromLabels(base, backend.Labels)
+		if err != nil {
+			log.Printf("[HealthCheck] Ignoring health check for %s (%s): %s", backend.Address, backend.Slug, err)
+			continue
+		}
+		if cfg == nil {
+			continue
+		}
+
+		targets = append(targets, health.Target{
+			Slug:    backend.Slug,
+			Address: backend.Address,
+			Config:  *cfg,
+		})
+	}
+	return targets
+}
+
+var healthSyncLock sync.Mutex
+
+// healthyBackends returns all backends of the slug not ejected by their
+// health check, the outlier detection or drained through the admin API
+func healthyBackends(slug string) []discovery.Backend {
+	healthy := []discovery.Backend{}
+	for _, backend := range containers.Lookup(slug) {
+		if healthChecker.Healthy(slug, backend.Address) && !isDrained(slug, backend.Address) {
+			healthy = append(healthy, backend)
+		}
+	}
+
+	result := []discovery.Backend{}
+	for _, backend := range healthy {
+		if !outliers.Ejected(slug, backend.Address) {
+			result = append(result, backend)
+		}
+	}
+
+	if len(result) == 0 {
+		// All backends were ejected by the outlier detection, rather try
+		// them than reject every request
+		return healthy
+	}
+	return result
+}
+
+func syncHealthChecks() {
+	healthSyncLock.Lock()
+	defer healthSyncLock.Unlock()
+
+	healthChecker.Sync(healthTargets())
+
+	backendHealthy.Reset()
+	for _, s := range healthChecker.Status() {
+		setBackendHealth(s.Slug, s.Address, s.Healthy)
+	}
+}
+
+func setBackendHealth(slug, address string, healthy bool) {
+	value := 0.0
+	if healthy {
+		value = 1
+	}
+	backendHealthy.WithLabelValues(slug, address).Set(value)
+}
+
+// watchHealthTargets keeps the health checks in sync with the
+// discovered containers
+func watchHealthTargets(changes <-chan struct{}) {
+	for range changes {
+		syncHealthChecks()
+	}
+}
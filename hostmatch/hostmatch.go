@@ -0,0 +1,186 @@
+package hostmatch
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+)
+
+// Kinds of host keys in the order of their precedence
+const (
+	KindExact = iota
+	KindWildcard
+	KindRegex
+)
+
+// regexChars mark a key as regular expression, none of them is valid in
+// host names
+const regexChars = `\^$()[]{}|+?*`
+
+// Kind returns how the key is matched against hosts: "*.example.com"
+// matches a single label below example.com, keys containing regular
+// expression characters are matched as anchored, case-insensitive
+// expression and all other keys need to be equal to the host.
+func Kind(key string) int {
+	if strings.HasPrefix(key, "*.") && !strings.ContainsAny(key[2:], regexChars) {
+		return KindWildcard
+	}
+	if strings.ContainsAny(key, regexChars) {
+		return KindRegex
+	}
+	return KindExact
+}
+
+type pattern struct {
+	key string
+	re  *regexp.Regexp
+}
+
+// Matcher finds the key matching a host. Exact keys are preferred over
+// wildcards, wildcards over regular expressions. Regular expressions
+// are tried with the longest expression first, equally long ones in
+// alphabetical order.
+type Matcher struct {
+	exact     map[string]string
+	wildcards map[string]pattern
+	regexes   []pattern
+	// bySuffix indexes the regexes by the literal suffix every matching
+	// host ends with
+	bySuffix map[string][]int
+}
+
+// Match is the result of a host lookup
+type Match struct {
+	// Key is the key matching the host
+	Key  string
+	Host string
+
+	re         *regexp.Regexp
+	submatches []int
+}
+
+// Expand replaces references like $1 or ${name} in the template by the
+// capture groups of the host, a wildcard is available as $1. Templates
+// of exact matches are returned unchanged.
+func (m Match) Expand(template string) string {
+	if m.re == nil {
+		return template
+	}
+	return string(m.re.ExpandString(nil, template, m.Host, m.submatches))
+}
+
+// New builds a Matcher for the keys
+func New(keys []string) (*Matcher, error) {
+	m := &Matcher{
+		exact:     make(map[string]string),
+		wildcards: make(map[string]pattern),
+		bySuffix:  make(map[string][]int),
+	}
+
+	for _, key := range keys {
+		normalized := strings.ToLower(strings.TrimSuffix(key, "."))
+		switch Kind(key) {
+		case KindExact:
+			m.exact[normalized] = key
+
+		case KindWildcard:
+			parent := normalized[2:]
+			m.wildcards[parent] = pattern{
+				key: key,
+				re:  regexp.MustCompile(`^([^.]+)\.` + regexp.QuoteMeta(parent) + `$`),
+			}
+
+		case KindRegex:
+			re, err := regexp.Compile(`(?i)^(?:` + key + `)$`)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid host expression %q: %s", key, err)
+			}
+			m.regexes = append(m.regexes, pattern{key: key, re: re})
+		}
+	}
+
+	sort.Slice(m.regexes, func(i, j int) bool {
+		if len(m.regexes[i].key) != len(m.regexes[j].key) {
+			return len(m.regexes[i].key) > len(m.regexes[j].key)
+		}
+		return m.regexes[i].key < m.regexes[j].key
+	})
+	for i, p := range m.regexes {
+		suffix := literalSuffix(p.key)
+		m.bySuffix[suffix] = append(m.bySuffix[suffix], i)
+	}
+
+	return m, nil
+}
+
+// Match returns the key matching the host, a port in the host is ignored
+func (m *Matcher) Match(host string) (Match, bool) {
+	if m == nil {
+		return Match{}, false
+	}
+	host = strings.ToLower(strings.TrimSuffix(stripPort(host), "."))
+
+	if key, ok := m.exact[host]; ok {
+		return Match{Key: key, Host: host}, true
+	}
+
+	if idx := strings.Index(host, "."); idx > 0 {
+		if p, ok := m.wildcards[host[idx+1:]]; ok {
+			return Match{Key: p.key, Host: host, re: p.re, submatches: p.re.FindStringSubmatchIndex(host)}, true
+		}
+	}
+
+	// Only expressions whose literal suffix the host ends with can match
+	var candidates []int
+	for i := 0; i <= len(host); i++ {
+		candidates = append(candidates, m.bySuffix[host[i:]]...)
+	}
+	sort.Ints(candidates)
+	for _, i := range candidates {
+		p := m.regexes[i]
+		if sm := p.re.FindStringSubmatchIndex(host); sm != nil {
+			return Match{Key: p.key, Host: host, re: p.re, submatches: sm}, true
+		}
+	}
+
+	return Match{}, false
+}
+
+// literalSuffix returns the lower-cased text all hosts matching the
+// expression end with
+func literalSuffix(expr string) string {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+
+	var parts []*syntax.Regexp
+	switch re.Op {
+	case syntax.OpConcat:
+		parts = re.Sub
+	case syntax.OpLiteral:
+		parts = []*syntax.Regexp{re}
+	}
+
+	suffix := ""
+	for i := len(parts) - 1; i >= 0; i-- {
+		if parts[i].Op == syntax.OpEndText {
+			continue
+		}
+		if parts[i].Op != syntax.OpLiteral {
+			break
+		}
+		suffix = string(parts[i].Rune) + suffix
+	}
+	return strings.ToLower(suffix)
+}
+
+func stripPort(host string) string {
+	if idx := strings.LastIndex(host, ":"); idx > strings.LastIndex(host, "]") {
+		return host[:idx]
+	}
+	return host
+}
@@ -0,0 +1,82 @@
+package hostmatch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	m, err := New([]string{
+		"api.example.com",
+		"*.preview.example.com",
+		"live.preview.example.com",
+		`pr-(\d+).example.com`,
+		`(?P<app>[a-z]+)-pr-(\d+)\.example\.com`,
+		`.+\.example\.com`,
+	})
+	if err != nil {
+		t.Fatalf("Unable to build matcher: %s", err)
+	}
+
+	for host, expected := range map[string]struct{ key, slug string }{
+		"api.example.com":          {"api.example.com", "app-$1"},
+		"API.example.com.:443":     {"api.example.com", "app-$1"},
+		"live.preview.example.com": {"live.preview.example.com", "app-$1"},
+		"feat.preview.example.com": {"*.preview.example.com", "app-feat"},
+		"a.b.preview.example.com":  {`.+\.example\.com`, "app-"},
+		"pr-42.example.com":        {`pr-(\d+).example.com`, "app-42"},
+		"shop-pr-7.example.com":    {`(?P<app>[a-z]+)-pr-(\d+)\.example\.com`, "app-shop"},
+		"other.example.com":        {`.+\.example\.com`, "app-"},
+		"example.org":              {"", ""},
+		"preview.example.com.evil": {"", ""},
+	} {
+		match, ok := m.Match(host)
+		if expected.key == "" {
+			if ok {
+				t.Errorf("%s: unexpected match %s", host, match.Key)
+			}
+			continue
+		}
+		if !ok || match.Key != expected.key {
+			t.Errorf("%s: expected %s, got %s (%v)", host, expected.key, match.Key, ok)
+			continue
+		}
+		template := "app-$1"
+		if match.Key == `(?P<app>[a-z]+)-pr-(\d+)\.example\.com` {
+			template = "app-${app}"
+		}
+		if slug := match.Expand(template); slug != expected.slug {
+			t.Errorf("%s: expected slug %s, got %s", host, expected.slug, slug)
+		}
+	}
+
+	if _, err := New([]string{"pr-(\\d+.example.com"}); err == nil {
+		t.Errorf("Invalid expression was accepted")
+	}
+}
+
+func TestLiteralSuffix(t *testing.T) {
+	for expr, expected := range map[string]string{
+		`pr-(\d+)\.Example\.com`: ".example.com",
+		`pr-(\d+).example.com`:   "com",
+		`(a|b)\.example\.com$`:   ".example.com",
+		`a\.example\.com|b\.org`: "",
+	} {
+		if s := literalSuffix(expr); s != expected {
+			t.Errorf("%s: expected suffix %q, got %q", expr, expected, s)
+		}
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	keys := []string{}
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, fmt.Sprintf("host%d.example.com", i), fmt.Sprintf(`app%d-pr-(\d+)\.example%d\.com`, i, i))
+	}
+	m, _ := New(keys)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Match("app4999-pr-12.example4999.com")
+	}
+}
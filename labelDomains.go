@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Luzifer/dockerproxy/auth"
+	"github.com/Luzifer/dockerproxy/discovery"
+	"github.com/Luzifer/dockerproxy/hostmatch"
+)
+
+// Container labels configuring the domains of a slug
+const (
+	domainsLabel         = "io.luzifer.dockerproxy.domains"
+	forceSSLLabel        = "io.luzifer.dockerproxy.force_ssl"
+	letsEncryptLabel     = "io.luzifer.dockerproxy.letsencrypt"
+	authTypeLabel        = "io.luzifer.dockerproxy.auth.type"
+	authConfigLabelStart = "io.luzifer.dockerproxy.auth.config."
+)
+
+var (
+	// labelDomainsLock serializes merging the label domains into the
+	// configuration from the discovery and the config reload
+	labelDomainsLock    sync.Mutex
+	currentLabelDomains = map[string]domainConfig{}
+	currentLabelErrors  string
+	lastLabelConflicts  string
+)
+
+// domainFromLabels builds the domain configuration of a container from
+// its labels, containers without domains label return no domains
+func domainFromLabels(backend discovery.Backend) ([]string, domainConfig, error) {
+	domainCFG := domainConfig{Slug: backend.Slug}
+
+	domains := []string{}
+	for _, domain := range strings.Split(backend.Labels[domainsLabel], ",") {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if hostmatch.Kind(domain) != hostmatch.KindExact {
+			return nil, domainCFG, fmt.Errorf("Domain %s is no host name, patterns are only supported in the config file", domain)
+		}
+		domains = append(domains, domain)
+	}
+	if len(domains) == 0 {
+		return nil, domainCFG, nil
+	}
+
+	for label, target := range map[string]*bool{
+		forceSSLLabel:    &domainCFG.ForceSSL,
+		letsEncryptLabel: &domainCFG.UseLetsEncrypt,
+	} {
+		value, ok := backend.Labels[label]
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, domainCFG, fmt.Errorf("Invalid value %q for label %s", value, label)
+		}
+		*target = b
+	}
+
+	authConfig := map[string]interface{}{}
+	for label, value := range backend.Labels {
+		if strings.HasPrefix(label, authConfigLabelStart) {
+			authConfig[strings.TrimPrefix(label, authConfigLabelStart)] = value
+		}
+	}
+	if authType := backend.Labels[authTypeLabel]; authType != "" {
+		if _, err := auth.GetAuthHandler(authType); err != nil {
+			return nil, domainCFG, err
+		}
+		domainCFG.Authentication = domainAuth{Type: authType, Config: authConfig}
+	} else if len(authConfig) > 0 {
+		return nil, domainCFG, fmt.Errorf("Label %s is required for the authentication config", authTypeLabel)
+	}
+
+	return domains, domainCFG, nil
+}
+
+// labelDomains collects the domains configured by the labels of all
+// backends. Domains claimed with differing configurations, for example
+// for different slugs, are left out and reported as conflicts.
+func labelDomains(backends []discovery.Backend) (map[string]domainConfig, []error) {
+	var (
+		errs      []error
+		domains   = map[string]domainConfig{}
+		conflicts = map[string]bool{}
+	)
+
+	for _, backend := range backends {
+		names, domainCFG, err := domainFromLabels(backend)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Invalid domain labels of slug %s: %s", backend.Slug, err))
+			continue
+		}
+
+		for _, name := range names {
+			if existing, ok := domains[name]; ok && !reflect.DeepEqual(existing, domainCFG) {
+				conflicts[name] = true
+				continue
+			}
+			domains[name] = domainCFG
+		}
+	}
+
+	for name := range conflicts {
+		errs = append(errs, fmt.Errorf("Domain %s is configured differently by the labels of multiple containers", name))
+		delete(domains, name)
+	}
+
+	return domains, errs
+}
+
+// applyLabelDomains merges the current label domains into the config
+// and logs ignored domains if they changed since the last merge
+func applyLabelDomains(p *proxyConfig) *proxyConfig {
+	domains, errs := labelDomains(containers.Backends())
+	merged, conflicts := p.withLabelDomains(domains)
+
+	if summary := errorSummary(append(errs, conflicts...)); summary != lastLabelConflicts {
+		for _, msg := range strings.Split(summary, "\n") {
+			if msg != "" {
+				log.Printf("[Docker] Ignoring label domain: %s", msg)
+			}
+		}
+		lastLabelConflicts = summary
+	}
+
+	currentLabelDomains = domains
+	currentLabelErrors = errorSummary(errs)
+	return merged
+}
+
+func errorSummary(errs []error) string {
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	sort.Strings(msgs)
+	return strings.Join(msgs, "\n")
+}
+
+// watchLabelDomains updates the domains configured by container labels
+// whenever the discovered containers change
+func watchLabelDomains(changes <-chan struct{}) {
+	for range changes {
+		domains, errs := labelDomains(containers.Backends())
+
+		labelDomainsLock.Lock()
+		if !reflect.DeepEqual(domains, currentLabelDomains) || errorSummary(errs) != currentLabelErrors {
+			setProxyConfiguration(applyLabelDomains(proxyConfiguration()))
+		}
+		labelDomainsLock.Unlock()
+	}
+}
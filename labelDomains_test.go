@@ -0,0 +1,213 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Luzifer/dockerproxy/discovery"
+)
+
+func TestDomainFromLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		labels  map[string]string
+		domains []string
+		config  domainConfig
+		err     string
+	}{
+		{
+			name:   "no domains",
+			labels: map[string]string{forceSSLLabel: "true"},
+			config: domainConfig{Slug: "app"},
+		},
+		{
+			name:    "normalized domains",
+			labels:  map[string]string{domainsLabel: " App.example.com, ,www.example.com"},
+			domains: []string{"app.example.com", "www.example.com"},
+			config:  domainConfig{Slug: "app"},
+		},
+		{
+			name:    "bool labels",
+			labels:  map[string]string{domainsLabel: "app.example.com", forceSSLLabel: "1", letsEncryptLabel: "TRUE"},
+			domains: []string{"app.example.com"},
+			config:  domainConfig{Slug: "app", ForceSSL: true, UseLetsEncrypt: true},
+		},
+		{
+			name:    "disabled bool label",
+			labels:  map[string]string{domainsLabel: "app.example.com", forceSSLLabel: "false"},
+			domains: []string{"app.example.com"},
+			config:  domainConfig{Slug: "app"},
+		},
+		{
+			name:   "invalid bool label",
+			labels: map[string]string{domainsLabel: "app.example.com", letsEncryptLabel: "yes"},
+			err:    `Invalid value "yes" for label ` + letsEncryptLabel,
+		},
+		{
+			name:   "wildcard domain",
+			labels: map[string]string{domainsLabel: "*.example.com"},
+			err:    "Domain *.example.com is no host name",
+		},
+		{
+			name:   "regex domain",
+			labels: map[string]string{domainsLabel: `pr-(\d+)\.example\.com`},
+			err:    `Domain pr-(\d+)\.example\.com is no host name`,
+		},
+		{
+			name: "authentication",
+			labels: map[string]string{
+				domainsLabel:                     "app.example.com",
+				authTypeLabel:                    "basic-auth",
+				authConfigLabelStart + "user":    "admin",
+				authConfigLabelStart + "pass":    "secret",
+				"io.luzifer.dockerproxy.unknown": "ignored",
+			},
+			domains: []string{"app.example.com"},
+			config: domainConfig{Slug: "app", Authentication: domainAuth{
+				Type:   "basic-auth",
+				Config: map[string]interface{}{"user": "admin", "pass": "secret"},
+			}},
+		},
+		{
+			name:   "unknown auth type",
+			labels: map[string]string{domainsLabel: "app.example.com", authTypeLabel: "magic"},
+			err:    "Unable to find authentication type 'magic'",
+		},
+		{
+			name:   "auth config without type",
+			labels: map[string]string{domainsLabel: "app.example.com", authConfigLabelStart + "user": "admin"},
+			err:    "Label " + authTypeLabel + " is required",
+		},
+	} {
+		domains, config, err := domainFromLabels(discovery.Backend{Slug: "app", Labels: tc.labels})
+		if tc.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(domains, tc.domains) {
+			t.Errorf("%s: expected domains %v, got %v", tc.name, tc.domains, domains)
+		}
+		if !reflect.DeepEqual(config, tc.config) {
+			t.Errorf("%s: expected config %#v, got %#v", tc.name, tc.config, config)
+		}
+	}
+}
+
+func TestLabelDomainsConflicts(t *testing.T) {
+	domains, errs := labelDomains([]discovery.Backend{
+		{Slug: "app", Labels: map[string]string{domainsLabel: "app.example.com,shared.example.com"}},
+		{Slug: "other", Labels: map[string]string{domainsLabel: "shared.example.com,other.example.com"}},
+		{Slug: "app", Labels: map[string]string{domainsLabel: "app.example.com"}},
+		{Slug: "broken", Labels: map[string]string{domainsLabel: "*.example.com"}},
+		{Slug: "unrouted"},
+	})
+
+	expected := map[string]domainConfig{
+		"app.example.com":   {Slug: "app"},
+		"other.example.com": {Slug: "other"},
+	}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Errorf("Unexpected domains %#v", domains)
+	}
+
+	summary := errorSummary(errs)
+	for _, msg := range []string{
+		"Invalid domain labels of slug broken",
+		"Domain shared.example.com is configured differently",
+	} {
+		if !strings.Contains(summary, msg) {
+			t.Errorf("Expected error %q, got %q", msg, summary)
+		}
+	}
+	if len(errs) != 2 {
+		t.Errorf("Expected 2 errors, got %d: %q", len(errs), summary)
+	}
+}
+
+func TestWithLabelDomains(t *testing.T) {
+	loadTestConfig(t, `---
+domains:
+  app.example.com:
+    slug: app
+  "*.preview.example.com":
+    slug: preview-$1
+  'pr-(\d+)\.example\.com':
+    slug: pr-$1
+`)
+	config := proxyConfiguration()
+
+	for _, tc := range []struct {
+		name     string
+		labels   map[string]domainConfig
+		conflict string
+		hosts    map[string]string
+	}{
+		{
+			name:   "added domain",
+			labels: map[string]domainConfig{"new.example.com": {Slug: "new"}},
+			hosts: map[string]string{
+				"new.example.com":          "new",
+				"app.example.com":          "app",
+				"feat.preview.example.com": "preview-$1",
+			},
+		},
+		{
+			name:     "exact config domain",
+			labels:   map[string]domainConfig{"app.example.com": {Slug: "label"}},
+			conflict: "Domain app.example.com is already configured as app.example.com in the config file",
+			hosts:    map[string]string{"app.example.com": "app"},
+		},
+		{
+			name:     "wildcard config domain",
+			labels:   map[string]domainConfig{"feat.preview.example.com": {Slug: "label"}},
+			conflict: "Domain feat.preview.example.com is already configured as *.preview.example.com in the config file",
+			hosts:    map[string]string{"feat.preview.example.com": "preview-$1"},
+		},
+		{
+			name:     "regex config domain",
+			labels:   map[string]domainConfig{"pr-7.example.com": {Slug: "label"}},
+			conflict: `Domain pr-7.example.com is already configured as pr-(\d+)\.example\.com in the config file`,
+			hosts:    map[string]string{"pr-7.example.com": "pr-$1"},
+		},
+		{
+			name: "invalid matcher",
+			labels: map[string]domainConfig{
+				"new.example.com":     {Slug: "new"},
+				`pr-(\d+.example.org`: {Slug: "broken"},
+			},
+			conflict: "Invalid host expression",
+			hosts:    map[string]string{"new.example.com": "", "app.example.com": "app"},
+		},
+	} {
+		merged, conflicts := config.withLabelDomains(tc.labels)
+
+		switch {
+		case tc.conflict == "" && len(conflicts) > 0:
+			t.Errorf("%s: unexpected conflicts %v", tc.name, conflicts)
+		case tc.conflict != "" && (len(conflicts) != 1 || !strings.HasPrefix(conflicts[0].Error(), tc.conflict)):
+			t.Errorf("%s: expected conflict %q, got %v", tc.name, tc.conflict, conflicts)
+		}
+
+		for host, slug := range tc.hosts {
+			domainCFG, _, ok := merged.domainFor(host)
+			if ok != (slug != "") || domainCFG.Slug != slug {
+				t.Errorf("%s: expected slug %q for %s, got %q (%v)", tc.name, slug, host, domainCFG.Slug, ok)
+			}
+		}
+
+		if tc.conflict != "" && !reflect.DeepEqual(merged.Domains, config.configDomains) {
+			t.Errorf("%s: expected config file domains, got %#v", tc.name, merged.Domains)
+		}
+	}
+
+	if len(config.Domains) != 3 {
+		t.Errorf("Config was modified: %#v", config.Domains)
+	}
+}
@@ -1,53 +1,27 @@
 package main
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
-	"encoding/gob"
-	"encoding/pem"
 	"fmt"
 	"log"
-	"os"
-	"path"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/ericchiang/letsencrypt"
-	homedir "github.com/mitchellh/go-homedir"
-)
-
-var (
-	supportedChallenges = []string{
-		letsencrypt.ChallengeHTTP,
-	}
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/storage"
 )
 
 const (
 	renewTimeLeft = 30 * 24 * time.Hour
 )
 
-func init() {
-	gob.Register(letsEncryptClientCache{})
-	gob.Register(rsa.PrivateKey{})
-	gob.Register(rsa.PublicKey{})
-	gob.Register(x509.Certificate{})
-}
-
-type letsEncryptClientCache struct {
-	AccountKey   *rsa.PrivateKey
-	Certificates map[string]letsEncryptClientCertificateCache
-}
-
-type letsEncryptClientCertificateCache struct {
-	Certificate *x509.Certificate
-	Key         *rsa.PrivateKey
-}
-
 type letsEncryptClientChallenge struct {
+	Type     string
 	Path     string
 	Response string
 }
@@ -55,204 +29,348 @@ type letsEncryptClientChallenge struct {
 type letsEncryptClientChallenges map[string]letsEncryptClientChallenge
 
 type letsEncryptClient struct {
-	Challenges letsEncryptClientChallenges
+	challenges     letsEncryptClientChallenges
+	challengesLock sync.RWMutex
+
+	server  string
+	contact []string
+	eab     *acme.ExternalAccountBinding
+	solvers []acme.Solver
+	client  *acme.Client
+	// clientLock guards the creation and registration of the client
+	clientLock sync.Mutex
+
+	accountKeyType acme.KeyType
+	keyTypes       []acme.KeyType
+
+	store     storage.Storage
+	storeLock sync.Mutex
+	// forceRenew contains the certificates to fetch again regardless of
+	// the stored certificate, set by DropCachedCertificates
+	forceRenew map[string]bool
+
+	// orders contains the certificates currently fetched by their
+	// storage key, concurrent requests wait for the running order
+	orders     map[string]*certificateOrder
+	ordersLock sync.Mutex
+}
 
-	client *letsencrypt.Client
+// certificateOrder is the result of fetching a certificate, it is
+// available after done was closed
+type certificateOrder struct {
+	done  chan struct{}
+	chain []*x509.Certificate
+	key   crypto.Signer
+	err   error
+}
 
-	// Caching
-	cache     letsEncryptClientCache
-	cacheFile string
+// letsEncryptOptions configures the letsEncryptClient
+type letsEncryptOptions struct {
+	Server     string
+	Email      string
+	EAB        *acme.ExternalAccountBinding
+	Challenges []string
+	// AccountKeyType is used when creating a new account
+	AccountKeyType acme.KeyType
+	// KeyTypes lists the certificate keys, one certificate is requested
+	// per key type
+	KeyTypes []acme.KeyType
+	Store    storage.Storage
 }
 
-func newLetsEncryptClient(server string) (*letsEncryptClient, error) {
-	homedir, err := homedir.Dir()
-	if err != nil {
-		return nil, err
+func newLetsEncryptClient(opts letsEncryptOptions) (*letsEncryptClient, error) {
+	var contact []string
+	if opts.Email != "" {
+		contact = []string{"mailto:" + opts.Email}
 	}
 
-	cacheFile := path.Join(homedir, ".config", "dockerproxy.lecache")
-	os.MkdirAll(path.Dir(cacheFile), 0600)
-
-	cache := letsEncryptClientCache{
-		Certificates: make(map[string]letsEncryptClientCertificateCache),
-	}
-	if _, err := os.Stat(cacheFile); err == nil {
-		cf, err := os.Open(cacheFile)
-		if err != nil {
-			return nil, err
-		}
-		defer cf.Close()
-		if err := gob.NewDecoder(cf).Decode(&cache); err != nil {
-			return nil, err
-		}
+	if len(opts.KeyTypes) == 0 {
+		return nil, fmt.Errorf("No certificate key types configured")
 	}
 
-	cli, err := letsencrypt.NewClient(server)
-	if err != nil {
-		return nil, err
+	l := &letsEncryptClient{
+		challenges: make(letsEncryptClientChallenges),
+
+		server:         opts.Server,
+		contact:        contact,
+		eab:            opts.EAB,
+		accountKeyType: opts.AccountKeyType,
+		keyTypes:       opts.KeyTypes,
+		store:          opts.Store,
+		forceRenew:     make(map[string]bool),
+		orders:         make(map[string]*certificateOrder),
 	}
 
-	return &letsEncryptClient{
-		Challenges: make(letsEncryptClientChallenges),
-
-		client:    cli,
-		cache:     cache,
-		cacheFile: cacheFile,
-	}, nil
-}
-
-func (l *letsEncryptClient) saveCache() error {
-	os.MkdirAll(path.Dir(l.cacheFile), 0600)
-
-	cf, err := os.Create(l.cacheFile)
-	if err != nil {
-		return err
+	// Domains having a DNS provider are always validated using DNS as
+	// this is the only way to get wildcard certificates. Other solvers
+	// are tried in the order of the configured challenges.
+	l.solvers = []acme.Solver{dnsSolver{l}}
+	for _, c := range opts.Challenges {
+		switch c {
+		case acme.ChallengeHTTP01:
+			l.solvers = append(l.solvers, httpSolver{l})
+		case acme.ChallengeTLSALPN01:
+			l.solvers = append(l.solvers, tlsALPNSolver{l})
+		default:
+			return nil, fmt.Errorf("Unsupported challenge type %q", c)
+		}
+	}
+	if len(l.solvers) == 1 {
+		return nil, fmt.Errorf("No challenge types configured")
 	}
-	defer cf.Close()
 
-	return gob.NewEncoder(cf).Encode(l.cache)
+	return l, nil
 }
 
 func (l *letsEncryptClient) log(format string, args ...interface{}) {
 	log.Printf("[LetsEncrypt] "+format, args...)
 }
 
-func (l *letsEncryptClient) getAccountKey() (*rsa.PrivateKey, error) {
-	if l.cache.AccountKey != nil {
-		return l.cache.AccountKey, nil
+// getClient returns an ACME client for a registered account, creating
+// the account key and registering it if required
+func (l *letsEncryptClient) getClient() (*acme.Client, error) {
+	l.clientLock.Lock()
+	defer l.clientLock.Unlock()
+
+	if l.client != nil {
+		return l.client, nil
 	}
 
-	l.log("Registering new AccountKey")
+	account, err := l.store.LoadAccount(l.server)
+	switch err {
+	case nil:
+	case storage.ErrNotFound:
+		l.log("Creating new %s AccountKey", l.accountKeyType)
 
-	accountKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
+		accountKey, err := acme.GenerateKey(l.accountKeyType)
+		if err != nil {
+			return nil, err
+		}
+		account = &storage.Account{Directory: l.server, Key: accountKey}
+	default:
 		return nil, err
 	}
 
-	if _, err := l.client.NewRegistration(accountKey); err != nil {
-		return nil, err
-	}
+	client := acme.NewClient(l.server, account.Key)
 
-	l.cache.AccountKey = accountKey
-	if err := l.saveCache(); err != nil {
-		return nil, err
+	// Accounts migrated from the v1 API need to be registered, an existing
+	// account for the key is returned by the server
+	if account.URL == "" {
+		l.log("Registering account at %s", l.server)
+		if _, err := client.Register(l.contact, l.eab); err != nil {
+			return nil, err
+		}
+
+		account.URL = client.AccountURL
+		if err := l.store.SaveAccount(account); err != nil {
+			return nil, err
+		}
 	}
+	client.AccountURL = account.URL
 
-	return accountKey, nil
+	l.client = client
+	return client, nil
 }
 
-func (l *letsEncryptClient) authorizeDomain(domain string) error {
-	log.Printf("Authorizing domain: %s", domain)
+// Challenge returns the pending challenge for the host
+func (l *letsEncryptClient) Challenge(host string) (letsEncryptClientChallenge, bool) {
+	l.challengesLock.RLock()
+	defer l.challengesLock.RUnlock()
+	c, ok := l.challenges[host]
+	return c, ok
+}
 
-	accountKey, err := l.getAccountKey()
-	if err != nil {
-		return err
+// Challenges returns a copy of all pending challenges
+func (l *letsEncryptClient) Challenges() letsEncryptClientChallenges {
+	l.challengesLock.RLock()
+	defer l.challengesLock.RUnlock()
+	result := make(letsEncryptClientChallenges, len(l.challenges))
+	for k, v := range l.challenges {
+		result[k] = v
 	}
+	return result
+}
 
-	// ask for a set of challenges for a given domain
-	auth, _, err := l.client.NewAuthorization(accountKey, "dns", domain)
-	if err != nil {
-		return err
-	}
-	chals := auth.Combinations(supportedChallenges...)
-	if len(chals) == 0 {
-		return fmt.Errorf("no supported challenge combinations")
-	}
+// httpSolver answers http-01 challenges through the HTTP listener
+type httpSolver struct {
+	client *letsEncryptClient
+}
 
-	// HTTP Challenge handling
-	chal := chals[0][0]
-	if chal.Type != letsencrypt.ChallengeHTTP {
-		return fmt.Errorf("Did not find a HTTP challenge")
+func (h httpSolver) Type() string { return acme.ChallengeHTTP01 }
+
+func (h httpSolver) Present(domain, token, keyAuth string) error {
+	h.client.log("Authorizing domain: %s", domain)
+
+	h.client.challengesLock.Lock()
+	defer h.client.challengesLock.Unlock()
+	h.client.challenges[domain] = letsEncryptClientChallenge{
+		Type:     acme.ChallengeHTTP01,
+		Path:     "/.well-known/acme-challenge/" + token,
+		Response: keyAuth,
 	}
+	return nil
+}
 
-	path, resource, err := chal.HTTP(accountKey)
+func (h httpSolver) CleanUp(domain, token, keyAuth string) error {
+	h.client.challengesLock.Lock()
+	defer h.client.challengesLock.Unlock()
+	delete(h.client.challenges, domain)
+	return nil
+}
+
+// tlsALPNSolver answers tls-alpn-01 challenges through the SNI listener
+type tlsALPNSolver struct {
+	client *letsEncryptClient
+}
+
+func (t tlsALPNSolver) Type() string { return acme.ChallengeTLSALPN01 }
+
+func (t tlsALPNSolver) Present(domain, token, keyAuth string) error {
+	t.client.log("Authorizing domain using TLS-ALPN: %s", domain)
+
+	cert, err := acme.TLSALPN01ChallengeCert(domain, keyAuth)
 	if err != nil {
 		return err
 	}
+	sniServer.SetChallengeCertificate(domain, cert)
 
-	l.Challenges[domain] = letsEncryptClientChallenge{
-		Path:     path,
-		Response: resource,
+	t.client.challengesLock.Lock()
+	defer t.client.challengesLock.Unlock()
+	t.client.challenges[domain] = letsEncryptClientChallenge{
+		Type:     acme.ChallengeTLSALPN01,
+		Response: keyAuth,
 	}
+	return nil
+}
+
+func (t tlsALPNSolver) CleanUp(domain, token, keyAuth string) error {
+	sniServer.SetChallengeCertificate(domain, nil)
 
-	// Tell the server the challenge is ready and poll the server for updates.
-	return l.client.ChallengeReady(accountKey, chal)
+	t.client.challengesLock.Lock()
+	defer t.client.challengesLock.Unlock()
+	delete(t.client.challenges, domain)
+	return nil
 }
 
-func (l *letsEncryptClient) hashMultiDomain(domains []string) string {
+// KeyTypes returns the key types certificates are requested for
+func (l *letsEncryptClient) KeyTypes() []acme.KeyType {
+	return l.keyTypes
+}
+
+// FetchMultiDomainCertificate returns a certificate with a key of the
+// given type valid for all given domains together with its issuer chain.
+// Stored certificates are used until they are about to expire. Only one
+// order per certificate is running at a time, concurrent calls receive
+// its result.
+func (l *letsEncryptClient) FetchMultiDomainCertificate(domains []string, keyType acme.KeyType) ([]*x509.Certificate, crypto.Signer, error) {
+	// Sort a copy to not reorder the list of the caller
+	domains = append([]string{}, domains...)
 	sort.Strings(domains)
-	rawString := strings.Join(domains, "::")
-	return fmt.Sprintf("%x", sha1.Sum([]byte(rawString)))
+	key := storage.CertificateKey(domains, string(keyType))
+
+	l.ordersLock.Lock()
+	if order, ok := l.orders[key]; ok {
+		l.ordersLock.Unlock()
+		<-order.done
+		return order.chain, order.key, order.err
+	}
+	order := &certificateOrder{done: make(chan struct{})}
+	l.orders[key] = order
+	l.ordersLock.Unlock()
+
+	order.chain, order.key, order.err = l.fetchCertificate(key, domains, keyType)
+
+	l.ordersLock.Lock()
+	delete(l.orders, key)
+	l.ordersLock.Unlock()
+	close(order.done)
+
+	return order.chain, order.key, order.err
 }
 
-func (l *letsEncryptClient) FetchMultiDomainCertificate(domains []string) (*x509.Certificate, *rsa.PrivateKey, error) {
-	domainHash := l.hashMultiDomain(domains)
-	if cert, ok := l.cache.Certificates[domainHash]; ok && cert.Certificate.NotAfter.Sub(time.Now()) > renewTimeLeft {
-		log.Printf("Using cached certificate for domains %s", strings.Join(domains, ", "))
-		return cert.Certificate, cert.Key, nil
+// storedCertificate loads the certificate from the storage if it is
+// not marked for renewal and not about to expire
+func (l *letsEncryptClient) storedCertificate(key string) (*storage.Certificate, bool) {
+	l.storeLock.Lock()
+	defer l.storeLock.Unlock()
+
+	if l.forceRenew[key] {
+		return nil, false
 	}
 
-	accountKey, err := l.getAccountKey()
-	if err != nil {
-		return nil, nil, err
+	cert, err := l.store.LoadCertificate(key)
+	switch {
+	case err == storage.ErrNotFound:
+	case err != nil:
+		l.log("Unable to load stored certificate, fetching new one: %s", err)
+	case len(cert.Chain) > 0 && cert.Chain[0].NotAfter.Sub(time.Now()) > renewTimeLeft:
+		return cert, true
 	}
+	return nil, false
+}
 
-	for _, domain := range domains {
-		if err := leClient.authorizeDomain(domain); err != nil {
-			return nil, nil, err
-		}
+func (l *letsEncryptClient) fetchCertificate(key string, domains []string, keyType acme.KeyType) ([]*x509.Certificate, crypto.Signer, error) {
+	if cert, ok := l.storedCertificate(key); ok {
+		l.log("Using stored %s certificate for domains %s", keyType, strings.Join(domains, ", "))
+		return cert.Chain, cert.Key, nil
 	}
 
-	csr, certKey, err := l.createMultiDomainCSR(domains)
+	client, err := l.getClient()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	cert, err := l.client.NewCertificate(accountKey, csr)
+	csr, certKey, err := l.createMultiDomainCSR(domains, keyType)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	l.cache.Certificates[domainHash] = letsEncryptClientCertificateCache{
-		Certificate: cert,
-		Key:         certKey,
+	// The order including the validation runs without holding the
+	// store lock as DNS propagation may take minutes
+	chain, err := client.ObtainCertificate(domains, csr, l.solvers)
+	if err != nil {
+		return nil, nil, err
 	}
-	if err := l.saveCache(); err != nil {
+
+	l.storeLock.Lock()
+	defer l.storeLock.Unlock()
+	if err := l.store.SaveCertificate(key, &storage.Certificate{Chain: chain, Key: certKey}); err != nil {
 		return nil, nil, err
 	}
+	delete(l.forceRenew, key)
 
-	log.Printf("Fetched fresh certificate for domains %s", strings.Join(domains, ", "))
-	return cert, certKey, err
+	l.log("Fetched fresh %s certificate for domains %s", keyType, strings.Join(domains, ", "))
+	return chain, certKey, nil
 }
 
-func (l *letsEncryptClient) createMultiDomainCSR(domains []string) (*x509.CertificateRequest, *rsa.PrivateKey, error) {
-	certKey, err := rsa.GenerateKey(rand.Reader, 4096)
+// DropCachedCertificates marks all stored certificates to be fetched
+// again on next request. They are kept in the storage until replaced.
+func (l *letsEncryptClient) DropCachedCertificates() {
+	l.storeLock.Lock()
+	defer l.storeLock.Unlock()
+
+	keys, err := l.store.ListCertificates()
 	if err != nil {
-		return nil, nil, err
+		l.log("Unable to list stored certificates: %s", err)
+		return
 	}
-	template := &x509.CertificateRequest{
-		SignatureAlgorithm: x509.SHA256WithRSA,
-		PublicKeyAlgorithm: x509.RSA,
-		PublicKey:          &certKey.PublicKey,
-		Subject:            pkix.Name{CommonName: domains[0]},
-		DNSNames:           domains,
+	for _, key := range keys {
+		l.forceRenew[key] = true
 	}
-	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, certKey)
+}
+
+func (l *letsEncryptClient) createMultiDomainCSR(domains []string, keyType acme.KeyType) ([]byte, crypto.Signer, error) {
+	certKey, err := acme.GenerateKey(keyType)
 	if err != nil {
 		return nil, nil, err
 	}
-	csr, err := x509.ParseCertificateRequest(csrDER)
-	if err != nil {
-		return nil, nil, err
+	template := &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
 	}
-	return csr, certKey, nil
-}
-
-func (l *letsEncryptClient) GetIntermediateCertificate() (*x509.Certificate, error) {
-	crtData, err := Asset("assets/lets-encrypt-x3-cross-signed.pem")
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, template, certKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	b, _ := pem.Decode(crtData)
-	return x509.ParseCertificate(b.Bytes)
+	return csrDER, certKey, nil
 }
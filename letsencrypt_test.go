@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/storage"
+)
+
+func newTestLetsEncryptClient(t *testing.T) (*letsEncryptClient, func()) {
+	dir, err := ioutil.TempDir("", "letsencrypt")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	store, err := storage.NewFilesystem(dir)
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+
+	// The server is not reachable, tests must not place orders
+	l, err := newLetsEncryptClient(letsEncryptOptions{
+		Server:     "http://127.0.0.1:1/directory",
+		Challenges: []string{acme.ChallengeHTTP01},
+		KeyTypes:   []acme.KeyType{acme.KeyP256},
+		Store:      store,
+	})
+	if err != nil {
+		t.Fatalf("Unable to create client: %s", err)
+	}
+	return l, func() { os.RemoveAll(dir) }
+}
+
+func TestFetchCertificateJoinsRunningOrder(t *testing.T) {
+	l, cleanup := newTestLetsEncryptClient(t)
+	defer cleanup()
+
+	domains := []string{"a.example.com"}
+	order := &certificateOrder{done: make(chan struct{})}
+	l.orders[storage.CertificateKey(domains, string(acme.KeyP256))] = order
+
+	type result struct {
+		chain []*x509.Certificate
+		key   crypto.Signer
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		chain, key, err := l.FetchMultiDomainCertificate(domains, acme.KeyP256)
+		results <- result{chain, key, err}
+	}()
+
+	select {
+	case <-results:
+		t.Fatalf("Call did not wait for the running order")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	key, err := acme.GenerateKey(acme.KeyP256)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	order.chain = []*x509.Certificate{{}}
+	order.key = key
+	close(order.done)
+
+	r := <-results
+	if r.err != nil || r.key != key || len(r.chain) != 1 {
+		t.Errorf("Result of the running order was not returned: %#v", r)
+	}
+}
+
+func TestFetchCertificateKeepsDomainOrder(t *testing.T) {
+	l, cleanup := newTestLetsEncryptClient(t)
+	defer cleanup()
+
+	domains := []string{"b.example.com", "a.example.com"}
+	key := storage.CertificateKey([]string{"a.example.com", "b.example.com"}, string(acme.KeyP256))
+	order := &certificateOrder{done: make(chan struct{})}
+	close(order.done)
+	l.orders[key] = order
+
+	l.FetchMultiDomainCertificate(domains, acme.KeyP256)
+	if domains[0] != "b.example.com" {
+		t.Errorf("Domains of the caller were reordered: %v", domains)
+	}
+}
+
+func TestFetchCertificateUsesStoredCertificateWithoutIntermediate(t *testing.T) {
+	l, cleanup := newTestLetsEncryptClient(t)
+	defer cleanup()
+
+	key, err := acme.GenerateKey(acme.KeyP256)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "a.example.com"},
+		DNSNames:     []string{"a.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s", err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	domains := []string{"a.example.com"}
+	if err := l.store.SaveCertificate(storage.CertificateKey(domains, string(acme.KeyP256)), &storage.Certificate{
+		Chain: []*x509.Certificate{leaf},
+		Key:   key,
+	}); err != nil {
+		t.Fatalf("Unable to store certificate: %s", err)
+	}
+
+	chain, _, err := l.FetchMultiDomainCertificate(domains, acme.KeyP256)
+	if err != nil {
+		t.Fatalf("Stored certificate was not used: %s", err)
+	}
+	if len(chain) != 1 || !chain[0].Equal(leaf) {
+		t.Errorf("Unexpected chain %#v", chain)
+	}
+}
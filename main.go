@@ -1,13 +1,14 @@
 package main
 
-//go:generate make bindata
-
 import (
 	"io"
 	"log"
 	"net/http"
 	"strings"
 
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/discovery"
+	"github.com/Luzifer/dockerproxy/health"
 	"github.com/Luzifer/dockerproxy/sni"
 	"github.com/Luzifer/go_helpers/str"
 	"github.com/Luzifer/rconfig"
@@ -19,18 +20,41 @@ import (
 
 var (
 	cfg = struct {
-		ConfigFile        string `flag:"configfile" default:"./config.json" description:"Location of the configuration file"`
-		LetsEncryptServer string `flag:"letsencrypt-server" default:"https://acme-v01.api.letsencrypt.org/directory" description:"ACME directory endpoint"`
+		ConfigFile        string   `flag:"configfile" default:"./config.json" description:"Location of the configuration file"`
+		LetsEncryptServer string   `flag:"letsencrypt-server" default:"https://acme-v02.api.letsencrypt.org/directory" description:"ACME directory endpoint"`
+		LetsEncryptEmail  string   `flag:"letsencrypt-email" default:"" description:"Contact email address for the ACME account"`
+		EABKeyID          string   `flag:"letsencrypt-eab-kid" default:"" description:"Key identifier for external account binding"`
+		EABHMACKey        string   `flag:"letsencrypt-eab-hmac" default:"" description:"Base64url encoded MAC key for external account binding"`
+		Challenges        []string `flag:"letsencrypt-challenges" default:"http-01,tls-alpn-01" description:"ACME challenge types to use in order of preference"`
+		AccountKeyType    string   `flag:"letsencrypt-account-key-type" default:"P-256" description:"Key type of new ACME accounts (P-256, P-384, RSA-2048, RSA-4096)"`
+		KeyTypes          []string `flag:"letsencrypt-key-types" default:"P-256" description:"Key types to request certificates for, one certificate per type (P-256, P-384, RSA-2048, RSA-4096)"`
+		StorageType       string   `flag:"storage-type" default:"filesystem" description:"Backend to store accounts and certificates in (filesystem, kv)"`
+		StoragePath       string   `flag:"storage-path" default:"" description:"Directory (filesystem) or file (kv) to store accounts and certificates in (default ~/.config/dockerproxy or ~/.config/dockerproxy.kv.json)"`
 	}{}
 
-	containers         *dockerContainers
-	proxyConfiguration *proxyConfig
-	leClient           *letsEncryptClient
-	sniServer          = sni.SNIServer{}
-
-	requestCount    *prometheus.CounterVec
-	requestDuration prometheus.Summary
-	responseSize    prometheus.Summary
+	containers      = discovery.NewRegistry()
+	dockerDiscovery = discovery.New(containers, discovery.NewDockerClient)
+	healthChecker   = health.NewChecker(setBackendHealth)
+	outliers        = health.NewOutlierDetector()
+	leClient        *letsEncryptClient
+	sniServer       = sni.SNIServer{}
+
+	requestCount     *prometheus.CounterVec
+	requestDuration  prometheus.Summary
+	responseSize     prometheus.Summary
+	backendHealthy   *prometheus.GaugeVec
+	backendRetries   *prometheus.CounterVec
+	backendEjections *prometheus.CounterVec
+
+	upgradeConnections      *prometheus.GaugeVec
+	upgradeConnectionsTotal *prometheus.CounterVec
+
+	discoveryLastSuccess *prometheus.GaugeVec
+	discoveryErrors      *prometheus.CounterVec
+	discoveredBackends   *prometheus.GaugeVec
+
+	certExpiry          *prometheus.GaugeVec
+	certRenewalFailures *prometheus.CounterVec
 )
 
 func initMetrics() {
@@ -54,29 +78,139 @@ func initMetrics() {
 	so.Help = "The HTTP request latencies in microseconds."
 	reqDur := prometheus.NewSummary(so)
 
+	bckHealthy := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem:   "backend",
+		Name:        "healthy",
+		Help:        "Whether the backend passes its health check (1) or is ejected (0).",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug", "backend"})
+
+	bckRetries := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem:   "backend",
+		Name:        "retries_total",
+		Help:        "Total number of requests retried on another backend.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug"})
+
+	bckEjections := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem:   "backend",
+		Name:        "ejections_total",
+		Help:        "Total number of backends ejected after consecutive connection failures.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug"})
+
+	upgConnections := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem:   "upgrade",
+		Name:        "connections",
+		Help:        "Number of currently open upgraded connections like WebSockets.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug"})
+
+	upgConnectionsTotal := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem:   "upgrade",
+		Name:        "connections_total",
+		Help:        "Total number of upgraded connections like WebSockets.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug"})
+
+	dscLastSuccess := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem:   "discovery",
+		Name:        "last_success_timestamp",
+		Help:        "Last successful sync with the docker daemon as unix timestamp.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"host"})
+
+	dscErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem:   "discovery",
+		Name:        "errors_total",
+		Help:        "Total number of errors talking to the docker daemon.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"host"})
+
+	dscBackends := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "discovered_backends",
+		Help:        "Number of discovered containers per slug.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"slug"})
+
+	crtExpiry := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Subsystem:   "cert",
+		Name:        "expiry_timestamp_seconds",
+		Help:        "Expiry of the served certificates as unix timestamp.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"names"})
+
+	crtRenewalFailures := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem:   "cert",
+		Name:        "renewal_failures_total",
+		Help:        "Total number of failed certificate renewal attempts.",
+		ConstLabels: so.ConstLabels,
+	}, []string{"names"})
+
 	requestCount = prometheus.MustRegisterOrGet(reqCnt).(*prometheus.CounterVec)
 	requestDuration = prometheus.MustRegisterOrGet(reqDur).(prometheus.Summary)
 	responseSize = prometheus.MustRegisterOrGet(resSz).(prometheus.Summary)
+	backendHealthy = prometheus.MustRegisterOrGet(bckHealthy).(*prometheus.GaugeVec)
+	backendRetries = prometheus.MustRegisterOrGet(bckRetries).(*prometheus.CounterVec)
+	backendEjections = prometheus.MustRegisterOrGet(bckEjections).(*prometheus.CounterVec)
+	upgradeConnections = prometheus.MustRegisterOrGet(upgConnections).(*prometheus.GaugeVec)
+	upgradeConnectionsTotal = prometheus.MustRegisterOrGet(upgConnectionsTotal).(*prometheus.CounterVec)
+	discoveryLastSuccess = prometheus.MustRegisterOrGet(dscLastSuccess).(*prometheus.GaugeVec)
+	discoveryErrors = prometheus.MustRegisterOrGet(dscErrors).(*prometheus.CounterVec)
+	discoveredBackends = prometheus.MustRegisterOrGet(dscBackends).(*prometheus.GaugeVec)
+	certExpiry = prometheus.MustRegisterOrGet(crtExpiry).(*prometheus.GaugeVec)
+	certRenewalFailures = prometheus.MustRegisterOrGet(crtRenewalFailures).(*prometheus.CounterVec)
 }
 
-func init() {
+// setup parses the flags and the configuration and creates the shared
+// clients, it is not run as init to keep the package testable
+func setup() {
 	var err error
 
 	if err := rconfig.Parse(&cfg); err != nil {
 		log.Fatalf("Unable to parse commandline flags: %s", err)
 	}
 
-	proxyConfiguration, err = newProxyConfig(cfg.ConfigFile)
+	proxyCFG, err := newProxyConfig(cfg.ConfigFile)
 	if err != nil {
 		log.Fatalf("Unable to parse configuration: %s", err)
 	}
+	setProxyConfiguration(proxyCFG)
 
-	leClient, err = newLetsEncryptClient(cfg.LetsEncryptServer)
+	opts := letsEncryptOptions{
+		Server:     cfg.LetsEncryptServer,
+		Email:      cfg.LetsEncryptEmail,
+		Challenges: cfg.Challenges,
+	}
+	if cfg.EABKeyID != "" {
+		opts.EAB = &acme.ExternalAccountBinding{KeyID: cfg.EABKeyID, HMACKey: cfg.EABHMACKey}
+	}
+	if opts.AccountKeyType, err = acme.ParseKeyType(cfg.AccountKeyType); err != nil {
+		log.Fatalf("Invalid account key type: %s", err)
+	}
+	for _, kt := range cfg.KeyTypes {
+		keyType, err := acme.ParseKeyType(kt)
+		if err != nil {
+			log.Fatalf("Invalid certificate key type: %s", err)
+		}
+		opts.KeyTypes = append(opts.KeyTypes, keyType)
+	}
+
+	if opts.Store, err = openStorage(); err != nil {
+		log.Fatalf("Unable to open certificate storage: %s", err)
+	}
+
+	leClient, err = newLetsEncryptClient(opts)
 	if err != nil {
 		log.Fatalf("Unable to create LetsEncrypt client: %s", err)
 	}
+	certRenewal = newCertRenewal()
+	onDemandTLS = newOnDemandTLS()
+	sniServer.OnDemand = onDemandTLS.GetCertificate
 
 	initMetrics()
+	dockerDiscovery.OnSuccess = discoverySucceeded
+	dockerDiscovery.OnError = discoveryFailed
 }
 
 func createDomainMap(domains []string) map[string][]string {
@@ -104,50 +238,30 @@ func createDomainMap(domains []string) map[string][]string {
 }
 
 func startSSLServer(proxy *dockerProxy, serverErrorChan chan error) {
-	// Collect certificates from disk
-	certificates := proxy.getCertificates()
-
-	// Get a certificate for all LetsEncrypt enabled domains
-	leDomains := []string{}
-	for domain, domainCFG := range proxyConfiguration.Domains {
-		if domainCFG.UseLetsEncrypt {
-			leDomains = append(leDomains, domain)
-		}
+	config := proxyConfiguration()
+	// The listener is started with the certificates available now as
+	// it is required to answer TLS-ALPN challenges for the LetsEncrypt
+	// ones which are fetched in the background
+	if err := refreshCertificates(); err != nil {
+		log.Fatalf("ERROR: %s", err)
 	}
-
-	domainMap := createDomainMap(leDomains)
-
-	if len(domainMap) > 0 {
-		for _, leDomains := range domainMap {
-			cert, key, err := leClient.FetchMultiDomainCertificate(leDomains)
-			if err != nil {
-				log.Fatalf("ERROR: Unable to get certificate: %s", err)
-			}
-			intermediate, err := leClient.GetIntermediateCertificate()
-			if err != nil {
-				log.Fatalf("ERROR: Unable to get intermediate certificate: %s", err)
-			}
-			certificates = append(certificates, sni.Certificates{
-				Certificate:  cert,
-				Key:          key,
-				Intermediate: intermediate,
-			})
-		}
+	if err := sniServer.SetPolicies(config.TLS, config.tlsPolicies()); err != nil {
+		log.Fatalf("Unable to apply TLS policies: %s", err)
 	}
 
-	go func(proxy http.Handler, certificates []sni.Certificates) {
+	go func(proxy http.Handler) {
 		httpsServer := &http.Server{
 			Handler: proxy,
-			Addr:    proxyConfiguration.ListenHTTPS,
+			Addr:    config.ListenHTTPS,
 		}
 
-		serverErrorChan <- sniServer.ListenAndServeTLSSNI(httpsServer, certificates)
-	}(proxy, certificates)
+		serverErrorChan <- sniServer.ListenAndServeTLSSNI(httpsServer, nil)
+	}(proxy)
 }
 
 func startHTTPServer(proxy *dockerProxy, serverErrorChan chan error) {
 	letsEncryptHandler := http.HandlerFunc(func(res http.ResponseWriter, r *http.Request) {
-		if challenge, ok := leClient.Challenges[r.Host]; ok {
+		if challenge, ok := leClient.Challenge(r.Host); ok && challenge.Type == acme.ChallengeHTTP01 {
 			if r.URL.RequestURI() == challenge.Path {
 				log.Printf("Got challenge request for domain %s and answered.", r.Host)
 				requestCount.WithLabelValues("acme", "200").Inc()
@@ -167,39 +281,68 @@ func startHTTPServer(proxy *dockerProxy, serverErrorChan chan error) {
 	})
 
 	go func(h http.Handler) {
-		serverErrorChan <- http.ListenAndServe(proxyConfiguration.ListenHTTP, h)
+		serverErrorChan <- http.ListenAndServe(proxyConfiguration().ListenHTTP, h)
 	}(letsEncryptHandler)
 }
 
 func startMetricsServer(serverErrorChan chan error) {
+	config := proxyConfiguration()
 	r := mux.NewRouter()
 	r.Handle("/metrics", prometheus.Handler())
+	registerAdminAPI(r)
+
+	tlsConfig, err := adminTLSConfig()
+	if err != nil {
+		log.Fatalf("Unable to configure admin API: %s", err)
+	}
 
 	go func(h http.Handler) {
-		serverErrorChan <- http.ListenAndServe(proxyConfiguration.ListenMetrics, h)
-	}(r)
+		srv := &http.Server{
+			Addr:      config.ListenMetrics,
+			Handler:   h,
+			TLSConfig: tlsConfig,
+		}
+		if tlsConfig != nil {
+			serverErrorChan <- srv.ListenAndServeTLS(config.Admin.Cert, config.Admin.Key)
+			return
+		}
+		serverErrorChan <- srv.ListenAndServe()
+	}(adminAuth(r))
 }
 
-func reloadConfiguration() {
+func reloadConfiguration() error {
 	tmp, err := newProxyConfig(cfg.ConfigFile)
 	if err == nil {
-		proxyConfiguration = tmp
-	} else {
-		log.Printf("%v\n", err)
+		labelDomainsLock.Lock()
+		setProxyConfiguration(applyLabelDomains(tmp))
+		labelDomainsLock.Unlock()
 	}
-	containers = collectDockerContainer()
+
+	config := proxyConfiguration()
+	onDemandTLS.SetConfig(config.OnDemandTLS)
+	if err := sniServer.SetPolicies(config.TLS, config.tlsPolicies()); err != nil {
+		log.Printf("Unable to apply TLS policies: %s", err)
+	}
+	dockerDiscovery.Sync(config.dockerHosts, config.Docker.discoveryConfig())
+	syncHealthChecks()
+	return err
 }
 
 func main() {
-	containers = collectDockerContainer()
+	setup()
+
+	go watchHealthTargets(containers.Subscribe())
+	go watchDiscoveredBackends(containers.Subscribe())
+	go watchLabelDomains(containers.Subscribe())
+	config := proxyConfiguration()
+	dockerDiscovery.Sync(config.dockerHosts, config.Docker.discoveryConfig())
 	proxy := newDockerProxy()
 
 	c := cron.New()
-	c.AddFunc("@every 1m", reloadConfiguration)
-	c.AddFunc("@every 720h", func() {
-		// Stop the SNI server every 30d, it will get restarted and
-		// the LetsEncrypt certificates are checked for expiry
-		sniServer.Stop()
+	c.AddFunc("@every 1m", func() {
+		if err := reloadConfiguration(); err != nil {
+			log.Printf("%v\n", err)
+		}
 	})
 	c.Start()
 
@@ -209,6 +352,10 @@ func main() {
 	startSSLServer(proxy, serverErrorChan)
 	startMetricsServer(serverErrorChan)
 
+	certRenewal.Sync(letsEncryptGroups())
+	go certRenewal.Run()
+	go watchCertificates()
+
 	for err := range serverErrorChan {
 		if err != stoppableListener.StoppedError {
 			log.Fatal(err)
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"strings"
+
+	"github.com/Luzifer/dockerproxy/ondemand"
+)
+
+var onDemandTLS *ondemand.Manager
+
+func newOnDemandTLS() *ondemand.Manager {
+	m := ondemand.New(onDemandAllowed, issueOnDemand)
+	m.SetConfig(proxyConfiguration().OnDemandTLS)
+	return m
+}
+
+// onDemandAllowed permits on-demand certificates only for hosts below
+// the generic suffix whose slug has discovered containers
+func onDemandAllowed(name string) bool {
+	generic := strings.ToLower(proxyConfiguration().Generic)
+	if generic == "" || !strings.HasSuffix(name, generic) {
+		return false
+	}
+
+	slug := strings.TrimSuffix(name, generic)
+	if slug == "" {
+		return false
+	}
+	_, ok := (*collectDockerContainer())[slug]
+	return ok
+}
+
+func issueOnDemand(name string) (*tls.Certificate, error) {
+	log.Printf("[LetsEncrypt] Requesting on-demand certificate for %s", name)
+
+	certs, err := fetchLetsEncryptCertificate([]string{name})
+	if err != nil {
+		log.Printf("[LetsEncrypt] Unable to get on-demand certificate for %s: %s", name, err)
+		return nil, err
+	}
+
+	// Hand the certificates over to the renewal manager to keep them
+	// valid, until they are loaded the first key type is served
+	go certRenewal.Sync(letsEncryptGroups())
+
+	return certs[0].TLSCertificate()
+}
@@ -0,0 +1,217 @@
+// Package ondemand obtains certificates during the TLS handshake for
+// hosts not known in advance
+package ondemand // import "github.com/Luzifer/dockerproxy/ondemand"
+
+import (
+	"crypto/tls"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrDisabled is returned if on-demand issuance is not enabled
+	ErrDisabled = errors.New("on-demand TLS is disabled")
+	// ErrNotAllowed is returned for names failing the allow check
+	ErrNotAllowed = errors.New("name is not allowed for on-demand TLS")
+	// ErrRateLimited is returned if too many certificates were issued
+	// recently or the last attempt for the name failed recently
+	ErrRateLimited = errors.New("on-demand TLS issuance is rate limited")
+	// ErrPending is returned if the certificate is still being issued
+	// after the handshake waited for HandshakeWait
+	ErrPending = errors.New("on-demand certificate is still being issued")
+)
+
+// Config controls the on-demand issuance
+type Config struct {
+	Enabled bool `json:"enabled" yaml:"enabled"`
+	// MaxIssuances is the number of certificates to issue per Period
+	MaxIssuances int           `json:"max_issuances" yaml:"max_issuances"`
+	Period       time.Duration `json:"period" yaml:"period"`
+	// RetryAfter is the time to wait before retrying a failed name
+	RetryAfter time.Duration `json:"retry_after" yaml:"retry_after"`
+	// HandshakeWait is the maximum time a handshake waits for the
+	// issuance, the issuance continues in the background afterwards
+	HandshakeWait time.Duration `json:"handshake_wait" yaml:"handshake_wait"`
+}
+
+// WithDefaults returns a copy of the config with unset values defaulted
+func (c Config) WithDefaults() Config {
+	if c.MaxIssuances == 0 {
+		c.MaxIssuances = 10
+	}
+	if c.Period == 0 {
+		c.Period = time.Hour
+	}
+	if c.RetryAfter == 0 {
+		c.RetryAfter = 10 * time.Minute
+	}
+	if c.HandshakeWait == 0 {
+		c.HandshakeWait = 5 * time.Second
+	}
+	return c
+}
+
+// AllowFunc decides whether a certificate may be issued for the name
+type AllowFunc func(name string) bool
+
+// IssueFunc obtains a certificate for the name
+type IssueFunc func(name string) (*tls.Certificate, error)
+
+type call struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// Manager issues certificates on first handshake. The issuance runs in
+// the background, concurrent handshakes for the same name share it and
+// wait for it at most HandshakeWait.
+type Manager struct {
+	sync.Mutex
+	cfg   Config
+	allow AllowFunc
+	issue IssueFunc
+
+	certs  map[string]*tls.Certificate
+	calls  map[string]*call
+	failed map[string]time.Time
+	issued []time.Time
+
+	now func() time.Time
+}
+
+// New creates a disabled Manager, use SetConfig to enable it
+func New(allow AllowFunc, issue IssueFunc) *Manager {
+	return &Manager{
+		allow:  allow,
+		issue:  issue,
+		certs:  make(map[string]*tls.Certificate),
+		calls:  make(map[string]*call),
+		failed: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// SetConfig replaces the configuration
+func (m *Manager) SetConfig(cfg Config) {
+	m.Lock()
+	defer m.Unlock()
+	m.cfg = cfg.WithDefaults()
+}
+
+// GetCertificate returns the certificate for the requested name,
+// issuing it if required. If the issuance takes longer than
+// HandshakeWait ErrPending is returned and the certificate is served
+// to later handshakes once it was issued.
+func (m *Manager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	m.Lock()
+	if !m.cfg.Enabled {
+		m.Unlock()
+		return nil, ErrDisabled
+	}
+	m.Unlock()
+
+	// The allow check might be expensive and is done without the lock
+	if name == "" || !m.allow(name) {
+		return nil, ErrNotAllowed
+	}
+
+	m.Lock()
+	now := m.now()
+	if cert, ok := m.certs[name]; ok && (cert.Leaf == nil || cert.Leaf.NotAfter.After(now)) {
+		m.Unlock()
+		return cert, nil
+	}
+
+	c, ok := m.calls[name]
+	if !ok {
+		if m.failed[name].After(now) || !m.take(now) {
+			m.Unlock()
+			return nil, ErrRateLimited
+		}
+
+		c = &call{done: make(chan struct{})}
+		m.calls[name] = c
+		go m.run(name, c)
+	}
+	wait := m.cfg.HandshakeWait
+	m.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+		return c.cert, c.err
+	case <-timer.C:
+		return nil, ErrPending
+	}
+}
+
+// run issues the certificate for the name and stores the result
+func (m *Manager) run(name string, c *call) {
+	c.cert, c.err = m.issue(name)
+
+	m.Lock()
+	delete(m.calls, name)
+	if c.err != nil {
+		m.failed[name] = m.now().Add(m.cfg.RetryAfter)
+	} else {
+		delete(m.failed, name)
+		m.certs[name] = c.cert
+	}
+	m.Unlock()
+
+	close(c.done)
+}
+
+// take consumes one issuance from the rate limit if available
+func (m *Manager) take(now time.Time) bool {
+	recent := m.issued[:0]
+	for _, t := range m.issued {
+		if now.Sub(t) < m.cfg.Period {
+			recent = append(recent, t)
+		}
+	}
+	m.issued = recent
+
+	if len(m.issued) >= m.cfg.MaxIssuances {
+		return false
+	}
+	m.issued = append(m.issued, now)
+	return true
+}
+
+// Names returns the names certificates were issued for
+func (m *Manager) Names() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	names := []string{}
+	for name := range m.certs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Prune forgets the certificates of names no longer allowed and reports
+// whether any name was removed
+func (m *Manager) Prune() bool {
+	removed := false
+	for _, name := range m.Names() {
+		if m.allow(name) {
+			continue
+		}
+		m.Lock()
+		delete(m.certs, name)
+		m.Unlock()
+		removed = true
+	}
+	return removed
+}
@@ -0,0 +1,139 @@
+package ondemand
+
+import (
+	"crypto/tls"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentHandshakesShareIssuance(t *testing.T) {
+	var issued int32
+	release := make(chan struct{})
+	m := New(
+		func(name string) bool { return name == "app.example.com" },
+		func(name string) (*tls.Certificate, error) {
+			atomic.AddInt32(&issued, 1)
+			<-release
+			return &tls.Certificate{}, nil
+		},
+	)
+	m.SetConfig(Config{Enabled: true})
+
+	var wg sync.WaitGroup
+	certs := make([]*tls.Certificate, 5)
+	for i := range certs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			certs[i], _ = m.GetCertificate(&tls.ClientHelloInfo{ServerName: "App.example.com"})
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&issued); n != 1 {
+		t.Errorf("Expected one issuance, got %d", n)
+	}
+	for i, c := range certs {
+		if c == nil || c != certs[0] {
+			t.Errorf("Handshake %d got no or a different certificate", i)
+		}
+	}
+	if names := m.Names(); len(names) != 1 || names[0] != "app.example.com" {
+		t.Errorf("Unexpected names: %v", names)
+	}
+}
+
+func TestAllowAndRateLimit(t *testing.T) {
+	allowed := map[string]bool{"a.example.com": true, "b.example.com": true, "c.example.com": true}
+	var fail bool
+	m := New(
+		func(name string) bool { return allowed[name] },
+		func(name string) (*tls.Certificate, error) {
+			if fail {
+				return nil, errors.New("order failed")
+			}
+			return &tls.Certificate{}, nil
+		},
+	)
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"}); err != ErrDisabled {
+		t.Errorf("Disabled manager issued certificate: %v", err)
+	}
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+	m.SetConfig(Config{Enabled: true, MaxIssuances: 2, Period: time.Hour, RetryAfter: time.Minute})
+
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "evil.example.com"}); err != ErrNotAllowed {
+		t.Errorf("Unknown name was not rejected: %v", err)
+	}
+
+	fail = true
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"}); err == nil {
+		t.Fatalf("Failed issuance returned no error")
+	}
+	fail = false
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"}); err != ErrRateLimited {
+		t.Errorf("Failed name was retried immediately: %v", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "b.example.com"}); err != nil {
+		t.Errorf("Issuance failed: %v", err)
+	}
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.example.com"}); err != ErrRateLimited {
+		t.Errorf("Rate limit not enforced: %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "c.example.com"}); err != nil {
+		t.Errorf("Rate limit did not reset: %v", err)
+	}
+
+	delete(allowed, "b.example.com")
+	if !m.Prune() {
+		t.Errorf("Prune did not report removal")
+	}
+	if names := m.Names(); len(names) != 1 || names[0] != "c.example.com" {
+		t.Errorf("Unexpected names after prune: %v", names)
+	}
+}
+
+func TestSlowIssuanceContinuesInBackground(t *testing.T) {
+	release := make(chan struct{})
+	issued := &tls.Certificate{}
+	m := New(
+		func(name string) bool { return true },
+		func(name string) (*tls.Certificate, error) {
+			<-release
+			return issued, nil
+		},
+	)
+	m.SetConfig(Config{Enabled: true, HandshakeWait: 20 * time.Millisecond})
+
+	hello := &tls.ClientHelloInfo{ServerName: "slow.example.com"}
+	start := time.Now()
+	if _, err := m.GetCertificate(hello); err != ErrPending {
+		t.Fatalf("Expected pending issuance, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Handshake was blocked for %s", d)
+	}
+
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for len(m.Names()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Background issuance did not finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if cert, err := m.GetCertificate(hello); err != nil || cert != issued {
+		t.Errorf("Issued certificate not served: %v", err)
+	}
+}
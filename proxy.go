@@ -8,71 +8,60 @@ import (
 	"strings"
 	"time"
 
-	"github.com/Luzifer/dockerproxy/sni"
+	"github.com/Luzifer/dockerproxy/balancer"
+	"github.com/Luzifer/dockerproxy/reverseproxy"
+	"github.com/Luzifer/dockerproxy/routing"
+	"github.com/Luzifer/dockerproxy/tunnel"
 	"github.com/Luzifer/go_helpers/accessLogger"
-	"github.com/elazarl/goproxy"
 
 	"github.com/Luzifer/dockerproxy/auth"
 	_ "github.com/Luzifer/dockerproxy/auth/basic"
 )
 
 type dockerProxy struct {
-	proxy *goproxy.ProxyHttpServer
-}
+	proxy *reverseproxy.Proxy
 
-type redirectRewriter struct{}
+	transport    http.RoundTripper
+	h2cTransport http.RoundTripper
+}
 
-func (r redirectRewriter) HandleResp(resp *http.Response, ctx *goproxy.ProxyCtx) bool {
-	if resp == nil {
-		return false
+// rewriteRedirects sends clients redirected to a domain with force_ssl
+// to its https location
+func rewriteRedirects(resp *http.Response) error {
+	if _, ok := resp.Header["Location"]; !ok {
+		return nil
 	}
-	_, ok := resp.Header["Location"]
-	return ok
-}
-func redirectRewriterRewrite(resp *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
-	loc, _ := resp.Location()
-	if host, ok := proxyConfiguration.Domains[loc.Host]; ok && host.ForceSSL && loc.Scheme == "http" {
+	loc, err := resp.Location()
+	if err != nil {
+		return nil
+	}
+	if host, _, ok := proxyConfiguration().domainFor(loc.Host); ok && host.ForceSSL && loc.Scheme == "http" {
 		loc.Scheme = "https"
 		resp.Header.Set("Location", loc.String())
 	}
-	return resp
+	return nil
 }
 
 func newDockerProxy() *dockerProxy {
-	proxy := goproxy.NewProxyHttpServer()
-	proxy.OnRequest().HandleConnect(goproxy.AlwaysReject)
-
-	proxy.OnResponse(redirectRewriter{}).DoFunc(redirectRewriterRewrite)
+	config := proxyConfiguration()
+	d := &dockerProxy{
+		transport:    reverseproxy.NewTransport(config.Transport),
+		h2cTransport: reverseproxy.NewH2CTransport(config.Transport),
+	}
 
-	// We are not really a proxy but act as a HTTP(s) server who delivers remote pages
-	proxy.NonproxyHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		proxy.ServeHTTP(w, req)
-	})
+	d.proxy = reverseproxy.New(roundTripperFunc(d.roundTripWithRetry))
+	d.proxy.UseRequest(applyRoute)
+	d.proxy.UseResponse(rewriteRedirects)
 
 	rand.Seed(time.Now().UnixNano())
 
-	return &dockerProxy{
-		proxy: proxy,
-	}
+	return d
 }
 
 func (d *dockerProxy) ServeHTTP(res http.ResponseWriter, r *http.Request) {
 	d.shieldOwnHosts(d.httpLog(d.proxy)).ServeHTTP(res, r)
 }
 
-func (d *dockerProxy) getCertificates() []sni.Certificates {
-	var certs []sni.Certificates
-	for _, domain := range proxyConfiguration.Domains {
-		if domain.SSL.Cert != "" {
-			certs = append(certs, sni.Certificates{
-				CertFile: domain.SSL.Cert,
-				KeyFile:  domain.SSL.Key,
-			})
-		}
-	}
-	return certs
-}
-
 func (d *dockerProxy) normalizeRemoteAddr(remoteAddress string) string {
 	idx := strings.LastIndex(remoteAddress, ":")
 	if idx != -1 {
@@ -89,32 +78,58 @@ func (d *dockerProxy) httpLog(handler http.Handler) http.Handler {
 		al := accessLogger.New(w)
 
 		start := time.Now()
-		handler.ServeHTTP(al, r)
-		duration := float64(time.Since(start)) / float64(time.Microsecond)
-
-		requestCount.WithLabelValues(
-			strings.ToLower(r.Method),
-			strconv.FormatInt(int64(al.StatusCode), 10),
-		).Inc()
-		responseSize.Observe(float64(al.Size))
-		requestDuration.Observe(duration)
-
-		log.Printf("%s %s \"%s %s\" %d %d \"%s\"",
-			d.normalizeRemoteAddr(r.RemoteAddr),
-			r.Host,
-			r.Method, r.URL.RequestURI(),
-			al.StatusCode, al.Size,
-			r.Header.Get("User-Agent"),
-		)
+		handler.ServeHTTP(loggedResponseWriter{al}, r)
+		d.logRequest(r, al.StatusCode, int64(al.Size), start)
 	})
 }
 
+// loggedResponseWriter exposes the wrapped writer to allow flushing
+// streamed responses through the access logger
+type loggedResponseWriter struct {
+	*accessLogger.AccessLogResponseWriter
+}
+
+func (l loggedResponseWriter) Unwrap() http.ResponseWriter {
+	return l.ResponseWriter
+}
+
+func (d *dockerProxy) logRequest(r *http.Request, statusCode int, size int64, start time.Time) {
+	duration := float64(time.Since(start)) / float64(time.Microsecond)
+
+	requestCount.WithLabelValues(
+		strings.ToLower(r.Method),
+		strconv.FormatInt(int64(statusCode), 10),
+	).Inc()
+	responseSize.Observe(float64(size))
+	requestDuration.Observe(duration)
+
+	log.Printf("%s %s \"%s %s\" %d %d \"%s\"",
+		d.normalizeRemoteAddr(r.RemoteAddr),
+		r.Host,
+		r.Method, r.URL.RequestURI(),
+		statusCode, size,
+		r.Header.Get("User-Agent"),
+	)
+}
+
 func (d *dockerProxy) shieldOwnHosts(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		slug := ""
+		var (
+			slug          string
+			balancerCFG   balancer.Config
+			balancerScope string
+			sticky        *balancer.Sticky
+			route         *routing.Match
+		)
+		config := proxyConfiguration()
 		// Host is defined and slug has been found
-		if host, ok := proxyConfiguration.Domains[req.Host]; ok {
-			slug = host.Slug
+		if host, match, ok := config.domainFor(req.Host); ok {
+			slug = match.Expand(host.Slug)
+			balancerCFG = host.Balancer
+			balancerScope = "domain:" + match.Key
+			if host.Sticky != nil {
+				sticky = balancer.NewSticky(*host.Sticky, stickySecret)
+			}
 
 			if host.ForceSSL && req.TLS == nil {
 				req.URL.Scheme = "https"
@@ -123,15 +138,15 @@ func (d *dockerProxy) shieldOwnHosts(handler http.Handler) http.Handler {
 				return
 			}
 
-			if proxyConfiguration.Domains[req.Host].Authentication.Type != "" {
-				authHandler, err := auth.GetAuthHandler(proxyConfiguration.Domains[req.Host].Authentication.Type)
+			if host.Authentication.Type != "" {
+				authHandler, err := auth.GetAuthHandler(host.Authentication.Type)
 				if err != nil {
 					http.Error(w, "Authentication system is misconfigured for this host.", http.StatusInternalServerError)
 					log.Printf("AuthSystemError: %s\n", err)
 					return
 				}
 
-				ok, err := authHandler(proxyConfiguration.Domains[req.Host].Authentication.Config, w, req)
+				ok, err := authHandler(host.Authentication.Config, w, req)
 				if err != nil {
 					http.Error(w, "Authentication system threw an error.", http.StatusInternalServerError)
 					log.Printf("AuthSystemError: %s\n", err)
@@ -143,16 +158,68 @@ func (d *dockerProxy) shieldOwnHosts(handler http.Handler) http.Handler {
 					return
 				}
 			}
+
+			// Routes take precedence over the slug of the domain
+			if m, ok := config.routes[match.Key].Match(req); ok {
+				m.Slug = match.Expand(m.Slug)
+				slug = m.Slug
+				route = &m
+			}
+			if match.Key != req.Host || route != nil {
+				// Hosts matched by a pattern or route may use different slugs
+				balancerScope += "|" + slug
+			}
 		}
-		// Host is a generic host
-		if strings.HasSuffix(req.Host, proxyConfiguration.Generic) {
-			slug = strings.Replace(req.Host, proxyConfiguration.Generic, "", -1)
+		// Host is a generic host, the slug from the host name overrides
+		// the one of a matching domain
+		if config.Generic != "" && strings.HasSuffix(req.Host, config.Generic) {
+			slug = strings.Replace(req.Host, config.Generic, "", -1)
+			balancerCFG = config.Balancers[slug]
+			balancerScope = "slug:" + slug
+			route = nil
 		}
 		// We found a valid slug before?
-		if target, ok := (*containers)[slug]; ok && slug != "" {
+		if backends := healthyBackends(slug); len(backends) > 0 && slug != "" {
+			candidates := balancerBackends(backends)
+
+			var (
+				backend balancer.Backend
+				found   bool
+			)
+			if sticky != nil {
+				backend, found = sticky.Lookup(req, candidates)
+			}
+			plan := &upstreamPlan{
+				slug:       slug,
+				candidates: candidates,
+				balancer:   getBalancer(balancerScope, balancerCFG),
+				tried:      make(map[string]bool),
+				protocols:  upstreamProtocols(slug, backends),
+				route:      route,
+			}
+			defer plan.finish()
+			if found {
+				plan.tried[backend.Address] = true
+			} else {
+				var ok bool
+				if backend, ok = plan.next(req); !ok {
+					http.Error(w, "This host is currently not available", 503)
+					return
+				}
+
+				if sticky != nil {
+					sticky.Set(w, req, backend)
+				}
+			}
+			req = withUpstreamPlan(req, plan)
+
 			req.URL.Scheme = "http"
-			req.URL.Host = target[rand.Intn(len(target))]
-			req.Header.Add("X-Forwarded-For", d.normalizeRemoteAddr(req.RemoteAddr))
+			req.URL.Host = backend.Address
+
+			if tunnel.IsUpgrade(req) {
+				d.serveUpgrade(w, req, plan, backend)
+				return
+			}
 
 			handler.ServeHTTP(w, req)
 		} else {
@@ -4,25 +4,81 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/Luzifer/dockerproxy/balancer"
+	"github.com/Luzifer/dockerproxy/discovery"
+	"github.com/Luzifer/dockerproxy/dns01"
+	"github.com/Luzifer/dockerproxy/health"
+	"github.com/Luzifer/dockerproxy/hostmatch"
+	"github.com/Luzifer/dockerproxy/ondemand"
+	"github.com/Luzifer/dockerproxy/reverseproxy"
+	"github.com/Luzifer/dockerproxy/routing"
+	"github.com/Luzifer/dockerproxy/sni"
+	"github.com/Luzifer/dockerproxy/tunnel"
 	"gopkg.in/yaml.v2"
 )
 
+// currentProxyConfig holds the active *proxyConfig, it is replaced as a
+// whole on reloads and label changes while requests are served
+var currentProxyConfig atomic.Value
+
+// proxyConfiguration returns the active configuration, it must not be
+// modified
+func proxyConfiguration() *proxyConfig {
+	return currentProxyConfig.Load().(*proxyConfig)
+}
+
+func setProxyConfiguration(p *proxyConfig) {
+	currentProxyConfig.Store(p)
+}
+
 type proxyConfig struct {
-	Domains       map[string]domainConfig `json:"domains" yaml:"domains"`
-	Generic       string                  `json:"generic" yaml:"generic"`
-	Docker        dockerConfig            `json:"docker" yaml:"docker"`
-	ListenHTTP    string                  `json:"listenHTTP" yaml:"listenHTTP"`
-	ListenHTTPS   string                  `json:"listenHTTPS" yaml:"listenHTTPS"`
-	ListenMetrics string                  `json:"listenMetrics" yaml:"listenMetrics"`
+	Domains          map[string]domainConfig      `json:"domains" yaml:"domains"`
+	Generic          string                       `json:"generic" yaml:"generic"`
+	GenericDNS       string                       `json:"generic_dns_provider" yaml:"generic_dns_provider"`
+	Docker           dockerConfig                 `json:"docker" yaml:"docker"`
+	HealthChecks     map[string]health.Config     `json:"healthchecks" yaml:"healthchecks"`
+	Balancers        map[string]balancer.Config   `json:"balancers" yaml:"balancers"`
+	Upstreams        map[string]upstreamConfig    `json:"upstreams" yaml:"upstreams"`
+	Retry            retryConfig                  `json:"retry" yaml:"retry"`
+	OutlierDetection health.OutlierConfig         `json:"outlier_detection" yaml:"outlier_detection"`
+	Upgrade          tunnel.Config                `json:"upgrade" yaml:"upgrade"`
+	Transport        reverseproxy.TransportConfig `json:"transport" yaml:"transport"`
+	ListenHTTP       string                       `json:"listenHTTP" yaml:"listenHTTP"`
+	ListenHTTPS      string                       `json:"listenHTTPS" yaml:"listenHTTPS"`
+	ListenMetrics    string                       `json:"listenMetrics" yaml:"listenMetrics"`
+	Admin            adminConfig                  `json:"admin" yaml:"admin"`
+	DNSProviders     map[string]dnsProvider       `json:"dns_providers" yaml:"dns_providers"`
+	OnDemandTLS      ondemand.Config              `json:"on_demand_tls" yaml:"on_demand_tls"`
+	TLS              sni.Policy                   `json:"tls" yaml:"tls"`
+
+	// routes holds the compiled routes per domain
+	routes map[string]*routing.Table
+	// hosts matches request hosts against the domains
+	hosts *hostmatch.Matcher
+	// configDomains and configHosts hold the domains of the config file
+	// without the ones configured by container labels
+	configDomains map[string]domainConfig
+	configHosts   *hostmatch.Matcher
+	// dockerHosts are the docker daemons to discover containers on
+	dockerHosts []discovery.Host
 }
 
 type domainConfig struct {
-	SSL            sslConfig  `json:"ssl,omitempty" yaml:"ssl,omitempty"`
-	Slug           string     `json:"slug" yaml:"slug"`
-	ForceSSL       bool       `json:"force_ssl" yaml:"force_ssl"`
-	Authentication domainAuth `json:"authentication,omitempty" yaml:"authentication,omitempty"`
-	UseLetsEncrypt bool       `json:"letsencrypt" yaml:"letsencrypt"`
+	SSL            sslConfig              `json:"ssl,omitempty" yaml:"ssl,omitempty"`
+	Slug           string                 `json:"slug" yaml:"slug"`
+	ForceSSL       bool                   `json:"force_ssl" yaml:"force_ssl"`
+	Authentication domainAuth             `json:"authentication,omitempty" yaml:"authentication,omitempty"`
+	UseLetsEncrypt bool                   `json:"letsencrypt" yaml:"letsencrypt"`
+	Balancer       balancer.Config        `json:"balancer,omitempty" yaml:"balancer,omitempty"`
+	Sticky         *balancer.StickyConfig `json:"sticky,omitempty" yaml:"sticky,omitempty"`
+	DNSProvider    string                 `json:"dns_provider,omitempty" yaml:"dns_provider,omitempty"`
+	Wildcard       bool                   `json:"wildcard,omitempty" yaml:"wildcard,omitempty"`
+	TLS            *sni.Policy            `json:"tls,omitempty" yaml:"tls,omitempty"`
+	Routes         []routing.Route        `json:"routes,omitempty" yaml:"routes,omitempty"`
 }
 
 type domainAuth struct {
@@ -33,16 +89,56 @@ type domainAuth struct {
 type sslConfig struct {
 	Cert string `json:"cert" yaml:"cert"`
 	Key  string `json:"key" yaml:"key"`
+	// Additional holds further certificates for the domain with other
+	// key types like an ECDSA certificate next to an RSA one
+	Additional []sslConfig `json:"additional,omitempty" yaml:"additional,omitempty"`
+}
+
+type adminConfig struct {
+	Token    string `json:"token" yaml:"token"`
+	Cert     string `json:"cert" yaml:"cert"`
+	Key      string `json:"key" yaml:"key"`
+	ClientCA string `json:"client_ca" yaml:"client_ca"`
+}
+
+type dnsProvider struct {
+	Type               string        `json:"type" yaml:"type"`
+	Config             interface{}   `json:"config" yaml:"config"`
+	PropagationTimeout time.Duration `json:"propagation_timeout" yaml:"propagation_timeout"`
+}
+
+type retryConfig struct {
+	Attempts    int   `json:"attempts" yaml:"attempts"`
+	StatusCodes []int `json:"status_codes" yaml:"status_codes"`
 }
 
 type dockerConfig struct {
-	Hosts map[string]string `json:"hosts" yaml:"hosts"`
-	Port  int               `json:"port" yaml:"port"`
+	Hosts map[string]discovery.HostConfig `json:"hosts" yaml:"hosts"`
+	Port  int                             `json:"port" yaml:"port"`
+	// StaleAfter removes the containers of an unreachable docker daemon
+	// after this duration, zero keeps them
+	StaleAfter time.Duration `json:"stale_after" yaml:"stale_after"`
+	// Timeout limits every call to the docker API of a daemon
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+	// LabelsOnly only lists containers having a slug label and skips
+	// the ROUTER_SLUG environment fallback
+	LabelsOnly bool `json:"labels_only" yaml:"labels_only"`
+	// Network addresses containers by their IP in this docker network
+	// instead of their published ports
+	Network string `json:"network" yaml:"network"`
 }
 
 func newProxyConfig(configFile string) (*proxyConfig, error) {
 	tmp := proxyConfig{
 		ListenMetrics: "127.0.0.1:9000",
+		Docker: dockerConfig{
+			StaleAfter: 10 * time.Minute,
+			Timeout:    10 * time.Second,
+		},
+		Retry: retryConfig{
+			Attempts:    3,
+			StatusCodes: []int{502, 503, 504},
+		},
 	}
 
 	configBody, err := ioutil.ReadFile(configFile)
@@ -58,5 +154,147 @@ func newProxyConfig(configFile string) (*proxyConfig, error) {
 		}
 	}
 
+	if tmp.Admin.ClientCA != "" && (tmp.Admin.Cert == "" || tmp.Admin.Key == "") {
+		return nil, fmt.Errorf("Admin API client certificate authentication requires cert and key")
+	}
+
+	if err := tmp.TLS.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid TLS policy: %s", err)
+	}
+
+	if tmp.dockerHosts, err = discovery.ParseHostsFromEnv(tmp.Docker.Hosts, tmp.Docker.Port); err != nil {
+		return nil, err
+	}
+
+	domains := []string{}
+	for domain := range tmp.Domains {
+		domains = append(domains, domain)
+	}
+	if tmp.hosts, err = hostmatch.New(domains); err != nil {
+		return nil, err
+	}
+
+	tmp.routes = make(map[string]*routing.Table)
+	for domain, domainCFG := range tmp.Domains {
+		switch hostmatch.Kind(domain) {
+		case hostmatch.KindWildcard:
+			if domainCFG.Wildcard {
+				return nil, fmt.Errorf("Wildcard domain %s cannot request an additional wildcard certificate", domain)
+			}
+			if domainCFG.UseLetsEncrypt && domainCFG.DNSProvider == "" {
+				return nil, fmt.Errorf("Wildcard certificate for domain %s requires a dns_provider", domain)
+			}
+		case hostmatch.KindRegex:
+			if domainCFG.UseLetsEncrypt || domainCFG.Wildcard || domainCFG.TLS != nil {
+				return nil, fmt.Errorf("Domain expression %s cannot use letsencrypt, wildcard or tls", domain)
+			}
+		}
+
+		if _, err := balancer.New(domainCFG.Balancer); err != nil {
+			return nil, fmt.Errorf("Invalid balancer for domain %s: %s", domain, err)
+		}
+		if len(domainCFG.Routes) > 0 {
+			table, err := routing.New(domainCFG.Routes)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid routes for domain %s: %s", domain, err)
+			}
+			tmp.routes[domain] = table
+		}
+		if domainCFG.TLS != nil {
+			if err := tmp.TLS.Merge(*domainCFG.TLS).Validate(); err != nil {
+				return nil, fmt.Errorf("Invalid TLS policy for domain %s: %s", domain, err)
+			}
+		}
+	}
+	for name, provider := range tmp.DNSProviders {
+		if _, err := dns01.New(provider.Type, provider.Config); err != nil {
+			return nil, fmt.Errorf("Invalid DNS provider %s: %s", name, err)
+		}
+	}
+	for domain, domainCFG := range tmp.Domains {
+		if _, ok := tmp.DNSProviders[domainCFG.DNSProvider]; domainCFG.DNSProvider != "" && !ok {
+			return nil, fmt.Errorf("Domain %s uses undefined DNS provider %s", domain, domainCFG.DNSProvider)
+		}
+		if domainCFG.Wildcard && (!domainCFG.UseLetsEncrypt || domainCFG.DNSProvider == "") {
+			return nil, fmt.Errorf("Wildcard certificate for domain %s requires letsencrypt and a dns_provider", domain)
+		}
+	}
+	if tmp.OnDemandTLS.Enabled && strings.Trim(tmp.Generic, ".") == "" {
+		return nil, fmt.Errorf("on_demand_tls requires the generic suffix to be set")
+	}
+	if tmp.GenericDNS != "" {
+		if _, ok := tmp.DNSProviders[tmp.GenericDNS]; !ok {
+			return nil, fmt.Errorf("Generic suffix uses undefined DNS provider %s", tmp.GenericDNS)
+		}
+		if strings.Trim(tmp.Generic, ".") == "" {
+			return nil, fmt.Errorf("generic_dns_provider requires the generic suffix to be set")
+		}
+	}
+
+	for slug, upstreamCFG := range tmp.Upstreams {
+		if err := validateUpstreamProtocol(upstreamCFG.Protocol); err != nil {
+			return nil, fmt.Errorf("Invalid upstream for slug %s: %s", slug, err)
+		}
+	}
+
+	for slug, balancerCFG := range tmp.Balancers {
+		if _, err := balancer.New(balancerCFG); err != nil {
+			return nil, fmt.Errorf("Invalid balancer for slug %s: %s", slug, err)
+		}
+	}
+
+	tmp.configDomains = tmp.Domains
+	tmp.configHosts = tmp.hosts
+
 	return &tmp, nil
 }
+
+// withLabelDomains returns a copy of the config with the domains from
+// container labels added. Domains of the config file take precedence,
+// label domains matched by any of them are returned as conflicts.
+func (p proxyConfig) withLabelDomains(labelDomains map[string]domainConfig) (*proxyConfig, []error) {
+	var conflicts []error
+
+	p.Domains = make(map[string]domainConfig, len(p.configDomains)+len(labelDomains))
+	keys := []string{}
+	for domain, domainCFG := range p.configDomains {
+		p.Domains[domain] = domainCFG
+		keys = append(keys, domain)
+	}
+	for domain, domainCFG := range labelDomains {
+		if match, ok := p.configHosts.Match(domain); ok {
+			conflicts = append(conflicts, fmt.Errorf("Domain %s is already configured as %s in the config file", domain, match.Key))
+			continue
+		}
+		p.Domains[domain] = domainCFG
+		keys = append(keys, domain)
+	}
+
+	hosts, err := hostmatch.New(keys)
+	if err != nil {
+		p.Domains, p.hosts = p.configDomains, p.configHosts
+		return &p, append(conflicts, err)
+	}
+	p.hosts = hosts
+	return &p, conflicts
+}
+
+// domainFor returns the configuration of the domain matching the host
+func (p proxyConfig) domainFor(host string) (domainConfig, hostmatch.Match, bool) {
+	match, ok := p.hosts.Match(host)
+	if !ok {
+		return domainConfig{}, match, false
+	}
+	return p.Domains[match.Key], match, true
+}
+
+// tlsPolicies returns the TLS policy overrides of the domains
+func (p proxyConfig) tlsPolicies() map[string]sni.Policy {
+	policies := make(map[string]sni.Policy)
+	for domain, domainCFG := range p.Domains {
+		if domainCFG.TLS != nil {
+			policies[domain] = *domainCFG.TLS
+		}
+	}
+	return policies
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Luzifer/dockerproxy/discovery"
+)
+
+func TestShieldOwnHostsWithoutBackends(t *testing.T) {
+	loadTestConfig(t, `---
+domains:
+  app.example.com:
+    slug: app
+    sticky:
+      cookie: backend
+`)
+
+	h := (&dockerProxy{}).shieldOwnHosts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Request without backends was proxied to %q", r.URL.Host)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://app.example.com/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+	if c := w.Header().Get("Set-Cookie"); c != "" {
+		t.Errorf("Affinity cookie set without backend: %s", c)
+	}
+}
+
+func TestShieldOwnHostsGenericOverridesDomain(t *testing.T) {
+	loadTestConfig(t, `---
+generic: .gen.example.com
+domains:
+  app.gen.example.com:
+    slug: other
+`)
+	containers.Set("test", "generic", discovery.Backend{Slug: "app", Address: "10.0.0.1:80"})
+	containers.Set("test", "domain", discovery.Backend{Slug: "other", Address: "10.0.0.2:80"})
+	defer containers.RemoveHost("test")
+
+	var upstream string
+	h := (&dockerProxy{}).shieldOwnHosts(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.URL.Host
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://app.gen.example.com/", nil))
+
+	if upstream != "10.0.0.1:80" {
+		t.Errorf("Expected request to the container of the generic slug, got %q", upstream)
+	}
+}
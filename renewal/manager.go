@@ -0,0 +1,334 @@
+// Package renewal keeps ACME certificates up to date in the background
+package renewal // import "github.com/Luzifer/dockerproxy/renewal"
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/sni"
+)
+
+// Fetcher obtains the certificates valid for all names, one for every
+// configured key type
+type Fetcher func(names []string) ([]sni.Certificates, error)
+
+// FailureFunc is called for every failed renewal attempt
+type FailureFunc func(names []string, err error)
+
+// Config controls the renewal schedule
+type Config struct {
+	// RenewBefore is the time before expiry a certificate is renewed
+	RenewBefore time.Duration
+	// MinBackoff is the delay after the first failed attempt which is
+	// doubled for every further failure up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction every delay is randomly varied by
+	Jitter float64
+}
+
+// WithDefaults returns a copy of the config with unset values defaulted
+func (c Config) WithDefaults() Config {
+	if c.RenewBefore == 0 {
+		c.RenewBefore = 30 * 24 * time.Hour
+	}
+	if c.MinBackoff == 0 {
+		c.MinBackoff = time.Minute
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 12 * time.Hour
+	}
+	if c.Jitter == 0 {
+		c.Jitter = 0.1
+	}
+	return c
+}
+
+// Status describes the renewal state of one certificate
+type Status struct {
+	Names       []string  `json:"names"`
+	NotAfter    time.Time `json:"not_after"`
+	LastAttempt time.Time `json:"last_attempt"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+type entry struct {
+	names []string
+	certs []sni.Certificates
+
+	notAfter    time.Time
+	lastAttempt time.Time
+	nextAttempt time.Time
+	failures    int
+	lastError   string
+}
+
+// Manager renews a set of certificates in the background. Certificates
+// are renewed RenewBefore their expiry, failed attempts are retried with
+// exponential backoff while the previous certificate is kept.
+type Manager struct {
+	sync.Mutex
+	cfg       Config
+	fetch     Fetcher
+	onUpdate  func()
+	onFailure FailureFunc
+
+	entries map[string]*entry
+	wake    chan struct{}
+	stop    chan struct{}
+
+	now    func() time.Time
+	random func() float64
+}
+
+// NewManager creates a Manager obtaining certificates using fetch.
+// onUpdate is called after a certificate changed, onFailure after a
+// failed attempt, both may be nil.
+func NewManager(cfg Config, fetch Fetcher, onUpdate func(), onFailure FailureFunc) *Manager {
+	if onUpdate == nil {
+		onUpdate = func() {}
+	}
+	if onFailure == nil {
+		onFailure = func([]string, error) {}
+	}
+
+	return &Manager{
+		cfg:       cfg.WithDefaults(),
+		fetch:     fetch,
+		onUpdate:  onUpdate,
+		onFailure: onFailure,
+		entries:   make(map[string]*entry),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		now:       time.Now,
+		random:    rand.Float64,
+	}
+}
+
+func key(names []string) string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+// Sync sets the certificates to manage, each given by its names. New
+// certificates are fetched immediately, removed ones are dropped.
+func (m *Manager) Sync(groups [][]string) {
+	m.Lock()
+	defer m.Unlock()
+
+	wanted := make(map[string][]string)
+	for _, names := range groups {
+		wanted[key(names)] = names
+	}
+
+	changed := false
+	for k := range m.entries {
+		if _, ok := wanted[k]; !ok {
+			delete(m.entries, k)
+			changed = true
+		}
+	}
+	for k, names := range wanted {
+		if _, ok := m.entries[k]; !ok {
+			m.entries[k] = &entry{names: names, nextAttempt: m.now()}
+		}
+	}
+
+	m.notify()
+	if changed {
+		go m.onUpdate()
+	}
+}
+
+// Trigger schedules all certificates for immediate renewal
+func (m *Manager) Trigger() {
+	m.Lock()
+	defer m.Unlock()
+	for _, e := range m.entries {
+		e.nextAttempt = m.now()
+	}
+	m.notify()
+}
+
+// Certificates returns the currently valid certificates
+func (m *Manager) Certificates() []sni.Certificates {
+	m.Lock()
+	defer m.Unlock()
+
+	keys := []string{}
+	for k, e := range m.entries {
+		if e.certs != nil {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := []sni.Certificates{}
+	for _, k := range keys {
+		result = append(result, m.entries[k].certs...)
+	}
+	return result
+}
+
+// Status returns the renewal state of all managed certificates
+func (m *Manager) Status() []Status {
+	m.Lock()
+	defer m.Unlock()
+
+	result := []Status{}
+	for _, e := range m.entries {
+		result = append(result, Status{
+			Names:       e.names,
+			NotAfter:    e.notAfter,
+			LastAttempt: e.lastAttempt,
+			NextAttempt: e.nextAttempt,
+			Failures:    e.failures,
+			LastError:   e.lastError,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return key(result[i].Names) < key(result[j].Names) })
+	return result
+}
+
+// Run renews certificates when they are due until Stop is called
+func (m *Manager) Run() {
+	for {
+		due, wait := m.due()
+		for _, k := range due {
+			m.renew(k)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case <-m.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Stop ends the Run loop
+func (m *Manager) Stop() {
+	close(m.stop)
+}
+
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due returns the certificates to renew now and the time until the
+// next one is due
+func (m *Manager) due() ([]string, time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := m.now()
+	wait := m.cfg.MaxBackoff
+	due := []string{}
+	for k, e := range m.entries {
+		if d := e.nextAttempt.Sub(now); d <= 0 {
+			due = append(due, k)
+		} else if d < wait {
+			wait = d
+		}
+	}
+	sort.Strings(due)
+	return due, wait
+}
+
+func (m *Manager) renew(k string) {
+	m.Lock()
+	e, ok := m.entries[k]
+	if !ok {
+		m.Unlock()
+		return
+	}
+	names := e.names
+	m.Unlock()
+
+	certs, err := m.fetch(names)
+
+	m.Lock()
+	if m.entries[k] != e {
+		// Removed while fetching
+		m.Unlock()
+		return
+	}
+
+	now := m.now()
+	e.lastAttempt = now
+	if err != nil {
+		e.failures++
+		e.lastError = err.Error()
+		e.nextAttempt = now.Add(m.jitter(m.backoff(e.failures)))
+		m.Unlock()
+
+		m.onFailure(names, err)
+		return
+	}
+
+	e.certs = certs
+	e.failures = 0
+	e.lastError = ""
+	e.notAfter = earliestExpiry(certs, e.notAfter)
+	e.nextAttempt = m.renewAt(e.notAfter)
+	if min := now.Add(m.cfg.MinBackoff); e.nextAttempt.Before(min) {
+		// Do not hammer the CA if it hands out short-lived certificates
+		e.nextAttempt = min
+	}
+	m.Unlock()
+
+	m.onUpdate()
+}
+
+// earliestExpiry returns the first expiry of the certificates, the
+// renewal of all of them is due when the first one needs to be renewed
+func earliestExpiry(certs []sni.Certificates, fallback time.Time) time.Time {
+	var notAfter time.Time
+	for _, c := range certs {
+		if c.Certificate != nil && (notAfter.IsZero() || c.Certificate.NotAfter.Before(notAfter)) {
+			notAfter = c.Certificate.NotAfter
+		}
+	}
+	if notAfter.IsZero() {
+		return fallback
+	}
+	return notAfter
+}
+
+func (m *Manager) backoff(failures int) time.Duration {
+	d := m.cfg.MinBackoff
+	for i := 1; i < failures && d < m.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > m.cfg.MaxBackoff {
+		d = m.cfg.MaxBackoff
+	}
+	return d
+}
+
+// renewAt returns the time to renew a certificate expiring at notAfter.
+// The jitter only delays the renewal as the certificate is not renewed
+// before it is less than RenewBefore from its expiry.
+func (m *Manager) renewAt(notAfter time.Time) time.Time {
+	return notAfter.Add(-m.cfg.RenewBefore).Add(time.Duration(float64(m.cfg.RenewBefore) * m.cfg.Jitter * m.random()))
+}
+
+func (m *Manager) jitter(d time.Duration) time.Duration {
+	return d + time.Duration(float64(d)*m.cfg.Jitter*(2*m.random()-1))
+}
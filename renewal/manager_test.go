@@ -0,0 +1,134 @@
+package renewal
+
+import (
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/sni"
+)
+
+type fakeFetcher struct {
+	sync.Mutex
+	fail     bool
+	calls    int
+	notAfter time.Time
+}
+
+func (f *fakeFetcher) fetch(names []string) ([]sni.Certificates, error) {
+	f.Lock()
+	defer f.Unlock()
+	f.calls++
+	if f.fail {
+		return nil, errors.New("CA unavailable")
+	}
+	// RSA and ECDSA certificate, the ECDSA one expiring a day later
+	return []sni.Certificates{
+		{Certificate: &x509.Certificate{DNSNames: names, NotAfter: f.notAfter}},
+		{Certificate: &x509.Certificate{DNSNames: names, NotAfter: f.notAfter.Add(24 * time.Hour)}},
+	}, nil
+}
+
+func (f *fakeFetcher) set(fail bool, notAfter time.Time) {
+	f.Lock()
+	defer f.Unlock()
+	f.fail, f.notAfter = fail, notAfter
+}
+
+func TestBackoff(t *testing.T) {
+	m := NewManager(Config{MinBackoff: time.Minute, MaxBackoff: 10 * time.Minute}, nil, nil, nil)
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute}
+	for i, e := range expected {
+		if d := m.backoff(i + 1); d != e {
+			t.Errorf("Backoff after %d failures: %s != %s", i+1, d, e)
+		}
+	}
+
+	m.random = func() float64 { return 1 }
+	if d := m.jitter(time.Minute); d != 66*time.Second {
+		t.Errorf("Unexpected jitter: %s", d)
+	}
+}
+
+func TestRenewAtNotBeforeThreshold(t *testing.T) {
+	m := NewManager(Config{RenewBefore: 30 * 24 * time.Hour}, nil, nil, nil)
+	notAfter := time.Now().Add(90 * 24 * time.Hour)
+	threshold := notAfter.Add(-30 * 24 * time.Hour)
+
+	for _, r := range []float64{0, 0.5, 0.999} {
+		m.random = func() float64 { return r }
+		at := m.renewAt(notAfter)
+		if at.Before(threshold) {
+			t.Errorf("Renewal scheduled at %s before threshold %s with random %f", at, threshold, r)
+		}
+		if at.After(threshold.Add(3 * 24 * time.Hour)) {
+			t.Errorf("Renewal scheduled at %s later than the jitter allows", at)
+		}
+	}
+}
+
+func TestRenewKeepsCertificateOnFailure(t *testing.T) {
+	now := time.Now()
+	first := now.Add(90 * 24 * time.Hour)
+	f := &fakeFetcher{notAfter: first}
+
+	updates := make(chan struct{}, 10)
+	failures := make(chan error, 10)
+	m := NewManager(
+		Config{MinBackoff: 20 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+		f.fetch,
+		func() { updates <- struct{}{} },
+		func(names []string, err error) { failures <- err },
+	)
+	go m.Run()
+	defer m.Stop()
+
+	m.Sync([][]string{{"www.example.com", "example.com"}})
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatalf("Certificate was not fetched")
+	}
+
+	st := m.Status()
+	if len(st) != 1 || !st[0].NotAfter.Equal(first) || st[0].NextAttempt.Before(now.Add(55*24*time.Hour)) {
+		t.Fatalf("Unexpected status: %#v", st)
+	}
+
+	// Renewal fails: the previous certificate must be kept and retried
+	f.set(true, time.Time{})
+	m.Trigger()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-failures:
+		case <-time.After(time.Second):
+			t.Fatalf("Failed renewal was not retried")
+		}
+	}
+
+	if certs := m.Certificates(); len(certs) != 2 || !certs[0].Certificate.NotAfter.Equal(first) {
+		t.Errorf("Certificate was dropped after failed renewal: %v", certs)
+	}
+	if st := m.Status(); st[0].Failures < 2 || st[0].LastError != "CA unavailable" {
+		t.Errorf("Failures not recorded: %#v", st[0])
+	}
+
+	// Recovery resets the failure state
+	f.set(false, now.Add(80*24*time.Hour))
+	select {
+	case <-updates:
+	case <-time.After(time.Second):
+		t.Fatalf("Renewal did not recover")
+	}
+	if st := m.Status(); st[0].Failures != 0 || st[0].LastError != "" {
+		t.Errorf("Failure state not reset: %#v", st[0])
+	}
+
+	// Removing the certificate drops it
+	m.Sync(nil)
+	if certs := m.Certificates(); len(certs) != 0 {
+		t.Errorf("Removed certificate still served")
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/Luzifer/dockerproxy/balancer"
+	"github.com/Luzifer/dockerproxy/routing"
+)
+
+type upstreamPlanKey struct{}
+
+// upstreamPlan carries the backend selection of a request into the
+// round trip to be able to choose another backend for a retry
+type upstreamPlan struct {
+	slug       string
+	candidates []balancer.Backend
+	balancer   balancer.Balancer
+	tried      map[string]bool
+	done       []func()
+	// protocols holds the upstream protocol per backend address
+	protocols map[string]string
+	// route is the route of the domain matching the request
+	route *routing.Match
+}
+
+func withUpstreamPlan(req *http.Request, plan *upstreamPlan) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), upstreamPlanKey{}, plan))
+}
+
+// next picks a backend not yet tried for this request
+func (u *upstreamPlan) next(req *http.Request) (balancer.Backend, bool) {
+	remaining := []balancer.Backend{}
+	for _, c := range u.candidates {
+		if !u.tried[c.Address] {
+			remaining = append(remaining, c)
+		}
+	}
+	if len(remaining) == 0 {
+		return balancer.Backend{}, false
+	}
+
+	backend, done := u.balancer.Pick(req, remaining)
+	u.tried[backend.Address] = true
+	u.done = append(u.done, done)
+	return backend, true
+}
+
+// finish releases all backends picked for the request
+func (u *upstreamPlan) finish() {
+	for _, done := range u.done {
+		done()
+	}
+}
+
+// roundTripperFunc allows to use a function as http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// transportFor returns the RoundTripper speaking the upstream protocol
+func (d *dockerProxy) transportFor(protocol string) http.RoundTripper {
+	if protocol == upstreamH2C {
+		return d.h2cTransport
+	}
+	return d.transport
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		return false
+	}
+
+	// Without a body we cannot replay the request
+	return req.ContentLength == 0 && (req.Body == nil || req.Body == http.NoBody)
+}
+
+func isRetryableStatus(code int) bool {
+	for _, c := range proxyConfiguration().Retry.StatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// roundTripWithRetry sends the request to the backend chosen in
+// shieldOwnHosts and retries idempotent requests on other backends of
+// the slug if the backend is not reachable or answers with a retryable
+// status code
+func (d *dockerProxy) roundTripWithRetry(req *http.Request) (*http.Response, error) {
+	plan, ok := req.Context().Value(upstreamPlanKey{}).(*upstreamPlan)
+	if !ok {
+		return d.transport.RoundTrip(req)
+	}
+
+	config := proxyConfiguration()
+	outlierCFG := config.OutlierDetection.WithDefaults()
+	canRetry := isIdempotent(req)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := d.transportFor(plan.protocols[req.URL.Host]).RoundTrip(req)
+		if err != nil {
+			if outliers.ReportFailure(plan.slug, req.URL.Host, outlierCFG) {
+				log.Printf("[Outlier] Ejecting %s (%s) for %s", req.URL.Host, plan.slug, outlierCFG.EjectionTime)
+				backendEjections.WithLabelValues(plan.slug).Inc()
+			}
+		} else {
+			outliers.ReportSuccess(plan.slug, req.URL.Host)
+		}
+
+		if !canRetry || attempt >= config.Retry.Attempts || (err == nil && !isRetryableStatus(resp.StatusCode)) {
+			return resp, err
+		}
+
+		backend, ok := plan.next(req)
+		if !ok {
+			return resp, err
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		backendRetries.WithLabelValues(plan.slug).Inc()
+		req = req.Clone(req.Context())
+		req.URL.Host = backend.Address
+	}
+}
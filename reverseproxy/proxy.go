@@ -0,0 +1,233 @@
+package reverseproxy
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RequestMiddleware modifies the request sent to the backend, an error
+// aborts the request
+type RequestMiddleware func(out *http.Request) error
+
+// ResponseMiddleware modifies the backend response before it is sent to
+// the client, an error discards the response
+type ResponseMiddleware func(resp *http.Response) error
+
+// Proxy sends requests to the backend set in their URL and passes the
+// responses to the client
+type Proxy struct {
+	// Transport sends the requests to the backends, defaults to a
+	// transport created by NewTransport
+	Transport http.RoundTripper
+	// RequestMiddlewares and ResponseMiddlewares are applied in order
+	RequestMiddlewares  []RequestMiddleware
+	ResponseMiddlewares []ResponseMiddleware
+	// ErrorHandler answers requests not reaching the backend, defaults
+	// to a 502 Bad Gateway response
+	ErrorHandler func(w http.ResponseWriter, req *http.Request, err error)
+}
+
+// New creates a Proxy using the transport
+func New(transport http.RoundTripper) *Proxy {
+	return &Proxy{Transport: transport}
+}
+
+// UseRequest appends middlewares to the request chain
+func (p *Proxy) UseRequest(m ...RequestMiddleware) {
+	p.RequestMiddlewares = append(p.RequestMiddlewares, m...)
+}
+
+// UseResponse appends middlewares to the response chain
+func (p *Proxy) UseResponse(m ...ResponseMiddleware) {
+	p.ResponseMiddlewares = append(p.ResponseMiddlewares, m...)
+}
+
+// hopHeaders are only valid for a single connection and are never
+// passed on (RFC 7230, section 6.1)
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RemoveHopHeaders deletes all hop-by-hop headers including the ones
+// named in the Connection header
+func RemoveHopHeaders(h http.Header) {
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// AddForwardedHeaders appends the client information of the incoming
+// request to the X-Forwarded-For and Forwarded (RFC 7239) headers of the
+// outgoing request. X-Forwarded-Host and X-Forwarded-Proto are always
+// replaced as the values sent by the client cannot be trusted.
+func AddForwardedHeaders(out, in *http.Request) {
+	clientIP := in.RemoteAddr
+	if host, _, err := net.SplitHostPort(in.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	proto := "http"
+	if in.TLS != nil {
+		proto = "https"
+	}
+
+	if prior := out.Header["X-Forwarded-For"]; len(prior) > 0 {
+		out.Header.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+clientIP)
+	} else {
+		out.Header.Set("X-Forwarded-For", clientIP)
+	}
+	out.Header.Set("X-Forwarded-Host", in.Host)
+	out.Header.Set("X-Forwarded-Proto", proto)
+
+	forwardedFor := clientIP
+	if strings.Contains(clientIP, ":") {
+		// IPv6 addresses need to be quoted and bracketed
+		forwardedFor = `"[` + clientIP + `]"`
+	}
+	element := "for=" + forwardedFor + ";host=" + quoteForwarded(in.Host) + ";proto=" + proto
+	if prior := out.Header.Get("Forwarded"); prior != "" {
+		element = prior + ", " + element
+	}
+	out.Header.Set("Forwarded", element)
+}
+
+func quoteForwarded(value string) string {
+	if strings.ContainsAny(value, ":[]\" ,;=") {
+		return `"` + strings.Replace(value, `"`, `\"`, -1) + `"`
+	}
+	return value
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodConnect {
+		http.Error(w, "CONNECT is not supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	out := req.Clone(req.Context())
+	if req.ContentLength == 0 {
+		// Allows the transport to retry the request
+		out.Body = nil
+	}
+	out.RequestURI = ""
+	out.Close = false
+
+	// gRPC requires the "TE: trailers" header to be passed on
+	keepTE := headerContainsToken(req.Header, "Te", "trailers")
+	RemoveHopHeaders(out.Header)
+	if keepTE {
+		out.Header.Set("Te", "trailers")
+	}
+	AddForwardedHeaders(out, req)
+	if _, ok := out.Header["User-Agent"]; !ok {
+		// Prevent the transport from setting its default user agent
+		out.Header.Set("User-Agent", "")
+	}
+
+	for _, m := range p.RequestMiddlewares {
+		if err := m(out); err != nil {
+			p.handleError(w, req, err)
+			return
+		}
+	}
+
+	transport := p.Transport
+	if transport == nil {
+		transport = defaultTransport
+	}
+	resp, err := transport.RoundTrip(out)
+	if err != nil {
+		p.handleError(w, req, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	RemoveHopHeaders(resp.Header)
+	for _, m := range p.ResponseMiddlewares {
+		if err := m(resp); err != nil {
+			p.handleError(w, req, err)
+			return
+		}
+	}
+
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	dst := io.Writer(w)
+	if isStreaming(resp) {
+		// The controller finds the flusher of wrapped writers as well
+		rc := http.NewResponseController(w)
+		if rc.Flush() == nil {
+			dst = flushWriter{w: w, rc: rc}
+		}
+	}
+	if _, err := io.Copy(dst, resp.Body); err != nil && req.Context().Err() == nil {
+		log.Printf("[Proxy] Unable to copy response body from %s: %s", req.URL.Host, err)
+	}
+
+	// Trailers are only known after the body has been read
+	for key, values := range resp.Trailer {
+		w.Header()[http.TrailerPrefix+key] = values
+	}
+}
+
+func (p *Proxy) handleError(w http.ResponseWriter, req *http.Request, err error) {
+	if p.ErrorHandler != nil {
+		p.ErrorHandler(w, req, err)
+		return
+	}
+	log.Printf("[Proxy] Request to %s failed: %s", req.URL.Host, err)
+	http.Error(w, "Bad Gateway", http.StatusBadGateway)
+}
+
+// isStreaming reports whether the response needs to be sent to the
+// client without buffering like server-sent events or gRPC streams
+func isStreaming(resp *http.Response) bool {
+	if resp.ContentLength == -1 {
+		return true
+	}
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
+}
+
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		err = f.rc.Flush()
+	}
+	return n, err
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
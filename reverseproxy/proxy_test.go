@@ -0,0 +1,177 @@
+package reverseproxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyTo creates a server proxying all requests to the backend
+func proxyTo(backend *httptest.Server, p *Proxy) *httptest.Server {
+	target, _ := url.Parse(backend.URL)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		p.ServeHTTP(w, r)
+	}))
+}
+
+func TestProxyHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"Connection", "X-Hop", "Proxy-Authorization", "Keep-Alive"} {
+			if r.Header.Get(h) != "" {
+				t.Errorf("Hop-by-hop header %s was passed to the backend", h)
+			}
+		}
+		if r.Header.Get("Te") != "trailers" {
+			t.Errorf("TE: trailers was not passed to the backend")
+		}
+		if r.Host != "app.example.com" {
+			t.Errorf("Host header was not kept: %s", r.Host)
+		}
+		if r.Header.Get("X-Forwarded-For") != "10.0.0.1, 127.0.0.1" {
+			t.Errorf("Unexpected X-Forwarded-For: %s", r.Header.Get("X-Forwarded-For"))
+		}
+		if r.Header.Get("X-Forwarded-Host") != "app.example.com" || r.Header.Get("X-Forwarded-Proto") != "http" {
+			t.Errorf("Unexpected X-Forwarded headers: %v", r.Header)
+		}
+		if r.Header.Get("Forwarded") != "for=127.0.0.1;host=app.example.com;proto=http" {
+			t.Errorf("Unexpected Forwarded header: %s", r.Header.Get("Forwarded"))
+		}
+		if r.Header.Get("X-Middleware") != "request" {
+			t.Errorf("Request middleware was not applied")
+		}
+
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	p := New(nil)
+	p.UseRequest(func(out *http.Request) error {
+		out.Header.Set("X-Middleware", "request")
+		return nil
+	})
+	p.UseResponse(func(resp *http.Response) error {
+		resp.Header.Set("X-Middleware", "response")
+		return nil
+	})
+	srv := proxyTo(backend, p)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Host = "app.example.com"
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "secret")
+	req.Header.Set("Te", "trailers")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	// Spoofed by the client, must be replaced
+	req.Header.Set("X-Forwarded-Host", "admin.example.com")
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Backend-Hop") != "" {
+		t.Errorf("Hop-by-hop header was passed to the client")
+	}
+	if resp.Header.Get("X-Middleware") != "response" {
+		t.Errorf("Response middleware was not applied")
+	}
+}
+
+func TestProxyStreamsWithTrailers(t *testing.T) {
+	next := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		fmt.Fprintln(w, "first")
+		w.(http.Flusher).Flush()
+		<-next
+		fmt.Fprintln(w, "second")
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer backend.Close()
+
+	srv := proxyTo(backend, New(nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	// The first line must arrive before the backend finished the response
+	br := bufio.NewReader(resp.Body)
+	lines := make(chan string)
+	go func() {
+		line, _ := br.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "first\n" {
+			t.Errorf("Unexpected line %q", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Response was not streamed")
+	}
+	close(next)
+
+	if line, _ := br.ReadString('\n'); line != "second\n" {
+		t.Errorf("Unexpected line %q", line)
+	}
+	br.ReadString('\n')
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Trailer was not passed: %v", resp.Trailer)
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	backend.Close()
+
+	p := New(nil)
+	srv := proxyTo(backend, p)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Unreachable backend: expected 502, got %d", resp.StatusCode)
+	}
+
+	p.UseRequest(func(out *http.Request) error { return errors.New("denied") })
+	p.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+	}
+	resp, err = http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Failing middleware: expected 403, got %d", resp.StatusCode)
+	}
+
+	req, _ := http.NewRequest("CONNECT", srv.URL, nil)
+	req.URL.Opaque = strings.TrimPrefix(srv.URL, "http://")
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("CONNECT: expected 405, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package reverseproxy
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig describes the connection handling towards the backends
+type TransportConfig struct {
+	// DialTimeout limits establishing a connection to a backend
+	DialTimeout time.Duration `json:"dial_timeout" yaml:"dial_timeout"`
+	// ResponseHeaderTimeout limits waiting for the response headers after
+	// the request was sent, zero waits forever
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout" yaml:"response_header_timeout"`
+	// MaxIdleConns and MaxIdleConnsPerHost limit the connections kept
+	// open for reuse in total and per backend
+	MaxIdleConns        int `json:"max_idle_conns" yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host" yaml:"max_idle_conns_per_host"`
+	// IdleConnTimeout closes connections unused for this duration
+	IdleConnTimeout time.Duration `json:"idle_conn_timeout" yaml:"idle_conn_timeout"`
+}
+
+// WithDefaults fills all unset values with their defaults
+func (t TransportConfig) WithDefaults() TransportConfig {
+	if t.DialTimeout <= 0 {
+		t.DialTimeout = 10 * time.Second
+	}
+	if t.MaxIdleConns <= 0 {
+		t.MaxIdleConns = 1000
+	}
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = 100
+	}
+	if t.IdleConnTimeout <= 0 {
+		t.IdleConnTimeout = 90 * time.Second
+	}
+	return t
+}
+
+var defaultTransport = NewTransport(TransportConfig{})
+
+// NewTransport creates a transport for proxied requests. Environment
+// proxies are ignored and responses are passed on without decompressing
+// them.
+func NewTransport(cfg TransportConfig) *http.Transport {
+	cfg = cfg.WithDefaults()
+	return &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          cfg.MaxIdleConns,
+		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:       cfg.IdleConnTimeout,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+		DisableCompression:    true,
+	}
+}
+
+// NewH2CTransport creates a transport sending requests using HTTP/2 with
+// prior knowledge over unencrypted connections as required by gRPC
+// services
+func NewH2CTransport(cfg TransportConfig) *http.Transport {
+	tr := NewTransport(cfg)
+	tr.Protocols = new(http.Protocols)
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return tr
+}
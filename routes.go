@@ -0,0 +1,21 @@
+package main
+
+import "net/http"
+
+// applyRoute rewrites the path of the request sent to the backend as
+// configured by the route matched in shieldOwnHosts
+func applyRoute(out *http.Request) error {
+	plan, ok := out.Context().Value(upstreamPlanKey{}).(*upstreamPlan)
+	if !ok || plan.route == nil {
+		return nil
+	}
+
+	if out.URL.Path != plan.route.Path {
+		out.URL.Path = plan.route.Path
+		out.URL.RawPath = ""
+	}
+	if plan.route.StrippedPrefix != "" {
+		out.Header.Set("X-Forwarded-Prefix", plan.route.StrippedPrefix)
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package routing
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Route sends matching requests of a domain to a slug. All given
+// conditions must match, a route without conditions matches every
+// request.
+type Route struct {
+	// PathPrefix matches paths starting with the prefix on a segment
+	// boundary ("/v1" matches "/v1" and "/v1/users" but not "/v10")
+	PathPrefix string `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+	// PathRegex matches the path against a regular expression
+	PathRegex string `json:"path_regex,omitempty" yaml:"path_regex,omitempty"`
+	// Methods limits the route to these request methods
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// Headers maps header names to regular expressions the value must
+	// match, an empty expression requires the header to be present
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+
+	Slug string `json:"slug" yaml:"slug"`
+	// StripPrefix removes the PathPrefix before passing the request on
+	StripPrefix bool `json:"strip_prefix,omitempty" yaml:"strip_prefix,omitempty"`
+	// Rewrite replaces the PathPrefix or the match of the PathRegex
+	// (supporting $1 style references) in the path
+	Rewrite *string `json:"rewrite,omitempty" yaml:"rewrite,omitempty"`
+}
+
+// Match is the result of a successful route lookup
+type Match struct {
+	Slug string
+	// Path is the path to send to the backend
+	Path string
+	// StrippedPrefix is the part of the path removed by StripPrefix
+	StrippedPrefix string
+}
+
+type compiledRoute struct {
+	Route
+	prefix  string
+	regex   *regexp.Regexp
+	methods map[string]bool
+	headers map[string]*regexp.Regexp
+}
+
+// Table holds the routes of a domain in the order they are tried
+type Table struct {
+	routes []compiledRoute
+}
+
+// New validates and compiles the routes
+func New(routes []Route) (*Table, error) {
+	t := &Table{}
+	for i, r := range routes {
+		c, err := compile(r)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid route %d: %s", i+1, err)
+		}
+		t.routes = append(t.routes, c)
+	}
+	return t, nil
+}
+
+func compile(r Route) (compiledRoute, error) {
+	c := compiledRoute{Route: r}
+
+	if r.Slug == "" {
+		return c, fmt.Errorf("No slug given")
+	}
+	if r.PathPrefix != "" && r.PathRegex != "" {
+		return c, fmt.Errorf("path_prefix and path_regex are mutually exclusive")
+	}
+	if r.PathPrefix != "" {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return c, fmt.Errorf("path_prefix must start with a slash")
+		}
+		c.prefix = strings.TrimSuffix(r.PathPrefix, "/")
+	}
+	if r.PathRegex != "" {
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return c, fmt.Errorf("Invalid path_regex: %s", err)
+		}
+		c.regex = re
+	}
+	if r.StripPrefix && r.PathPrefix == "" {
+		return c, fmt.Errorf("strip_prefix requires a path_prefix")
+	}
+	if r.StripPrefix && r.Rewrite != nil {
+		return c, fmt.Errorf("strip_prefix and rewrite are mutually exclusive")
+	}
+	if r.Rewrite != nil && r.PathPrefix == "" && r.PathRegex == "" {
+		return c, fmt.Errorf("rewrite requires a path_prefix or path_regex")
+	}
+
+	if len(r.Methods) > 0 {
+		c.methods = make(map[string]bool)
+		for _, m := range r.Methods {
+			c.methods[strings.ToUpper(m)] = true
+		}
+	}
+
+	if len(r.Headers) > 0 {
+		c.headers = make(map[string]*regexp.Regexp)
+		for name, expr := range r.Headers {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return c, fmt.Errorf("Invalid expression for header %s: %s", name, err)
+			}
+			c.headers[http.CanonicalHeaderKey(name)] = re
+		}
+	}
+
+	return c, nil
+}
+
+// Match returns the first route matching the request
+func (t *Table) Match(req *http.Request) (Match, bool) {
+	if t == nil {
+		return Match{}, false
+	}
+	for _, r := range t.routes {
+		if m, ok := r.match(req); ok {
+			return m, true
+		}
+	}
+	return Match{}, false
+}
+
+func (c compiledRoute) match(req *http.Request) (Match, bool) {
+	path := req.URL.Path
+	m := Match{Slug: c.Slug, Path: path}
+
+	if c.methods != nil && !c.methods[req.Method] {
+		return m, false
+	}
+	for name, re := range c.headers {
+		values, ok := req.Header[name]
+		if !ok || !matchesAny(re, values) {
+			return m, false
+		}
+	}
+
+	switch {
+	case c.PathPrefix != "":
+		if !hasPathPrefix(path, c.prefix) {
+			return m, false
+		}
+		switch {
+		case c.StripPrefix:
+			m.Path = ensureSlash(strings.TrimPrefix(path, c.prefix))
+			m.StrippedPrefix = c.prefix
+		case c.Rewrite != nil:
+			m.Path = ensureSlash(strings.TrimSuffix(*c.Rewrite, "/") + strings.TrimPrefix(path, c.prefix))
+		}
+
+	case c.regex != nil:
+		if !c.regex.MatchString(path) {
+			return m, false
+		}
+		if c.Rewrite != nil {
+			m.Path = ensureSlash(c.regex.ReplaceAllString(path, *c.Rewrite))
+		}
+	}
+
+	return m, true
+}
+
+func matchesAny(re *regexp.Regexp, values []string) bool {
+	for _, v := range values {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func ensureSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
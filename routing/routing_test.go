@@ -0,0 +1,66 @@
+package routing
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTableMatch(t *testing.T) {
+	api := "/api"
+	user := "/users/$1"
+	table, err := New([]Route{
+		{PathPrefix: "/v1", Slug: "v1", StripPrefix: true},
+		{PathPrefix: "/v2/", Slug: "v2", Rewrite: &api},
+		{PathRegex: `^/u/([0-9]+)$`, Slug: "users", Rewrite: &user},
+		{PathPrefix: "/admin", Methods: []string{"post"}, Slug: "admin-write"},
+		{PathPrefix: "/admin", Headers: map[string]string{"X-Role": "^admin$"}, Slug: "admin"},
+		{Headers: map[string]string{"X-Canary": ""}, Slug: "canary"},
+	})
+	if err != nil {
+		t.Fatalf("Unable to compile routes: %s", err)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		headers      map[string]string
+		slug, result string
+		found        bool
+	}{
+		{"GET", "/v1/users", nil, "v1", "/users", true},
+		{"GET", "/v1", nil, "v1", "/", true},
+		{"GET", "/v10", nil, "", "", false},
+		{"GET", "/v2/users", nil, "v2", "/api/users", true},
+		{"GET", "/u/42", nil, "users", "/users/42", true},
+		{"POST", "/admin/settings", nil, "admin-write", "/admin/settings", true},
+		{"GET", "/admin/settings", map[string]string{"X-Role": "admin"}, "admin", "/admin/settings", true},
+		{"GET", "/admin/settings", map[string]string{"X-Role": "user"}, "", "", false},
+		{"GET", "/", map[string]string{"X-Canary": "1"}, "canary", "/", true},
+	} {
+		req, _ := http.NewRequest(tc.method, "http://example.com"+tc.path, nil)
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		m, ok := table.Match(req)
+		if ok != tc.found || m.Slug != tc.slug || (ok && m.Path != tc.result) {
+			t.Errorf("%s %s: unexpected match %#v (%v)", tc.method, tc.path, m, ok)
+		}
+	}
+}
+
+func TestRouteValidation(t *testing.T) {
+	rewrite := "/x"
+	for name, r := range map[string]Route{
+		"no slug":              {PathPrefix: "/a"},
+		"prefix and regex":     {PathPrefix: "/a", PathRegex: "^/a", Slug: "a"},
+		"relative prefix":      {PathPrefix: "a", Slug: "a"},
+		"invalid regex":        {PathRegex: "(", Slug: "a"},
+		"strip without path":   {StripPrefix: true, Slug: "a"},
+		"strip and rewrite":    {PathPrefix: "/a", StripPrefix: true, Rewrite: &rewrite, Slug: "a"},
+		"rewrite without path": {Rewrite: &rewrite, Slug: "a"},
+		"invalid header":       {Headers: map[string]string{"X": "("}, Slug: "a"},
+	} {
+		if _, err := New([]Route{r}); err == nil {
+			t.Errorf("Route %q was accepted", name)
+		}
+	}
+}
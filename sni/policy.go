@@ -0,0 +1,224 @@
+package sni
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// Profiles following the Mozilla server side TLS recommendations
+const (
+	ProfileModern       = "modern"
+	ProfileIntermediate = "intermediate"
+	ProfileOld          = "old"
+)
+
+// DefaultProfile is used if a Policy does not name a profile
+const DefaultProfile = ProfileIntermediate
+
+type profile struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+var (
+	intermediateCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+
+	profiles = map[string]profile{
+		// TLS 1.3 cipher suites are not configurable
+		ProfileModern: {
+			minVersion: tls.VersionTLS13,
+			curves:     []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		ProfileIntermediate: {
+			minVersion:   tls.VersionTLS12,
+			cipherSuites: intermediateCipherSuites,
+			curves:       []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384},
+		},
+		// 3DES of the Mozilla profile is left out as it is insecure
+		ProfileOld: {
+			minVersion: tls.VersionTLS10,
+			cipherSuites: append(append([]uint16{}, intermediateCipherSuites...),
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+				tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+			),
+			curves: []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521},
+		},
+	}
+
+	versions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	curves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P-256":  tls.CurveP256,
+		"P-384":  tls.CurveP384,
+		"P-521":  tls.CurveP521,
+	}
+)
+
+// Policy describes the TLS parameters offered to clients. Unset values
+// are taken from the profile.
+type Policy struct {
+	Profile string `json:"profile,omitempty" yaml:"profile,omitempty"`
+	// MinVersion and MaxVersion are given like "1.2"
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	MaxVersion string `json:"max_version,omitempty" yaml:"max_version,omitempty"`
+	// CipherSuites are named like TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+	// and only apply to TLS 1.2 and below
+	CipherSuites []string `json:"cipher_suites,omitempty" yaml:"cipher_suites,omitempty"`
+	// Curves are named X25519, P-256, P-384 or P-521
+	Curves []string `json:"curves,omitempty" yaml:"curves,omitempty"`
+}
+
+// Merge returns the policy with all values set in the override replaced.
+// If the override names a profile the values of the policy are dropped.
+func (p Policy) Merge(override Policy) Policy {
+	if override.Profile != "" {
+		p = Policy{Profile: override.Profile}
+	}
+	if override.MinVersion != "" {
+		p.MinVersion = override.MinVersion
+	}
+	if override.MaxVersion != "" {
+		p.MaxVersion = override.MaxVersion
+	}
+	if len(override.CipherSuites) > 0 {
+		p.CipherSuites = override.CipherSuites
+	}
+	if len(override.Curves) > 0 {
+		p.Curves = override.Curves
+	}
+	return p
+}
+
+// Validate checks the policy for unknown values and insecure or
+// contradicting combinations
+func (p Policy) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// Apply sets the TLS parameters of the policy in the config
+func (p Policy) Apply(cfg *tls.Config) error {
+	c, err := p.compile()
+	if err != nil {
+		return err
+	}
+	cfg.MinVersion = c.MinVersion
+	cfg.MaxVersion = c.MaxVersion
+	cfg.CipherSuites = c.CipherSuites
+	cfg.CurvePreferences = c.CurvePreferences
+	return nil
+}
+
+func (p Policy) compile() (*tls.Config, error) {
+	name := p.Profile
+	if name == "" {
+		name = DefaultProfile
+	}
+	prof, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown TLS profile %q", p.Profile)
+	}
+
+	cfg := &tls.Config{
+		MinVersion:       prof.minVersion,
+		CipherSuites:     prof.cipherSuites,
+		CurvePreferences: prof.curves,
+	}
+
+	if p.MinVersion != "" {
+		if cfg.MinVersion, ok = versions[p.MinVersion]; !ok {
+			return nil, fmt.Errorf("Unknown TLS version %q", p.MinVersion)
+		}
+	}
+	if p.MaxVersion != "" {
+		if cfg.MaxVersion, ok = versions[p.MaxVersion]; !ok {
+			return nil, fmt.Errorf("Unknown TLS version %q", p.MaxVersion)
+		}
+		if cfg.MaxVersion < tls.VersionTLS12 {
+			return nil, fmt.Errorf("Maximum TLS version %s does not allow TLS 1.2 or newer", p.MaxVersion)
+		}
+		if cfg.MaxVersion < cfg.MinVersion {
+			return nil, fmt.Errorf("Maximum TLS version %s is below the minimum version", p.MaxVersion)
+		}
+	}
+
+	if len(p.CipherSuites) > 0 {
+		if cfg.MinVersion >= tls.VersionTLS13 {
+			return nil, fmt.Errorf("Cipher suites cannot be configured for TLS 1.3 only")
+		}
+		suites, err := parseCipherSuites(p.CipherSuites)
+		if err != nil {
+			return nil, err
+		}
+		cfg.CipherSuites = suites
+	}
+
+	if len(p.Curves) > 0 {
+		cfg.CurvePreferences = nil
+		for _, name := range p.Curves {
+			curve, ok := curves[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown curve %q", name)
+			}
+			cfg.CurvePreferences = append(cfg.CurvePreferences, curve)
+		}
+	}
+
+	return cfg, nil
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	secure := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		secure[s.Name] = s.ID
+	}
+	insecure := make(map[string]bool)
+	for _, s := range tls.InsecureCipherSuites() {
+		insecure[s.Name] = true
+	}
+
+	var (
+		result         []uint16
+		forwardSecrecy bool
+	)
+	for _, name := range names {
+		if insecure[name] {
+			return nil, fmt.Errorf("Cipher suite %s is insecure", name)
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown cipher suite %q", name)
+		}
+		if strings.HasPrefix(name, "TLS_AES_") || strings.HasPrefix(name, "TLS_CHACHA20_") {
+			return nil, fmt.Errorf("Cipher suite %s is a TLS 1.3 suite which cannot be configured", name)
+		}
+		forwardSecrecy = forwardSecrecy || strings.HasPrefix(name, "TLS_ECDHE_")
+		result = append(result, id)
+	}
+
+	if !forwardSecrecy {
+		return nil, fmt.Errorf("Cipher suites contain no suite with forward secrecy (ECDHE)")
+	}
+	return result, nil
+}
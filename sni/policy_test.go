@@ -0,0 +1,84 @@
+package sni
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	for name, p := range map[string]Policy{
+		"unknown profile":      {Profile: "paranoid"},
+		"unknown version":      {MinVersion: "1.4"},
+		"max below 1.2":        {Profile: ProfileOld, MaxVersion: "1.1"},
+		"max below min":        {MinVersion: "1.3", MaxVersion: "1.2"},
+		"3DES":                 {CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_3DES_EDE_CBC_SHA"}},
+		"no forward secrecy":   {CipherSuites: []string{"TLS_RSA_WITH_AES_128_GCM_SHA256"}},
+		"TLS 1.3 suite":        {CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+		"suites with TLS 1.3":  {Profile: ProfileModern, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		"unknown curve":        {Curves: []string{"P-192"}},
+		"unknown cipher suite": {CipherSuites: []string{"TLS_FOO"}},
+	} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Policy %q was accepted", name)
+		}
+	}
+
+	for _, p := range []Policy{{}, {Profile: ProfileModern}, {Profile: ProfileOld}, {MinVersion: "1.2", MaxVersion: "1.3", Curves: []string{"X25519"}}} {
+		if err := p.Validate(); err != nil {
+			t.Errorf("Valid policy %#v was rejected: %s", p, err)
+		}
+	}
+}
+
+func TestPolicyApply(t *testing.T) {
+	cfg := &tls.Config{}
+	if err := (Policy{}).Apply(cfg); err != nil {
+		t.Fatalf("Unable to apply default policy: %s", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != 0 || len(cfg.CipherSuites) != 6 {
+		t.Errorf("Unexpected default config: %#v", cfg)
+	}
+
+	// A profile in the override drops the inherited values
+	global := Policy{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}}
+	merged := global.Merge(Policy{Profile: ProfileModern})
+	if err := merged.Apply(cfg); err != nil {
+		t.Fatalf("Unable to apply merged policy: %s", err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.CipherSuites != nil {
+		t.Errorf("Unexpected modern config: %#v", cfg)
+	}
+
+	merged = global.Merge(Policy{Curves: []string{"P-384"}})
+	if merged.MinVersion != "1.2" || len(merged.CipherSuites) != 1 || len(merged.Curves) != 1 {
+		t.Errorf("Values were not merged: %#v", merged)
+	}
+}
+
+func TestDomainPolicies(t *testing.T) {
+	s := &SNIServer{base: &tls.Config{}}
+	if err := s.SetPolicies(Policy{}, map[string]Policy{
+		"legacy.example.com":   {Profile: ProfileOld},
+		"*.modern.example.com": {Profile: ProfileModern},
+	}); err != nil {
+		t.Fatalf("Unable to set policies: %s", err)
+	}
+
+	for name, expected := range map[string]uint16{
+		"www.example.com":      tls.VersionTLS12,
+		"Legacy.example.com.":  tls.VersionTLS10,
+		"a.modern.example.com": tls.VersionTLS13,
+	} {
+		cfg, err := s.getConfigForClient(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil || cfg.MinVersion != expected {
+			t.Errorf("Unexpected minimum version for %s: %x (%v)", name, cfg.MinVersion, err)
+		}
+	}
+
+	if err := s.SetPolicies(Policy{}, map[string]Policy{"x.example.com": {MaxVersion: "1.0"}}); err == nil {
+		t.Errorf("Invalid domain policy was accepted")
+	}
+	if cfg, _ := s.getConfigForClient(&tls.ClientHelloInfo{ServerName: "legacy.example.com"}); cfg.MinVersion != tls.VersionTLS10 {
+		t.Errorf("Failed update changed the policies")
+	}
+}
@@ -4,12 +4,15 @@
 package sni
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/pem"
+	"fmt"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hydrogen18/stoppableListener"
 )
@@ -19,21 +22,153 @@ type Certificates struct {
 	CertFile string
 	KeyFile  string
 
-	Certificate  *x509.Certificate
-	Key          *rsa.PrivateKey
-	Intermediate *x509.Certificate
+	Certificate *x509.Certificate
+	// Key is a RSA, ECDSA or Ed25519 private key
+	Key crypto.Signer
+	// Chain contains the issuer certificates sent after the certificate
+	Chain []*x509.Certificate
+}
+
+// CertificateInfo describes a certificate served by the SNIServer
+type CertificateInfo struct {
+	Names     []string  `json:"names"`
+	Issuer    string    `json:"issuer"`
+	KeyType   string    `json:"key_type"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
 }
 
 type SNIServer struct {
+	// OnDemand is asked for a certificate if no loaded certificate
+	// matches the requested name. If it fails the fallback certificate
+	// is served.
+	OnDemand func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
 	listener *stoppableListener.StoppableListener
+	store    *Store
+	once     sync.Once
+
+	policyLock sync.RWMutex
+	policies   policySet
+	base       *tls.Config
+	configs    map[string]*tls.Config
+}
+
+type policySet struct {
+	global  Policy
+	domains map[string]Policy
+}
+
+func (s *SNIServer) getStore() *Store {
+	s.once.Do(func() { s.store = NewStore() })
+	return s.store
+}
+
+// LoadedCertificates returns information about the certificates
+// currently served
+func (s *SNIServer) LoadedCertificates() []CertificateInfo {
+	return s.getStore().Certificates()
+}
+
+// SetChallengeCertificate registers the certificate answering ACME
+// tls-alpn-01 validations for the name, nil removes it
+func (s *SNIServer) SetChallengeCertificate(name string, cert *tls.Certificate) {
+	s.getStore().SetChallengeCertificate(name, cert)
+}
+
+func (s *SNIServer) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store := s.getStore()
+	if s.OnDemand != nil && !isChallengeHello(hello) {
+		if _, ok := store.Match(hello.ServerName); !ok {
+			if cert, err := s.OnDemand(hello); err == nil {
+				return cert, nil
+			}
+		}
+	}
+	return store.GetCertificate(hello)
+}
+
+// SetPolicies replaces the TLS policy for all connections and the
+// overrides for single domains. Domain overrides are merged into the
+// global policy, wildcard domains like "*.example.com" are supported.
+// On error the previous policies are kept.
+func (s *SNIServer) SetPolicies(global Policy, domains map[string]Policy) error {
+	set := policySet{global: global, domains: make(map[string]Policy)}
+	if err := global.Validate(); err != nil {
+		return err
+	}
+	for name, p := range domains {
+		merged := global.Merge(p)
+		if err := merged.Validate(); err != nil {
+			return fmt.Errorf("Invalid TLS policy for %s: %s", name, err)
+		}
+		set.domains[strings.ToLower(name)] = merged
+	}
+
+	s.policyLock.Lock()
+	defer s.policyLock.Unlock()
+	s.policies = set
+	return s.compilePolicies()
+}
+
+// compilePolicies builds the TLS configs for the listener from its base
+// config, the policyLock must be held
+func (s *SNIServer) compilePolicies() error {
+	if s.base == nil {
+		return nil
+	}
+
+	configs := make(map[string]*tls.Config)
+	global := s.base.Clone()
+	if err := s.policies.global.Apply(global); err != nil {
+		return err
+	}
+	configs[""] = global
+
+	for name, p := range s.policies.domains {
+		cfg := s.base.Clone()
+		if err := p.Apply(cfg); err != nil {
+			return err
+		}
+		configs[name] = cfg
+	}
+
+	s.configs = configs
+	return nil
+}
+
+func (s *SNIServer) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	s.policyLock.RLock()
+	defer s.policyLock.RUnlock()
+
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cfg, ok := s.configs[name]; ok {
+		return cfg, nil
+	}
+	if idx := strings.Index(name, "."); idx > 0 {
+		if cfg, ok := s.configs["*"+name[idx:]]; ok {
+			return cfg, nil
+		}
+	}
+	return s.configs[""], nil
+}
+
+// UpdateCertificates atomically replaces the served certificates without
+// restarting the listener. Certificates which cannot be loaded are
+// skipped and reported in the returned error.
+func (s *SNIServer) UpdateCertificates(certs []Certificates) error {
+	return s.getStore().Update(certs)
 }
 
 func (s *SNIServer) Stop() {
-	s.listener.Stop()
+	if s.listener != nil {
+		s.listener.Stop()
+	}
 }
 
 // ListenAndServeTLSSNI openes a http listener with SNI certificate selection
-// from the Certificates collection
+// from the Certificates collection. If certs is nil the certificates set
+// by UpdateCertificates are served.
 func (s *SNIServer) ListenAndServeTLSSNI(srv *http.Server, certs []Certificates) error {
 	addr := srv.Addr
 	if addr == "" {
@@ -41,64 +176,50 @@ func (s *SNIServer) ListenAndServeTLSSNI(srv *http.Server, certs []Certificates)
 	}
 	config := &tls.Config{}
 	if srv.TLSConfig != nil {
-		*config = *srv.TLSConfig
+		config = srv.TLSConfig.Clone()
 	}
-	if config.NextProtos == nil {
-		config.NextProtos = []string{"http/1.1"}
+	// Setting TLSNextProto below disables the automatic HTTP/2 support of
+	// the server unless its protocols are set explicitly
+	if srv.Protocols == nil {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(true)
 	}
 
-	var err error
-
-	config.Certificates = make([]tls.Certificate, len(certs))
-	for i, v := range certs {
-		if v.Certificate != nil {
-			certPEM := pem.EncodeToMemory(&pem.Block{Bytes: v.Certificate.Raw, Type: "CERTIFICATE"})
-			if v.Intermediate != nil {
-				certPEM = append(certPEM, '\n')
-				certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Bytes: v.Intermediate.Raw, Type: "CERTIFICATE"})...)
-			}
-
-			config.Certificates[i], err = tls.X509KeyPair(
-				certPEM,
-				pem.EncodeToMemory(&pem.Block{Bytes: x509.MarshalPKCS1PrivateKey(v.Key), Type: "RSA PRIVATE KEY"}),
-			)
-			if err != nil {
-				return err
-			}
-		} else {
-			config.Certificates[i], err = tls.LoadX509KeyPair(v.CertFile, v.KeyFile)
-			if err != nil {
-				return err
-			}
+	if config.NextProtos == nil {
+		if srv.Protocols.HTTP2() {
+			config.NextProtos = append(config.NextProtos, "h2")
 		}
+		config.NextProtos = append(config.NextProtos, "http/1.1")
 	}
+	config.NextProtos = append(config.NextProtos, ALPNChallengeProto)
 
-	config.BuildNameToCertificate()
-
-	// ++++ SSL security settings
-
-	// Force clients to use TLS1.0 as SSL is buggy as hell
-	config.MinVersion = tls.VersionTLS10
-
-	// Force TLS negotiation to pick a result from the (presumably) stronger list of server-specified ciphers
-	config.PreferServerCipherSuites = true
-
-	// Pick secure cipher suites
-	config.CipherSuites = []uint16{
-		// 256bit
-		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-		tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
-		tls.TLS_RSA_WITH_AES_256_CBC_SHA,
-		// 128bit
-		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-		tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-		tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-		// 112bit
-		tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-		tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
+	// Validation connections are closed after the handshake by the CA,
+	// they must not be handled as HTTP requests
+	if srv.TLSNextProto == nil {
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	}
+	srv.TLSNextProto[ALPNChallengeProto] = func(_ *http.Server, conn *tls.Conn, _ http.Handler) {
+		conn.Close()
 	}
 
-	// ++++ End SSL security settings
+	if certs != nil {
+		if err := s.UpdateCertificates(certs); err != nil {
+			return err
+		}
+	}
+	config.GetCertificate = s.getCertificate
+
+	// Protocol versions, cipher suites and curves are chosen per
+	// connection from the policies set by SetPolicies
+	s.policyLock.Lock()
+	s.base = config.Clone()
+	err := s.compilePolicies()
+	s.policyLock.Unlock()
+	if err != nil {
+		return err
+	}
+	config.GetConfigForClient = s.getConfigForClient
 
 	conn, err := net.Listen("tcp", addr)
 	if err != nil {
@@ -0,0 +1,70 @@
+package sni
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestListenerNegotiatesHTTP2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to find free port: %s", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := &SNIServer{}
+	srv := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Trailer", "Grpc-Status")
+			w.Write([]byte(r.Proto))
+			w.Header().Set("Grpc-Status", "0")
+		}),
+	}
+	go s.ListenAndServeTLSSNI(srv, []Certificates{writeTestCertificate(t, dir, "a.example.com")})
+	defer s.Stop()
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true, ServerName: "a.example.com"},
+		ForceAttemptHTTP2: true,
+	}}
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = client.Get("https://" + addr + "/"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Request failed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.ProtoMajor != 2 || string(body) != "HTTP/2.0" {
+		t.Errorf("HTTP/2 was not negotiated: %s / %s", resp.Proto, body)
+	}
+	if resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Trailer was not transmitted: %v", resp.Trailer)
+	}
+
+	// Validation handshakes must still be possible
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, ServerName: "a.example.com", NextProtos: []string{ALPNChallengeProto}})
+	if err == nil {
+		conn.Close()
+		t.Errorf("Validation handshake without challenge certificate succeeded")
+	}
+}
@@ -0,0 +1,267 @@
+package sni
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ALPNChallengeProto is the ALPN protocol of ACME tls-alpn-01 validations
+const ALPNChallengeProto = "acme-tls/1"
+
+// ErrNoCertificate is returned if no certificate matches the requested name
+var ErrNoCertificate = errors.New("no certificate available for requested name")
+
+type certificateSet struct {
+	// byName holds all certificates for a name with different key types
+	// ordered by preference
+	byName   map[string][]*tls.Certificate
+	fallback *tls.Certificate
+	infos    []CertificateInfo
+}
+
+// Store holds the certificates served by the SNIServer and allows to
+// swap them atomically without interrupting the listener
+type Store struct {
+	current atomic.Value
+
+	challengeLock sync.RWMutex
+	challenges    map[string]*tls.Certificate
+}
+
+// NewStore creates an empty Store
+func NewStore() *Store {
+	s := &Store{challenges: make(map[string]*tls.Certificate)}
+	s.current.Store(&certificateSet{byName: make(map[string][]*tls.Certificate)})
+	return s
+}
+
+// Update replaces all certificates in the store. Certificates which
+// cannot be loaded are skipped and reported in the returned error, the
+// store is only left untouched if none of them could be loaded. A name
+// may have one certificate per key type, for certificates with the same
+// key type the last one wins.
+func (s *Store) Update(certs []Certificates) error {
+	var errs []string
+	set := &certificateSet{byName: make(map[string][]*tls.Certificate)}
+	for _, v := range certs {
+		cert, err := v.TLSCertificate()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("Unable to load certificate %s: %s", v.describe(), err))
+			continue
+		}
+
+		leaf := cert.Leaf
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+
+		for _, name := range names {
+			name = strings.ToLower(name)
+			set.byName[name] = addCertificate(set.byName[name], cert)
+		}
+		if set.fallback == nil {
+			set.fallback = cert
+		}
+
+		set.infos = append(set.infos, CertificateInfo{
+			Names:     names,
+			Issuer:    leaf.Issuer.CommonName,
+			KeyType:   keyType(leaf.PublicKey),
+			NotBefore: leaf.NotBefore,
+			NotAfter:  leaf.NotAfter,
+		})
+	}
+
+	if len(errs) > 0 && len(set.infos) == 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	s.current.Store(set)
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// describe names the certificate for error messages
+func (c Certificates) describe() string {
+	if c.Certificate != nil {
+		return strings.Join(c.Certificate.DNSNames, ",")
+	}
+	return c.CertFile
+}
+
+// addCertificate adds the certificate to the list replacing one with
+// the same key type. ECDSA and Ed25519 certificates are preferred over
+// RSA as they are smaller and faster.
+func addCertificate(certs []*tls.Certificate, cert *tls.Certificate) []*tls.Certificate {
+	kt := keyType(cert.Leaf.PublicKey)
+	result := []*tls.Certificate{}
+	for _, c := range certs {
+		if keyType(c.Leaf.PublicKey) != kt {
+			result = append(result, c)
+		}
+	}
+	result = append(result, cert)
+
+	sort.SliceStable(result, func(i, j int) bool {
+		_, iRSA := result[i].Leaf.PublicKey.(*rsa.PublicKey)
+		_, jRSA := result[j].Leaf.PublicKey.(*rsa.PublicKey)
+		return !iRSA && jRSA
+	})
+	return result
+}
+
+// keyType describes the algorithm and size of a public key
+func keyType(pub crypto.PublicKey) string {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// selectCertificate returns the first certificate supported by the
+// client or the most preferred one if the client supports none
+func selectCertificate(hello *tls.ClientHelloInfo, certs []*tls.Certificate) *tls.Certificate {
+	for _, c := range certs {
+		if hello.SupportsCertificate(c) == nil {
+			return c
+		}
+	}
+	return certs[0]
+}
+
+// Certificates returns information about the certificates currently
+// served from the store
+func (s *Store) Certificates() []CertificateInfo {
+	return append([]CertificateInfo{}, s.current.Load().(*certificateSet).infos...)
+}
+
+// SetChallengeCertificate registers the certificate to answer
+// tls-alpn-01 validations for the name. Passing nil removes it.
+func (s *Store) SetChallengeCertificate(name string, cert *tls.Certificate) {
+	s.challengeLock.Lock()
+	defer s.challengeLock.Unlock()
+
+	name = strings.ToLower(name)
+	if cert == nil {
+		delete(s.challenges, name)
+		return
+	}
+	s.challenges[name] = cert
+}
+
+// GetCertificate selects the certificate for the client hello and is
+// intended to be used as tls.Config.GetCertificate
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+
+	if isChallengeHello(hello) {
+		// Validation handshakes must never get a regular certificate
+		s.challengeLock.RLock()
+		defer s.challengeLock.RUnlock()
+		if cert, ok := s.challenges[name]; ok {
+			return cert, nil
+		}
+		return nil, ErrNoCertificate
+	}
+
+	if certs := s.match(name); len(certs) > 0 {
+		return selectCertificate(hello, certs), nil
+	}
+
+	if set := s.current.Load().(*certificateSet); set.fallback != nil {
+		return set.fallback, nil
+	}
+	return nil, ErrNoCertificate
+}
+
+// Match returns the preferred certificate valid for the name without
+// using the fallback certificate
+func (s *Store) Match(name string) (*tls.Certificate, bool) {
+	if certs := s.match(name); len(certs) > 0 {
+		return certs[0], true
+	}
+	return nil, false
+}
+
+func (s *Store) match(name string) []*tls.Certificate {
+	set := s.current.Load().(*certificateSet)
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+
+	if certs, ok := set.byName[name]; ok {
+		return certs
+	}
+
+	// Try a wildcard certificate for the parent domain
+	if idx := strings.Index(name, "."); idx > 0 {
+		return set.byName["*"+name[idx:]]
+	}
+
+	return nil
+}
+
+func isChallengeHello(hello *tls.ClientHelloInfo) bool {
+	for _, proto := range hello.SupportedProtos {
+		if proto == ALPNChallengeProto {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSCertificate loads the certificate and its key into a
+// tls.Certificate with the parsed leaf set
+func (c Certificates) TLSCertificate() (*tls.Certificate, error) {
+	cert, err := c.loadKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, err
+		}
+	}
+	return cert, nil
+}
+
+func (c Certificates) loadKeyPair() (*tls.Certificate, error) {
+	if c.Certificate == nil {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		return &cert, err
+	}
+
+	if c.Key == nil {
+		return nil, errors.New("Certificate has no private key")
+	}
+	if pub, ok := c.Key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(c.Certificate.PublicKey) {
+		return nil, errors.New("Private key does not match certificate")
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{c.Certificate.Raw},
+		PrivateKey:  c.Key,
+		Leaf:        c.Certificate,
+	}
+	for _, issuer := range c.Chain {
+		cert.Certificate = append(cert.Certificate, issuer.Raw)
+	}
+	return cert, nil
+}
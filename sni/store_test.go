@@ -0,0 +1,231 @@
+package sni
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(t *testing.T, dir string, names ...string) Certificates {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	return writeKeyedCertificate(t, dir, key, names...)
+}
+
+func writeKeyedCertificate(t *testing.T, dir string, key crypto.Signer, names ...string) Certificates {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Unable to marshal key: %s", err)
+	}
+
+	c := Certificates{
+		CertFile: path.Join(dir, names[0]+"-"+keyType(key.Public())+".crt"),
+		KeyFile:  path.Join(dir, names[0]+"-"+keyType(key.Public())+".key"),
+	}
+	ioutil.WriteFile(c.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(c.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	return c
+}
+
+func servedName(t *testing.T, s *Store, serverName string) string {
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatalf("No certificate for %s: %s", serverName, err)
+	}
+	return cert.Leaf.DNSNames[0]
+}
+
+func TestStoreSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewStore()
+	if _, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.example.com"}); err != ErrNoCertificate {
+		t.Errorf("Empty store returned a certificate")
+	}
+
+	a := writeTestCertificate(t, dir, "a.example.com")
+	wild := writeTestCertificate(t, dir, "*.wild.example.com")
+	if err := s.Update([]Certificates{a, wild}); err != nil {
+		t.Fatalf("Unable to update store: %s", err)
+	}
+
+	if n := servedName(t, s, "A.example.com"); n != "a.example.com" {
+		t.Errorf("Wrong certificate for a.example.com: %s", n)
+	}
+	if n := servedName(t, s, "foo.wild.example.com"); n != "*.wild.example.com" {
+		t.Errorf("Wrong certificate for wildcard: %s", n)
+	}
+
+	// Update without any loadable certificate must keep the previous ones
+	if err := s.Update([]Certificates{{CertFile: path.Join(dir, "missing.crt"), KeyFile: a.KeyFile}}); err == nil {
+		t.Fatalf("Update with missing file succeeded")
+	}
+	if len(s.Certificates()) != 2 {
+		t.Errorf("Failed update changed the store")
+	}
+
+	// Removing a domain takes effect immediately
+	b := writeTestCertificate(t, dir, "b.example.com")
+	if err := s.Update([]Certificates{b}); err != nil {
+		t.Fatalf("Unable to update store: %s", err)
+	}
+	if n := servedName(t, s, "a.example.com"); n != "b.example.com" {
+		t.Errorf("Removed certificate is still served: %s", n)
+	}
+}
+
+func TestStoreChallengeCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := NewStore()
+	if err := s.Update([]Certificates{writeTestCertificate(t, dir, "a.example.com")}); err != nil {
+		t.Fatalf("Unable to update store: %s", err)
+	}
+
+	hello := &tls.ClientHelloInfo{ServerName: "a.example.com", SupportedProtos: []string{ALPNChallengeProto}}
+	if _, err := s.GetCertificate(hello); err != ErrNoCertificate {
+		t.Errorf("Regular certificate served for validation handshake")
+	}
+
+	challenge := &tls.Certificate{}
+	s.SetChallengeCertificate("A.example.com", challenge)
+	if cert, err := s.GetCertificate(hello); err != nil || cert != challenge {
+		t.Errorf("Challenge certificate not served: %v", err)
+	}
+	if n := servedName(t, s, "a.example.com"); n != "a.example.com" {
+		t.Errorf("Regular handshake got wrong certificate: %s", n)
+	}
+
+	s.SetChallengeCertificate("a.example.com", nil)
+	if _, err := s.GetCertificate(hello); err != ErrNoCertificate {
+		t.Errorf("Removed challenge certificate still served")
+	}
+}
+
+func TestStoreDualCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+
+	s := NewStore()
+	if err := s.Update([]Certificates{
+		writeKeyedCertificate(t, dir, rsaKey, "a.example.com"),
+		writeTestCertificate(t, dir, "a.example.com"),
+		writeKeyedCertificate(t, dir, edKey, "ed.example.com"),
+	}); err != nil {
+		t.Fatalf("Unable to update store: %s", err)
+	}
+
+	modern := &tls.ClientHelloInfo{
+		ServerName:        "a.example.com",
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PSSWithSHA256},
+	}
+	legacy := &tls.ClientHelloInfo{
+		ServerName:        "a.example.com",
+		SupportedVersions: []uint16{tls.VersionTLS12},
+		CipherSuites:      []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		SignatureSchemes:  []tls.SignatureScheme{tls.PKCS1WithSHA256},
+		SupportedCurves:   []tls.CurveID{tls.X25519},
+		SupportedPoints:   []uint8{0},
+	}
+
+	for hello, expected := range map[*tls.ClientHelloInfo]string{modern: "P-256", legacy: "RSA-2048"} {
+		cert, err := s.GetCertificate(hello)
+		if err != nil {
+			t.Fatalf("No certificate served: %s", err)
+		}
+		if kt := keyType(cert.Leaf.PublicKey); kt != expected {
+			t.Errorf("Expected %s certificate, got %s", expected, kt)
+		}
+	}
+
+	if cert, ok := s.Match("ed.example.com"); !ok || keyType(cert.Leaf.PublicKey) != "Ed25519" {
+		t.Errorf("Ed25519 certificate not loaded")
+	}
+
+	// In-memory certificates with a mismatching key are rejected
+	leaf := s.Certificates()
+	if len(leaf) != 3 {
+		t.Fatalf("Unexpected certificates: %v", leaf)
+	}
+	a, _ := s.Match("a.example.com")
+	if _, err := (Certificates{Certificate: a.Leaf, Key: rsaKey}).TLSCertificate(); err == nil {
+		t.Errorf("Mismatching key was accepted")
+	}
+}
+
+func TestStoreSkipsBrokenCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sni")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := writeTestCertificate(t, dir, "a.example.com")
+	b := writeTestCertificate(t, dir, "b.example.com")
+	// A half-written certificate file
+	if err := ioutil.WriteFile(b.CertFile, []byte("-----BEGIN CERTIFICATE-----\nMIIB"), 0600); err != nil {
+		t.Fatalf("Unable to truncate certificate: %s", err)
+	}
+	c := writeTestCertificate(t, dir, "c.example.com")
+
+	s := NewStore()
+	err = s.Update([]Certificates{a, b, c})
+	if err == nil || !strings.Contains(err.Error(), b.CertFile) {
+		t.Errorf("Expected error naming the broken certificate, got %v", err)
+	}
+	if len(s.Certificates()) != 2 {
+		t.Fatalf("Expected the two valid certificates to be loaded, got %d", len(s.Certificates()))
+	}
+	if n := servedName(t, s, "c.example.com"); n != "c.example.com" {
+		t.Errorf("Wrong certificate for c.example.com: %s", n)
+	}
+}
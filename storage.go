@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/storage"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+func init() {
+	gob.Register(legacyCache{})
+	gob.Register(rsa.PrivateKey{})
+	gob.Register(rsa.PublicKey{})
+	gob.Register(x509.Certificate{})
+}
+
+// openStorage creates the configured certificate storage and migrates
+// the legacy cache file into it
+func openStorage() (storage.Storage, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil, err
+	}
+
+	storagePath := cfg.StoragePath
+	if storagePath == "" {
+		storagePath = path.Join(home, ".config", "dockerproxy")
+		if cfg.StorageType == "kv" {
+			storagePath += ".kv.json"
+		}
+	}
+	if storagePath, err = homedir.Expand(storagePath); err != nil {
+		return nil, err
+	}
+
+	store, err := storage.New(cfg.StorageType, storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrateLegacyCache(path.Join(home, ".config", "dockerproxy.lecache"), cfg.LetsEncryptServer, store); err != nil {
+		return nil, fmt.Errorf("Unable to migrate legacy cache: %s", err)
+	}
+	return store, nil
+}
+
+// legacyCache is the format of the gob encoded cache file used before
+// the introduction of the certificate storage
+type legacyCache struct {
+	AccountKey   *rsa.PrivateKey
+	AccountURL   string
+	Directory    string
+	Certificates map[string]legacyCertificate
+}
+
+type legacyCertificate struct {
+	Certificate *x509.Certificate
+	// Chain contains the DER encoded issuer certificates sent by the CA,
+	// it is empty for certificates cached by the ACME v1 client which
+	// fetched the intermediate separately
+	Chain [][]byte
+	Key   *rsa.PrivateKey
+}
+
+// migrateLegacyCache moves the account and certificates from the gob
+// cache file into the storage and renames the cache file afterwards.
+// Accounts without directory were created against the v1 API and are
+// migrated to the current server to be registered again.
+func migrateLegacyCache(cacheFile, server string, store storage.Storage) error {
+	cf, err := os.Open(cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer cf.Close()
+
+	cache := legacyCache{}
+	if err := gob.NewDecoder(cf).Decode(&cache); err != nil {
+		return err
+	}
+
+	if cache.AccountKey != nil {
+		account := &storage.Account{
+			Directory: cache.Directory,
+			URL:       cache.AccountURL,
+			Key:       cache.AccountKey,
+		}
+		if account.Directory == "" {
+			account.Directory, account.URL = server, ""
+		}
+		if err := store.SaveAccount(account); err != nil {
+			return err
+		}
+	}
+
+	migrated := 0
+	for _, c := range cache.Certificates {
+		if c.Certificate == nil || c.Key == nil {
+			continue
+		}
+
+		chain := []*x509.Certificate{c.Certificate}
+		for _, der := range c.Chain {
+			issuer, err := x509.ParseCertificate(der)
+			if err != nil {
+				return err
+			}
+			chain = append(chain, issuer)
+		}
+
+		names := append([]string{}, c.Certificate.DNSNames...)
+		sort.Strings(names)
+		// Certificate keys of previous versions were always RSA-4096
+		if err := store.SaveCertificate(storage.CertificateKey(names, string(acme.KeyRSA4096)), &storage.Certificate{Chain: chain, Key: c.Key}); err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	cf.Close()
+	log.Printf("[LetsEncrypt] Migrated %d certificates from %s", migrated, cacheFile)
+	return os.Rename(cacheFile, cacheFile+".migrated")
+}
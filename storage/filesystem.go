@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	dirMode        = 0700
+	privateMode    = 0600
+	accountFile    = "account.json"
+	accountKeyFile = "account.key"
+	bundleFile     = "bundle.pem"
+)
+
+// Filesystem stores accounts and certificates as JSON and PEM files:
+//
+//	<root>/accounts/<directory>/account.json
+//	<root>/accounts/<directory>/account.key
+//	<root>/certificates/<key>/bundle.pem
+//
+// The bundle contains the certificate, its chain and the key to replace
+// all of them with a single rename.
+type Filesystem struct {
+	root string
+}
+
+// NewFilesystem creates a Filesystem storage in the root directory
+func NewFilesystem(root string) (*Filesystem, error) {
+	for _, dir := range []string{"accounts", "certificates"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), dirMode); err != nil {
+			return nil, err
+		}
+	}
+	return &Filesystem{root: root}, nil
+}
+
+// LoadAccount implements Storage
+func (f *Filesystem) LoadAccount(directory string) (*Account, error) {
+	dir := filepath.Join(f.root, "accounts", directoryKey(directory))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, accountFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	account := &Account{}
+	if err := json.Unmarshal(data, account); err != nil {
+		return nil, err
+	}
+
+	keyData, err := ioutil.ReadFile(filepath.Join(dir, accountKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	if account.Key, err = decodeKey(keyData); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// SaveAccount implements Storage
+func (f *Filesystem) SaveAccount(account *Account) error {
+	dir := filepath.Join(f.root, "accounts", directoryKey(account.Directory))
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	keyData, err := encodeKey(account.Key)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(account, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The key is written first, an account file always has a key
+	if err := writeFileAtomic(filepath.Join(dir, accountKeyFile), keyData, privateMode); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, accountFile), data, privateMode)
+}
+
+// LoadCertificate implements Storage
+func (f *Filesystem) LoadCertificate(key string) (*Certificate, error) {
+	dir := filepath.Join(f.root, "certificates", key)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, bundleFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	cert := &Certificate{}
+	if cert.Chain, err = decodeChain(data); err != nil {
+		return nil, err
+	}
+	if cert.Key, err = decodeKey(data); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// SaveCertificate implements Storage
+func (f *Filesystem) SaveCertificate(key string, cert *Certificate) error {
+	dir := filepath.Join(f.root, "certificates", key)
+	if err := os.MkdirAll(dir, dirMode); err != nil {
+		return err
+	}
+
+	keyData, err := encodeKey(cert.Key)
+	if err != nil {
+		return err
+	}
+
+	// Certificate and key are replaced together, a reader never sees a
+	// certificate paired with the key of its predecessor
+	return writeFileAtomic(filepath.Join(dir, bundleFile), append(encodeChain(cert.Chain), keyData...), privateMode)
+}
+
+// DeleteCertificate implements Storage
+func (f *Filesystem) DeleteCertificate(key string) error {
+	return os.RemoveAll(filepath.Join(f.root, "certificates", key))
+}
+
+// ListCertificates implements Storage
+func (f *Filesystem) ListCertificates() ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(f.root, "certificates"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(f.root, "certificates", e.Name(), bundleFile)); err == nil {
+			keys = append(keys, e.Name())
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// writeFileAtomic writes the data to a temporary file in the same
+// directory and renames it to the target afterwards
+func writeFileAtomic(filename string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+type kvAccount struct {
+	Account
+	KeyPEM string `json:"key_pem"`
+}
+
+type kvCertificate struct {
+	ChainPEM string `json:"chain_pem"`
+	KeyPEM   string `json:"key_pem"`
+}
+
+type kvData struct {
+	Accounts     map[string]kvAccount     `json:"accounts"`
+	Certificates map[string]kvCertificate `json:"certificates"`
+}
+
+// KV stores all accounts and certificates in a single JSON document.
+// Access is serialized through an advisory flock on "<path>.lock" so
+// multiple instances on the same host or on a volume supporting flock
+// can share the file. Network filesystems like NFS might not enforce
+// the lock and platforms without flock only serialize access within
+// the process. The document is rewritten on every save which is fine
+// for the few accounts and certificates of a proxy.
+type KV struct {
+	path string
+}
+
+// NewKV creates a KV storage in the given file
+func NewKV(path string) (*KV, error) {
+	if err := os.MkdirAll(filepath.Dir(path), dirMode); err != nil {
+		return nil, err
+	}
+	return &KV{path: path}, nil
+}
+
+// LoadAccount implements Storage
+func (k *KV) LoadAccount(directory string) (*Account, error) {
+	var account *Account
+	err := k.view(func(d *kvData) error {
+		a, ok := d.Accounts[directoryKey(directory)]
+		if !ok {
+			return ErrNotFound
+		}
+		key, err := decodeKey([]byte(a.KeyPEM))
+		if err != nil {
+			return err
+		}
+		account = &a.Account
+		account.Key = key
+		return nil
+	})
+	return account, err
+}
+
+// SaveAccount implements Storage
+func (k *KV) SaveAccount(account *Account) error {
+	keyData, err := encodeKey(account.Key)
+	if err != nil {
+		return err
+	}
+	return k.update(func(d *kvData) error {
+		d.Accounts[directoryKey(account.Directory)] = kvAccount{Account: *account, KeyPEM: string(keyData)}
+		return nil
+	})
+}
+
+// LoadCertificate implements Storage
+func (k *KV) LoadCertificate(key string) (*Certificate, error) {
+	var cert *Certificate
+	err := k.view(func(d *kvData) error {
+		c, ok := d.Certificates[key]
+		if !ok {
+			return ErrNotFound
+		}
+		chain, err := decodeChain([]byte(c.ChainPEM))
+		if err != nil {
+			return err
+		}
+		privKey, err := decodeKey([]byte(c.KeyPEM))
+		if err != nil {
+			return err
+		}
+		cert = &Certificate{Chain: chain, Key: privKey}
+		return nil
+	})
+	return cert, err
+}
+
+// SaveCertificate implements Storage
+func (k *KV) SaveCertificate(key string, cert *Certificate) error {
+	keyData, err := encodeKey(cert.Key)
+	if err != nil {
+		return err
+	}
+	return k.update(func(d *kvData) error {
+		d.Certificates[key] = kvCertificate{ChainPEM: string(encodeChain(cert.Chain)), KeyPEM: string(keyData)}
+		return nil
+	})
+}
+
+// DeleteCertificate implements Storage
+func (k *KV) DeleteCertificate(key string) error {
+	return k.update(func(d *kvData) error {
+		delete(d.Certificates, key)
+		return nil
+	})
+}
+
+// ListCertificates implements Storage
+func (k *KV) ListCertificates() ([]string, error) {
+	keys := []string{}
+	err := k.view(func(d *kvData) error {
+		for key := range d.Certificates {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+func (k *KV) view(fn func(*kvData) error) error {
+	unlock, err := lockFile(k.path+".lock", false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d, err := k.read()
+	if err != nil {
+		return err
+	}
+	return fn(d)
+}
+
+func (k *KV) update(fn func(*kvData) error) error {
+	unlock, err := lockFile(k.path+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	d, err := k.read()
+	if err != nil {
+		return err
+	}
+	if err := fn(d); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(k.path, data, privateMode)
+}
+
+func (k *KV) read() (*kvData, error) {
+	d := &kvData{}
+	data, err := ioutil.ReadFile(k.path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, d); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.Accounts == nil {
+		d.Accounts = make(map[string]kvAccount)
+	}
+	if d.Certificates == nil {
+		d.Certificates = make(map[string]kvCertificate)
+	}
+	return d, nil
+}
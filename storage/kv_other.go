@@ -0,0 +1,30 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package storage
+
+import "sync"
+
+var (
+	fileLocks     = make(map[string]*sync.RWMutex)
+	fileLocksLock sync.Mutex
+)
+
+// lockFile serializes access within the process as flock is not
+// available, instances must not share the file on these platforms
+func lockFile(filename string, exclusive bool) (func(), error) {
+	fileLocksLock.Lock()
+	l, ok := fileLocks[filename]
+	if !ok {
+		l = &sync.RWMutex{}
+		fileLocks[filename] = l
+	}
+	fileLocksLock.Unlock()
+
+	if exclusive {
+		l.Lock()
+		return l.Unlock, nil
+	}
+	l.RLock()
+	return l.RUnlock, nil
+}
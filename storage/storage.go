@@ -0,0 +1,147 @@
+// Package storage persists ACME accounts and certificates
+package storage // import "github.com/Luzifer/dockerproxy/storage"
+
+import (
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ErrNotFound is returned if the requested object is not stored
+var ErrNotFound = errors.New("not found in storage")
+
+// Account is an ACME account registered at a directory
+type Account struct {
+	Directory string        `json:"directory"`
+	URL       string        `json:"url"`
+	Key       crypto.Signer `json:"-"`
+}
+
+// Certificate is an issued certificate with its issuer chain and key
+type Certificate struct {
+	// Chain contains the leaf certificate first
+	Chain []*x509.Certificate
+	Key   crypto.Signer
+}
+
+// Storage stores accounts per ACME directory and certificates by a key
+// chosen by the caller
+type Storage interface {
+	LoadAccount(directory string) (*Account, error)
+	SaveAccount(account *Account) error
+
+	LoadCertificate(key string) (*Certificate, error)
+	SaveCertificate(key string, cert *Certificate) error
+	DeleteCertificate(key string) error
+	ListCertificates() ([]string, error)
+}
+
+// New creates a storage backend by type
+func New(storageType, path string) (Storage, error) {
+	switch storageType {
+	case "filesystem", "":
+		return NewFilesystem(path)
+	case "kv":
+		return NewKV(path)
+	default:
+		return nil, fmt.Errorf("Unknown storage type %q", storageType)
+	}
+}
+
+var unsafeChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// CertificateKey builds a readable storage key for a set of names and
+// the type of the certificate key
+func CertificateKey(names []string, keyType string) string {
+	first := strings.Replace(names[0], "*", "_wildcard", -1)
+	sum := sha1.Sum([]byte(strings.Join(names, "::")))
+	return fmt.Sprintf("%s-%x-%s", unsafeChars.ReplaceAllString(first, "_"), sum[:4], strings.ToLower(unsafeChars.ReplaceAllString(keyType, "_")))
+}
+
+// directoryKey builds the storage key for accounts of a directory
+func directoryKey(directory string) string {
+	if u, err := url.Parse(directory); err == nil && u.Host != "" {
+		directory = u.Host + u.Path
+	}
+	return strings.Trim(unsafeChars.ReplaceAllString(directory, "_"), "_")
+}
+
+func encodeKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func decodeKey(data []byte) (crypto.Signer, error) {
+	var block *pem.Block
+	for {
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("No PEM data found for key")
+		}
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			break
+		}
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+func encodeChain(chain []*x509.Certificate) []byte {
+	var data []byte
+	for _, c := range chain {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return data
+}
+
+func decodeChain(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, c)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("No certificates found")
+	}
+	return chain, nil
+}
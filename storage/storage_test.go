@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) *Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com", "*.example.com"},
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Certificate{Chain: []*x509.Certificate{c, c}, Key: key}
+}
+
+func testStorage(t *testing.T, s Storage) {
+	if _, err := s.LoadAccount("https://acme.example.com/directory"); err != ErrNotFound {
+		t.Errorf("Missing account returned %v", err)
+	}
+
+	accountKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveAccount(&Account{Directory: "https://acme.example.com/directory", URL: "https://acme.example.com/acct/1", Key: accountKey}); err != nil {
+		t.Fatalf("Unable to save account: %s", err)
+	}
+	account, err := s.LoadAccount("https://acme.example.com/directory")
+	if err != nil {
+		t.Fatalf("Unable to load account: %s", err)
+	}
+	if account.URL != "https://acme.example.com/acct/1" || !accountKey.Equal(account.Key) {
+		t.Errorf("Account changed in storage: %#v", account)
+	}
+
+	cert := testCertificate(t)
+	key := CertificateKey(cert.Chain[0].DNSNames, "P-256")
+	if err := s.SaveCertificate(key, cert); err != nil {
+		t.Fatalf("Unable to save certificate: %s", err)
+	}
+	loaded, err := s.LoadCertificate(key)
+	if err != nil {
+		t.Fatalf("Unable to load certificate: %s", err)
+	}
+	if len(loaded.Chain) != 2 || !loaded.Chain[0].Equal(cert.Chain[0]) || !cert.Key.(*ecdsa.PrivateKey).Equal(loaded.Key) {
+		t.Errorf("Certificate changed in storage")
+	}
+
+	if keys, err := s.ListCertificates(); err != nil || len(keys) != 1 || keys[0] != key {
+		t.Errorf("Unexpected certificate list: %v (%v)", keys, err)
+	}
+	if err := s.DeleteCertificate(key); err != nil {
+		t.Fatalf("Unable to delete certificate: %s", err)
+	}
+	if _, err := s.LoadCertificate(key); err != ErrNotFound {
+		t.Errorf("Deleted certificate returned %v", err)
+	}
+}
+
+func TestFilesystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewFilesystem(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	cert := testCertificate(t)
+	if err := s.SaveCertificate("example.com", cert); err != nil {
+		t.Fatal(err)
+	}
+	for file, mode := range map[string]os.FileMode{
+		"store":                          0700,
+		"store/certificates/example.com": 0700,
+		"store/certificates/example.com/bundle.pem":             0600,
+		"store/accounts/acme.example.com_directory/account.key": 0600,
+	} {
+		fi, err := os.Stat(filepath.Join(dir, file))
+		if err != nil {
+			t.Errorf("Unable to stat %s: %s", file, err)
+			continue
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("File %s has mode %s, expected %s", file, fi.Mode().Perm(), mode)
+		}
+	}
+}
+
+func TestKV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewKV(filepath.Join(dir, "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	// A second instance on the same file sees the written data
+	cert := testCertificate(t)
+	if err := s.SaveCertificate("example.com", cert); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewKV(filepath.Join(dir, "store.json"))
+	if _, err := other.LoadCertificate("example.com"); err != nil {
+		t.Errorf("Certificate not visible to second instance: %s", err)
+	}
+}
+
+func TestCertificateKey(t *testing.T) {
+	if k := CertificateKey([]string{"*.example.com", "example.com"}, "RSA-2048"); k != "_wildcard.example.com-8ab32c86-rsa-2048" {
+		t.Errorf("Unexpected key %q", k)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/acme"
+	"github.com/Luzifer/dockerproxy/storage"
+)
+
+func TestMigrateLegacyCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerproxy")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "b.example.com"},
+		DNSNames:     []string{"b.example.com", "a.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	// Layout of the cache written by the ACME v1 client
+	type letsEncryptClientCertificateCache struct {
+		Certificate *x509.Certificate
+		Key         *rsa.PrivateKey
+	}
+	type letsEncryptClientCache struct {
+		AccountKey   *rsa.PrivateKey
+		Certificates map[string]letsEncryptClientCertificateCache
+	}
+
+	cacheFile := path.Join(dir, "dockerproxy.lecache")
+	f, err := os.Create(cacheFile)
+	if err != nil {
+		t.Fatalf("Unable to create cache file: %s", err)
+	}
+	if err := gob.NewEncoder(f).Encode(letsEncryptClientCache{
+		AccountKey: key,
+		Certificates: map[string]letsEncryptClientCertificateCache{
+			"hash": {Certificate: cert, Key: key},
+		},
+	}); err != nil {
+		t.Fatalf("Unable to write cache file: %s", err)
+	}
+	f.Close()
+
+	store, err := storage.NewFilesystem(path.Join(dir, "store"))
+	if err != nil {
+		t.Fatalf("Unable to create storage: %s", err)
+	}
+	if err := migrateLegacyCache(cacheFile, "https://acme.example.com/directory", store); err != nil {
+		t.Fatalf("Unable to migrate cache: %s", err)
+	}
+
+	migrated, err := store.LoadCertificate(storage.CertificateKey([]string{"a.example.com", "b.example.com"}, string(acme.KeyRSA4096)))
+	if err != nil {
+		t.Fatalf("Certificate was not migrated: %s", err)
+	}
+	if len(migrated.Chain) != 1 || !migrated.Chain[0].Equal(cert) || !key.Equal(migrated.Key) {
+		t.Errorf("Migrated certificate differs")
+	}
+
+	if account, err := store.LoadAccount("https://acme.example.com/directory"); err != nil || !key.Equal(account.Key) {
+		t.Errorf("Account was not migrated: %v", err)
+	}
+	if _, err := os.Stat(cacheFile + ".migrated"); err != nil {
+		t.Errorf("Cache file was not renamed: %s", err)
+	}
+}
@@ -0,0 +1,236 @@
+package tunnel
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotSupported is returned if the client connection cannot be taken
+// over like HTTP/2 streams
+var ErrNotSupported = errors.New("Connection does not support protocol upgrades")
+
+// Config describes the handling of upgraded connections
+type Config struct {
+	// IdleTimeout closes the tunnel if no data was sent in either
+	// direction for this duration
+	IdleTimeout time.Duration `json:"idle_timeout" yaml:"idle_timeout"`
+}
+
+// WithDefaults fills all unset values with their defaults
+func (c Config) WithDefaults() Config {
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = 5 * time.Minute
+	}
+	return c
+}
+
+// Result describes a finished upgrade request
+type Result struct {
+	// StatusCode is the status sent by the backend
+	StatusCode int
+	// Upgraded is set if the backend switched protocols
+	Upgraded bool
+	// BytesIn and BytesOut count the data sent by the client and the
+	// backend, for requests not upgraded BytesOut is the body size
+	BytesIn  int64
+	BytesOut int64
+}
+
+// Tunnel passes Upgrade requests like WebSocket handshakes to a backend
+// and copies the data of the switched protocol in both directions
+type Tunnel struct {
+	Config Config
+	// Dial opens the connection to the backend, defaults to a TCP dial
+	Dial func(network, address string) (net.Conn, error)
+	// OnOpen and OnClose are called when the backend switched
+	// protocols and when the tunnel is closed again
+	OnOpen  func()
+	OnClose func()
+}
+
+// IsUpgrade reports whether the client asks to switch protocols
+func IsUpgrade(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != "" && headerContainsToken(req.Header, "Connection", "upgrade")
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, value := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hopHeaders are only valid for a single connection and are not passed
+// to the backend
+var hopHeaders = []string{
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+}
+
+// Serve sends the Upgrade request to the backend at address. If the
+// backend switches protocols the client connection is hijacked and
+// data is copied until one side closes the connection or the tunnel is
+// idle, otherwise the response is passed to the client. An error is
+// returned if the backend could not be reached, nothing has been sent
+// to the client in that case.
+func (t Tunnel) Serve(w http.ResponseWriter, req *http.Request, address string) (Result, error) {
+	cfg := t.Config.WithDefaults()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return Result{}, ErrNotSupported
+	}
+
+	dial := t.Dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).Dial
+	}
+	backendConn, err := dial("tcp", address)
+	if err != nil {
+		return Result{}, err
+	}
+	defer backendConn.Close()
+
+	upgrade := req.Header.Get("Upgrade")
+	outreq := req.Clone(req.Context())
+	outreq.RequestURI = ""
+	outreq.URL.Host = address
+	for _, h := range strings.Split(req.Header.Get("Connection"), ",") {
+		outreq.Header.Del(strings.TrimSpace(h))
+	}
+	for _, h := range hopHeaders {
+		outreq.Header.Del(h)
+	}
+	outreq.Header.Set("Connection", "Upgrade")
+	outreq.Header.Set("Upgrade", upgrade)
+
+	backendConn.SetDeadline(time.Now().Add(cfg.IdleTimeout))
+	if err := outreq.Write(backendConn); err != nil {
+		return Result{}, err
+	}
+	br := bufio.NewReader(backendConn)
+	resp, err := http.ReadResponse(br, outreq)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		n, _ := io.Copy(w, resp.Body)
+		return Result{StatusCode: resp.StatusCode, BytesOut: n}, nil
+	}
+
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), upgrade) {
+		http.Error(w, "Backend switched to an unexpected protocol", http.StatusBadGateway)
+		return Result{StatusCode: http.StatusBadGateway}, nil
+	}
+
+	clientConn, brw, err := hj.Hijack()
+	if err != nil {
+		http.Error(w, "Unable to switch protocols", http.StatusInternalServerError)
+		return Result{StatusCode: http.StatusInternalServerError}, nil
+	}
+	defer clientConn.Close()
+
+	if t.OnOpen != nil {
+		t.OnOpen()
+	}
+	if t.OnClose != nil {
+		defer t.OnClose()
+	}
+
+	clientConn.SetDeadline(time.Now().Add(cfg.IdleTimeout))
+	fmt.Fprintf(brw, "HTTP/1.1 %s\r\n", resp.Status)
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		return Result{StatusCode: resp.StatusCode}, nil
+	}
+
+	res := Result{StatusCode: resp.StatusCode, Upgraded: true}
+	s := &session{idle: cfg.IdleTimeout}
+	s.touch()
+
+	// Buffered data of both readers must be passed on, so the copies
+	// read from the buffers instead of the connections
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		res.BytesIn = s.copy(backendConn, clientConn, brw.Reader)
+		backendConn.Close()
+		clientConn.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		res.BytesOut = s.copy(clientConn, backendConn, br)
+		backendConn.Close()
+		clientConn.Close()
+	}()
+	wg.Wait()
+
+	return res, nil
+}
+
+// session tracks the last activity of a tunnel in both directions
+type session struct {
+	idle time.Duration
+	last int64
+}
+
+func (s *session) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *session) idleFor() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.last)))
+}
+
+// copy moves data from src (reading from srcConn) to dst until an error
+// occurs or the tunnel was idle in both directions
+func (s *session) copy(dst, srcConn net.Conn, src io.Reader) int64 {
+	var (
+		buf     = make([]byte, 32*1024)
+		written int64
+	)
+	for {
+		srcConn.SetReadDeadline(time.Now().Add(s.idle))
+		n, err := src.Read(buf)
+		if n > 0 {
+			s.touch()
+			dst.SetWriteDeadline(time.Now().Add(s.idle))
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return written
+			}
+			written += int64(n)
+		}
+		if err != nil {
+			// Data sent in the other direction keeps the tunnel open
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && s.idleFor() < s.idle {
+				continue
+			}
+			return written
+		}
+	}
+}
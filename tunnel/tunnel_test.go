@@ -0,0 +1,138 @@
+package tunnel
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// echoBackend switches to the "echo" protocol and returns every line
+func echoBackend(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Header.Get("Proxy-Authorization") != "" {
+			t.Errorf("Hop-by-hop header was passed to the backend")
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Unable to hijack backend connection: %s", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString(line)
+			brw.Flush()
+		}
+	}))
+}
+
+func dialUpgrade(t *testing.T, addr, protocol string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Unable to connect to proxy: %s", err)
+	}
+	req, _ := http.NewRequest("GET", "http://"+addr+"/socket", nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", protocol)
+	req.Header.Set("Proxy-Authorization", "secret")
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("Unable to send request: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("Unable to read response: %s", err)
+	}
+	return conn, br, resp
+}
+
+func TestIsUpgrade(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"Upgrade":             true,
+		"keep-alive, upgrade": true,
+		"keep-alive":          false,
+		"":                    false,
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Connection", header)
+		req.Header.Set("Upgrade", "websocket")
+		if IsUpgrade(req) != expected {
+			t.Errorf("Connection header %q: expected upgrade %v", header, expected)
+		}
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	backend := echoBackend(t)
+	defer backend.Close()
+
+	var open, closed int32
+	tun := Tunnel{
+		Config:  Config{IdleTimeout: 200 * time.Millisecond},
+		OnOpen:  func() { atomic.AddInt32(&open, 1) },
+		OnClose: func() { atomic.AddInt32(&closed, 1) },
+	}
+	results := make(chan Result, 2)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res, err := tun.Serve(w, r, strings.TrimPrefix(backend.URL, "http://"))
+		if err != nil {
+			t.Errorf("Tunnel failed: %s", err)
+		}
+		results <- res
+	}))
+	defer proxy.Close()
+	addr := strings.TrimPrefix(proxy.URL, "http://")
+
+	conn, br, resp := dialUpgrade(t, addr, "echo")
+	defer conn.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Unexpected status %d", resp.StatusCode)
+	}
+
+	io.WriteString(conn, "hello\n")
+	if line, err := br.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Errorf("Unexpected echo %q (%v)", line, err)
+	}
+	if atomic.LoadInt32(&open) != 1 {
+		t.Errorf("OnOpen was not called")
+	}
+
+	// The idle tunnel is closed by the proxy
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("Expected idle tunnel to be closed, got %v", err)
+	}
+
+	res := <-results
+	if !res.Upgraded || res.BytesIn != 6 || res.BytesOut != 6 {
+		t.Errorf("Unexpected result %#v", res)
+	}
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Errorf("OnClose was not called")
+	}
+
+	// Responses not switching protocols are passed to the client
+	conn, _, resp = dialUpgrade(t, addr, "other")
+	conn.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Unexpected status %d", resp.StatusCode)
+	}
+	if res := <-results; res.Upgraded || res.StatusCode != http.StatusForbidden {
+		t.Errorf("Unexpected result %#v", res)
+	}
+}
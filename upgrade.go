@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Luzifer/dockerproxy/balancer"
+	"github.com/Luzifer/dockerproxy/reverseproxy"
+	"github.com/Luzifer/dockerproxy/tunnel"
+)
+
+// serveUpgrade passes Upgrade requests like WebSocket handshakes to the
+// backend chosen in shieldOwnHosts and tunnels the connection after the
+// backend switched protocols
+func (d *dockerProxy) serveUpgrade(w http.ResponseWriter, req *http.Request, plan *upstreamPlan, backend balancer.Backend) {
+	config := proxyConfiguration()
+	start := time.Now()
+	out := req.Clone(req.Context())
+	reverseproxy.AddForwardedHeaders(out, req)
+	applyRoute(out)
+
+	t := tunnel.Tunnel{
+		Config: config.Upgrade,
+		OnOpen: func() {
+			upgradeConnections.WithLabelValues(plan.slug).Inc()
+			upgradeConnectionsTotal.WithLabelValues(plan.slug).Inc()
+		},
+		OnClose: func() {
+			upgradeConnections.WithLabelValues(plan.slug).Dec()
+		},
+	}
+
+	res, err := t.Serve(w, out, backend.Address)
+	if err == tunnel.ErrNotSupported {
+		http.Error(w, "Protocol upgrades are not supported on this connection", http.StatusNotImplemented)
+		d.logRequest(req, http.StatusNotImplemented, 0, start)
+		return
+	}
+	if err != nil {
+		outlierCFG := config.OutlierDetection.WithDefaults()
+		if outliers.ReportFailure(plan.slug, backend.Address, outlierCFG) {
+			log.Printf("[Outlier] Ejecting %s (%s) for %s", backend.Address, plan.slug, outlierCFG.EjectionTime)
+			backendEjections.WithLabelValues(plan.slug).Inc()
+		}
+		log.Printf("[Upgrade] Unable to connect to %s (%s): %s", backend.Address, plan.slug, err)
+		http.Error(w, "This host is currently not available", http.StatusBadGateway)
+		d.logRequest(req, http.StatusBadGateway, 0, start)
+		return
+	}
+	outliers.ReportSuccess(plan.slug, backend.Address)
+
+	d.logRequest(req, res.StatusCode, res.BytesOut, start)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Luzifer/dockerproxy/discovery"
+)
+
+const (
+	upstreamHTTP1 = "h1"
+	upstreamH2C   = "h2c"
+
+	// upstreamProtocolLabel sets the protocol spoken to a container and
+	// takes precedence over the upstreams configuration of its slug
+	upstreamProtocolLabel = "io.luzifer.dockerproxy.upstream.protocol"
+)
+
+type upstreamConfig struct {
+	// Protocol spoken to the containers: h1 (Default) or h2c
+	Protocol string `json:"protocol" yaml:"protocol"`
+}
+
+func validateUpstreamProtocol(protocol string) error {
+	switch protocol {
+	case "", upstreamHTTP1, upstreamH2C:
+		return nil
+	default:
+		return fmt.Errorf("Unknown upstream protocol %q", protocol)
+	}
+}
+
+// upstreamProtocols returns the protocol to use per backend address
+func upstreamProtocols(slug string, backends []discovery.Backend) map[string]string {
+	protocols := make(map[string]string)
+	for _, backend := range backends {
+		protocol := proxyConfiguration().Upstreams[slug].Protocol
+		if p, ok := backend.Labels[upstreamProtocolLabel]; ok {
+			if err := validateUpstreamProtocol(p); err == nil {
+				protocol = p
+			}
+		}
+		protocols[backend.Address] = protocol
+	}
+	return protocols
+}